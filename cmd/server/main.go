@@ -1,15 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/fileserver"
+	"github.com/brutally-Honest/http-server/internal/middleware"
+	"github.com/brutally-Honest/http-server/internal/proxy"
 	"github.com/brutally-Honest/http-server/internal/request"
 	"github.com/brutally-Honest/http-server/internal/response"
 	"github.com/brutally-Honest/http-server/internal/router"
 	"github.com/brutally-Honest/http-server/internal/server"
+	"github.com/brutally-Honest/http-server/internal/sse"
+	"github.com/brutally-Honest/http-server/internal/websocket"
 )
 
 const (
@@ -18,6 +31,8 @@ const (
 	MaxHeaderSize     = 8 * 1024
 	ReadTimeout       = time.Second * 10
 	WriteTimeout      = time.Second * 10
+	HandlerTimeout    = time.Second * 30
+	ShutdownTimeout   = time.Second * 15
 )
 
 func main() {
@@ -28,59 +43,236 @@ func main() {
 		ReadTimeout,
 		WriteTimeout,
 	)
+	cfg.MetricsPath = "/metrics"
+	cfg.AdminAddr = "127.0.0.1:1784"
+	cfg.HandlerTimeout = HandlerTimeout
+	cfg.H2C = true
+
+	// PROXY_PROTOCOL lists the load balancers, as addresses or CIDRs, whose
+	// connections start with a PROXY protocol header
+	if trusted := os.Getenv("PROXY_PROTOCOL"); trusted != "" {
+		nets, err := config.ParseCIDRs(trusted)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.ProxyProtocol = true
+		cfg.ProxyTrusted = nets
+	}
+	// TRUSTED_PROXIES lists the reverse proxies whose X-Forwarded-*
+	// headers are believed, or their Forwarded header with TRUST_FORWARDED
+	if trusted := os.Getenv("TRUSTED_PROXIES"); trusted != "" {
+		nets, err := config.ParseCIDRs(trusted)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.TrustedProxies = nets
+		cfg.TrustForwarded = os.Getenv("TRUST_FORWARDED") != ""
+	}
 
 	r := router.NewRouter()
 	r.GET("/api/static", func(req *request.Request, res *response.Response) {
-		res.Write([]byte("WOHOO !!! It is working"))
-		res.Flush(req, false)
+		res.WriteString("WOHOO !!! It is working")
 	})
 	r.GET("/api/param/:id", func(req *request.Request, res *response.Response) {
 		id := req.Params["id"]
-		output := fmt.Sprintf("Id %s", id)
-		res.Write([]byte(output))
-		res.Flush(req, false)
+		fmt.Fprintf(res, "Id %s", id)
 	})
 	r.GET("/api/param/:id/profile/:name", func(req *request.Request, res *response.Response) {
 		id := req.Params["id"]
 		name := req.Params["name"]
 
-		output := fmt.Sprintf("Id %s Name %s", id, name)
-		res.Write([]byte(output))
-		res.Flush(req, false)
+		fmt.Fprintf(res, "Id %s Name %s", id, name)
 	})
 	r.GET("/api/wildcard/*anything", func(req *request.Request, res *response.Response) {
 		wildcard := req.Params["anything"]
 
-		output := fmt.Sprintf("wild path %s", wildcard)
-		res.Write([]byte(output))
-		res.Flush(req, false)
+		fmt.Fprintf(res, "wild path %s", wildcard)
 	})
+	// outgrows the response buffer, so it switches to streaming on its own
+	r.GET("/api/lines", func(req *request.Request, res *response.Response) {
+		for i := 1; i <= 20000; i++ {
+			if _, err := fmt.Fprintf(res, "line %d\n", i); err != nil {
+				return
+			}
+		}
+	})
+	static := fileserver.New(staticRoot(), "path")
+	static.ListDirectories = true
+	for _, route := range []string{"/static", "/static/*path"} {
+		r.GET(route, static.Serve)
+		r.HEAD(route, static.Serve)
+	}
 	r.POST("/api/wake-up", func(req *request.Request, res *response.Response) {
-		log.Print(string(req.Body))
+		req.Logger.Info("wake-up received", "body_bytes", len(req.Body))
 		//simulating something created
 		res.WriteHeader(201)
-		res.Flush(req, false)
 	})
 
 	r.GET("/stream", func(req *request.Request, res *response.Response) {
 		res.SetHeader("Content-Type", "text/plain")
 		res.SetHeader("Transfer-Encoding", "chunked")
+		res.SetHeader("Trailer", "X-Checksum")
 
 		chunks := []string{"Testing\n", "Transfer\n", "Encoding\n", "With\n", "HTTP\n", "1.1\n"}
+		checksum := crc32.NewIEEE()
 
 		for _, chunk := range chunks {
 			if err := res.WriteChunk([]byte(chunk)); err != nil {
-				log.Printf("WriteChunk failed: %v", err)
+				req.Logger.Error("WriteChunk failed", "error", err)
 				return
 			}
+			checksum.Write([]byte(chunk))
 		}
 
+		res.SetTrailer("X-Checksum", fmt.Sprintf("crc32=%08x", checksum.Sum32()))
 		if err := res.EndChunked(); err != nil {
-			log.Printf("EndChunked failed: %v", err)
+			req.Logger.Error("EndChunked failed", "error", err)
 			return
 		}
 	})
 
-	s := server.NewServer(":1783", cfg, r)
-	log.Fatal(s.ListenAndServe())
+	// the simulated work outlasts its two second budget, so this answers 504
+	r.GET("/api/slow", router.Timeout(2*time.Second, 504, []byte("took too long"))(func(req *request.Request, res *response.Response) {
+		select {
+		case <-time.After(3 * time.Second):
+			res.WriteString("done")
+		case <-req.Context.Done():
+		}
+	}))
+
+	// the stream runs until the client leaves, so lift the handler timeout
+	r.GET("/events", router.WithTimeout(0, func(req *request.Request, res *response.Response) {
+		events, err := sse.NewWriter(req, res, sse.DefaultHeartbeat)
+		if err != nil {
+			res.WriteHeader(400)
+			res.WriteString(err.Error())
+			return
+		}
+		defer events.Close()
+
+		// resume the counter where a reconnecting client left off
+		n, _ := strconv.Atoi(events.LastEventID())
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-events.Done():
+				return
+			case t := <-ticker.C:
+				n++
+				err := events.Send(sse.Event{
+					ID:    strconv.Itoa(n),
+					Event: "tick",
+					Data:  t.Format(time.RFC3339),
+				})
+				if err != nil {
+					return
+				}
+			}
+		}
+	}))
+
+	// echoes every message back until the client closes
+	r.GET("/ws", func(req *request.Request, res *response.Response) {
+		conn, err := websocket.Upgrade(req, res, &websocket.Options{EnableCompression: true})
+		if err != nil {
+			req.Logger.Debug("websocket upgrade failed", "error", err)
+			return
+		}
+
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				req.Logger.Debug("websocket closed", "error", err)
+				return
+			}
+			if err := conn.WriteMessage(typ, msg); err != nil {
+				conn.Close(websocket.CloseInternalError, "")
+				return
+			}
+		}
+	})
+
+	// every subdomain of localhost is a tenant with a site of its own;
+	// STRICT_HOSTS answers hosts other than these and localhost with 421
+	tenants := router.NewRouter()
+	tenants.GET("/", func(req *request.Request, res *response.Response) {
+		fmt.Fprintf(res, "Tenant %s", req.Params["tenant"])
+	})
+	hosts := router.NewHostRouter(r)
+	hosts.Strict = os.Getenv("STRICT_HOSTS") != ""
+	hosts.Host("localhost", r)
+	hosts.Host("127.0.0.1", r)
+	hosts.Host(":tenant.localhost", tenants)
+
+	s := server.NewServer(":1783", cfg, hosts)
+
+	// background work such as health checks stops when shutdown begins
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// UPSTREAM_URL puts legacy backends behind /legacy: a comma-separated
+	// list is balanced by least connections, and UPSTREAM_HEALTH names the
+	// path their health is checked on
+	if upstream := os.Getenv("UPSTREAM_URL"); upstream != "" {
+		backends, err := proxy.NewUpstream(proxy.LeastConnections(), strings.Split(upstream, ",")...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		legacy := &proxy.ReverseProxy{Upstream: backends, Logger: s.Logger.With("proxy", "legacy")}
+		if path := os.Getenv("UPSTREAM_HEALTH"); path != "" {
+			legacy.StartHealthChecks(background, proxy.HealthCheck{Path: path})
+		}
+		// the proxy bounds its own waits on the backend with its Timeout;
+		// HandlerTimeout would cut off long downloads and tunnels
+		serve := router.WithTimeout(0, legacy.Serve)
+		for _, route := range []string{"/legacy", "/legacy/*path"} {
+			for _, method := range []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"} {
+				r.Register(method, route, serve)
+			}
+		}
+	}
+
+	s.Use(
+		middleware.RequestID(),
+		middleware.AccessLog(os.Stdout, middleware.CombinedFormat),
+		middleware.Compress(middleware.DefaultCompressMinSize),
+		middleware.Decompress(),
+	)
+
+	// finish what is in flight on SIGINT or SIGTERM before exiting
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+		stopBackground()
+
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
+
+	// TLS_CERT and TLS_KEY switch the listener to TLS, with HTTP/2 via ALPN
+	var err error
+	if cert, key := os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY"); cert != "" && key != "" {
+		err = s.ListenAndServeTLS(cert, key)
+	} else {
+		err = s.ListenAndServe()
+	}
+	if !errors.Is(err, server.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-stopped
+}
+
+func staticRoot() string {
+	if root := os.Getenv("STATIC_ROOT"); root != "" {
+		return root
+	}
+	return "./public"
 }
@@ -0,0 +1,271 @@
+// Package client is an HTTP/1.1 client for integration tests, health
+// checks and calls to other services. It reads response heads and chunked
+// bodies with the same code the server parses requests with.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout applies when Client.Timeout is unset.
+	DefaultTimeout = 30 * time.Second
+	// DefaultDialTimeout applies when Client.DialTimeout is unset.
+	DefaultDialTimeout = 5 * time.Second
+	// DefaultMaxIdleConnsPerHost is how many keep-alive connections are
+	// kept for each host when Client.MaxIdleConnsPerHost is unset.
+	DefaultMaxIdleConnsPerHost = 16
+	// DefaultIdleConnTimeout is how long an unused connection is kept when
+	// Client.IdleConnTimeout is unset.
+	DefaultIdleConnTimeout = 90 * time.Second
+	// DefaultMaxRedirects applies when Client.MaxRedirects is zero.
+	DefaultMaxRedirects = 10
+
+	// maxResponseHead bounds the status line and headers of a response,
+	// and each chunk size line and trailer section.
+	maxResponseHead = 64 * 1024
+	readBufferSize  = 32 * 1024
+)
+
+// ErrTooManyRedirects is returned by Do when a redirect chain runs past
+// MaxRedirects.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// Client sends requests over pooled keep-alive connections, one pool per
+// scheme and host. The zero value is ready to use and safe for concurrent
+// use.
+type Client struct {
+	// TLSConfig is used for https URLs; nil uses the defaults.
+	TLSConfig *tls.Config
+	// Timeout bounds the wait for the response head and for each read of
+	// the body; the Default constants above apply to unset fields.
+	Timeout             time.Duration
+	DialTimeout         time.Duration
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	// MaxRedirects is how many redirects Do follows; zero uses
+	// DefaultMaxRedirects and a negative value returns redirects as they
+	// are.
+	MaxRedirects int
+
+	mu    sync.Mutex
+	pools map[string]*pool
+}
+
+// Get fetches rawURL.
+func (c *Client) Get(ctx context.Context, rawURL string) (*Response, error) {
+	req, err := NewRequest(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post sends body to rawURL with the given Content-Type.
+func (c *Client) Post(ctx context.Context, rawURL, contentType string, body []byte) (*Response, error) {
+	req, err := NewRequest(ctx, "POST", rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Headers["content-type"] = contentType
+	return c.Do(req)
+}
+
+// Do sends req and returns the response once its head has arrived,
+// following redirects. The caller must read the body to the end or close
+// it; only then can the connection carry another request.
+//
+// A request sent on a pooled connection that the server closed while it
+// sat idle is sent again on another, if its method is idempotent.
+func (c *Client) Do(req *Request) (*Response, error) {
+	for redirects := 0; ; redirects++ {
+		res, err := c.roundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		if c.MaxRedirects < 0 {
+			return res, nil
+		}
+		next := redirect(req, res)
+		if next == nil {
+			return res, nil
+		}
+		res.Body.Close()
+		if redirects == intOr(c.MaxRedirects, DefaultMaxRedirects) {
+			return nil, ErrTooManyRedirects
+		}
+		req = next
+	}
+}
+
+// CloseIdleConnections closes the pooled connections of every host.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.pools {
+		p.closeIdle()
+	}
+}
+
+func (c *Client) roundTrip(req *Request) (*Response, error) {
+	if req.URL == nil || req.URL.Host == "" {
+		return nil, errors.New("client: request URL has no host")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, errors.New("client: unsupported scheme " + req.URL.Scheme)
+	}
+
+	ctx := req.context()
+	p := c.pool(req.URL)
+	for {
+		cc, err := p.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := cc.roundTrip(ctx, req, durationOr(c.Timeout, DefaultTimeout))
+		if err == nil {
+			return res, nil
+		}
+		cc.Close()
+		if cc.reused && errors.Is(err, ErrNoResponse) && idempotent(req.Method) {
+			continue
+		}
+		return nil, err
+	}
+}
+
+// pool returns the pool of u's scheme and host, setting it up on first
+// use.
+func (c *Client) pool(u *url.URL) *pool {
+	addr := hostPort(u)
+	key := u.Scheme + "://" + addr
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.pools[key]; ok {
+		return p
+	}
+	if c.pools == nil {
+		c.pools = make(map[string]*pool)
+	}
+	p := &pool{
+		addr:        addr,
+		tls:         c.tlsConfig(u),
+		dialTimeout: durationOr(c.DialTimeout, DefaultDialTimeout),
+		maxIdle:     intOr(c.MaxIdleConnsPerHost, DefaultMaxIdleConnsPerHost),
+		idleTimeout: durationOr(c.IdleConnTimeout, DefaultIdleConnTimeout),
+	}
+	c.pools[key] = p
+	return p
+}
+
+func (c *Client) tlsConfig(u *url.URL) *tls.Config {
+	if u.Scheme != "https" {
+		return nil
+	}
+	cfg := &tls.Config{}
+	if c.TLSConfig != nil {
+		cfg = c.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+	// this client speaks HTTP/1.1 only
+	cfg.NextProtos = []string{"http/1.1"}
+	return cfg
+}
+
+// redirect builds the request that follows a redirect response, or
+// returns nil if res is not one that can be followed.
+func redirect(req *Request, res *Response) *Request {
+	method, body := req.Method, req.Body
+	switch res.StatusCode {
+	case 301, 302, 303:
+		// browsers turn these into a GET; RFC 9110 section 15.4 allows it
+		if method != "HEAD" {
+			method, body = "GET", nil
+		}
+	case 307, 308:
+	default:
+		return nil
+	}
+
+	loc := res.Headers["location"]
+	if loc == "" {
+		return nil
+	}
+	u, err := req.URL.Parse(loc)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+
+	next := &Request{
+		Method:  method,
+		URL:     u,
+		Headers: make(map[string]string, len(req.Headers)),
+		Body:    body,
+		Context: req.Context,
+	}
+	for k, v := range req.Headers {
+		switch {
+		case k == "host":
+			continue
+		case body == nil && k == "content-type":
+			continue
+		case u.Host != req.URL.Host && (k == "authorization" || k == "cookie"):
+			// credentials stay with the host they were meant for
+			continue
+		}
+		next.Headers[k] = v
+	}
+	return next
+}
+
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+func intOr(n, def int) int {
+	if n > 0 {
+		return n
+	}
+	return def
+}
+
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DialError means no connection could be made, so the request was not
+// sent anywhere.
+type DialError struct {
+	Err error
+}
+
+func (e *DialError) Error() string { return "dial: " + e.Err.Error() }
+func (e *DialError) Unwrap() error { return e.Err }
+
+// pool keeps idle keep-alive connections to one address.
+type pool struct {
+	addr        string
+	tls         *tls.Config
+	dialTimeout time.Duration
+	maxIdle     int
+	idleTimeout time.Duration
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+type conn struct {
+	net.Conn
+	br        *bufio.Reader
+	pool      *pool
+	reused    bool
+	idleSince time.Time
+}
+
+// get hands out the most recently used idle connection that is still open,
+// or dials a new one.
+func (p *pool) get(ctx context.Context) (*conn, error) {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			return p.dial(ctx)
+		}
+		cc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if time.Since(cc.idleSince) > p.idleTimeout || !cc.alive() {
+			cc.Close()
+			continue
+		}
+		cc.reused = true
+		return cc, nil
+	}
+}
+
+// put returns a connection whose response was read in full.
+func (p *pool) put(cc *conn) {
+	cc.SetDeadline(time.Time{})
+	cc.idleSince = time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) >= p.maxIdle {
+		cc.Close()
+		return
+	}
+	p.idle = append(p.idle, cc)
+}
+
+func (p *pool) closeIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, cc := range idle {
+		cc.Close()
+	}
+}
+
+func (p *pool) dial(ctx context.Context) (*conn, error) {
+	dialer := &net.Dialer{Timeout: p.dialTimeout}
+	var c net.Conn
+	var err error
+	if p.tls != nil {
+		c, err = (&tls.Dialer{NetDialer: dialer, Config: p.tls}).DialContext(ctx, "tcp", p.addr)
+	} else {
+		c, err = dialer.DialContext(ctx, "tcp", p.addr)
+	}
+	if err != nil {
+		return nil, &DialError{err}
+	}
+	return &conn{Conn: c, br: bufio.NewReaderSize(c, readBufferSize), pool: p}, nil
+}
+
+// alive peeks at an idle connection without blocking: anything but a
+// timeout means the server closed it or sent something unasked.
+func (cc *conn) alive() bool {
+	cc.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := cc.br.Peek(1)
+	cc.SetReadDeadline(time.Time{})
+
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// roundTrip writes the request and reads the head of the response.
+// Cancelling ctx closes the connection until the body has been read.
+func (cc *conn) roundTrip(ctx context.Context, req *Request, timeout time.Duration) (*Response, error) {
+	stop := context.AfterFunc(ctx, func() { cc.Close() })
+
+	cc.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := cc.Write(append(req.appendHead(nil), req.Body...)); err != nil {
+		stop()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if cc.reused {
+			return nil, fmt.Errorf("%w: %v", ErrNoResponse, err)
+		}
+		return nil, err
+	}
+
+	cc.SetReadDeadline(time.Now().Add(timeout))
+	res, r, err := readResponse(cc.br, req.Method)
+	if err != nil {
+		stop()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	res.Request = req
+
+	if res.StatusCode == 101 {
+		// the connection now belongs to the caller
+		stop()
+		cc.SetDeadline(time.Time{})
+		res.Body = upgraded{cc}
+		return res, nil
+	}
+	if hasToken(req.Headers["connection"], "close") {
+		res.keepAlive = false
+	}
+
+	b := &body{r: r, res: res, cc: cc, ctx: ctx, stop: stop, timeout: timeout}
+	if _, ok := r.(eofReader); ok {
+		b.release(true)
+	}
+	res.Body = b
+	return res, nil
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// Request is a request to send with Client.Do.
+type Request struct {
+	Method string
+	URL    *url.URL
+	// Headers are sent as given under lower-case names. Host defaults to
+	// the URL host; Content-Length and Transfer-Encoding are set by the
+	// client, which always sends Body with a Content-Length.
+	Headers map[string]string
+	Body    []byte
+	// Target, when set, is sent on the request line instead of the path
+	// and query of URL, so a proxy can pass a request target on verbatim.
+	// Redirects do not keep it.
+	Target string
+	// Context cancels the request and the reading of its response; nil
+	// means context.Background.
+	Context context.Context
+}
+
+// NewRequest parses rawURL, an http or https URL, into a request.
+func NewRequest(ctx context.Context, method, rawURL string, body []byte) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{
+		Method:  method,
+		URL:     u,
+		Headers: make(map[string]string),
+		Body:    body,
+		Context: ctx,
+	}, nil
+}
+
+func (req *Request) context() context.Context {
+	if req.Context == nil {
+		return context.Background()
+	}
+	return req.Context
+}
+
+func (req *Request) target() string {
+	if req.Target != "" {
+		return req.Target
+	}
+	return req.URL.RequestURI()
+}
+
+// appendHead writes the request line and headers, sorted so the server
+// sees them in a stable order.
+func (req *Request) appendHead(b []byte) []byte {
+	headers := make(map[string]string, len(req.Headers)+2)
+	for k, v := range req.Headers {
+		if k == "content-length" || k == "transfer-encoding" {
+			continue
+		}
+		headers[k] = v
+	}
+	if headers["host"] == "" {
+		headers["host"] = req.URL.Host
+	}
+	if len(req.Body) > 0 || expectsBody(req.Method) {
+		headers["content-length"] = strconv.Itoa(len(req.Body))
+	}
+
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b = append(b, req.Method...)
+	b = append(b, ' ')
+	b = append(b, req.target()...)
+	b = append(b, " HTTP/1.1\r\n"...)
+	for _, k := range keys {
+		b = append(b, CanonicalKey(k)...)
+		b = append(b, ": "...)
+		b = append(b, headers[k]...)
+		b = append(b, "\r\n"...)
+	}
+	return append(b, "\r\n"...)
+}
+
+// CanonicalKey turns content-type into Content-Type; header names are
+// case-insensitive, but older servers are not always.
+func CanonicalKey(k string) string {
+	b := []byte(k)
+	upper := true
+	for i, c := range b {
+		switch {
+		case upper && 'a' <= c && c <= 'z':
+			b[i] = c - ('a' - 'A')
+		case !upper && 'A' <= c && c <= 'Z':
+			b[i] = c + ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+func expectsBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+)
+
+var (
+	// ErrNoResponse means the server closed the connection without sending
+	// a byte of the response.
+	ErrNoResponse = errors.New("server closed the connection before responding")
+	// ErrBodyClosed is returned by reads of a body after Close.
+	ErrBodyClosed = errors.New("read on closed response body")
+)
+
+// Response is a response head and the body that follows it.
+type Response struct {
+	StatusCode int
+	Proto      string
+	// Headers has lower-case names; repeated fields are joined with a
+	// comma.
+	Headers map[string]string
+	// SetCookies holds each Set-Cookie field on its own, as joining them
+	// in Headers loses where one cookie ends and the next begins.
+	SetCookies []string
+	// ContentLength is the declared body length, or -1 when the body is
+	// chunked or runs until the server closes the connection.
+	ContentLength int64
+	// Chunked is set when the body arrived in chunks; Trailers then holds
+	// the trailer fields once Body has returned io.EOF.
+	Chunked  bool
+	Trailers map[string]string
+	// Body reads the body. After a 101 Switching Protocols it is the
+	// connection itself and also an io.Writer.
+	Body io.ReadCloser
+	// Request is the request this answers, the last one of a redirect
+	// chain.
+	Request *Request
+
+	keepAlive bool
+}
+
+// readResponse reads a response head, skipping interim 1xx responses, and
+// works out how its body is delimited (RFC 9112 section 6.3). The body is
+// left on br.
+func readResponse(br *bufio.Reader, method string) (*Response, io.Reader, error) {
+	for {
+		proto, status, headers, cookies, err := readHead(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		if status >= 100 && status < 200 && status != 101 {
+			continue
+		}
+
+		res := &Response{StatusCode: status, Proto: proto, Headers: headers, SetCookies: cookies, ContentLength: -1}
+		conn := strings.ToLower(headers["connection"])
+		res.keepAlive = proto == "HTTP/1.1" && !strings.Contains(conn, "close") ||
+			proto == "HTTP/1.0" && strings.Contains(conn, "keep-alive")
+
+		te, hasTE := headers["transfer-encoding"]
+		cl, hasCL := headers["content-length"]
+		switch {
+		case status == 101:
+			res.keepAlive = false
+			return res, nil, nil
+		case method == "HEAD" || status == 204 || status == 304:
+			res.ContentLength = 0
+			return res, eofReader{}, nil
+		case hasTE:
+			codings := strings.Split(strings.ToLower(te), ",")
+			if strings.TrimSpace(codings[len(codings)-1]) != "chunked" {
+				// only the end of the connection delimits this body
+				res.keepAlive = false
+				return res, br, nil
+			}
+			// the chunks delimit the body; a Content-Length is meaningless
+			delete(headers, "content-length")
+			res.Chunked = true
+			return res, request.NewChunkedReader(br, maxResponseHead), nil
+		case hasCL:
+			n, err := strconv.ParseInt(cl, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil, fmt.Errorf("invalid Content-Length %q", cl)
+			}
+			res.ContentLength = n
+			return res, &exactReader{r: br, n: n}, nil
+		default:
+			res.keepAlive = false
+			return res, br, nil
+		}
+	}
+}
+
+// readHead reads the status line and header fields up to the blank line.
+func readHead(br *bufio.Reader) (proto string, status int, headers map[string]string, cookies []string, err error) {
+	lr := &request.LineReader{R: br, Limit: maxResponseHead}
+	line, err := lr.ReadLine()
+	if err != nil {
+		if lr.Size() == 0 && (errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)) {
+			return "", 0, nil, nil, ErrNoResponse
+		}
+		return "", 0, nil, nil, err
+	}
+
+	// HTTP/1.1 200 OK; the reason phrase may be empty
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "HTTP/1.") || len(parts[1]) != 3 {
+		return "", 0, nil, nil, fmt.Errorf("malformed status line %q", line)
+	}
+	proto = parts[0]
+	status, err = strconv.Atoi(parts[1])
+	if err != nil || status < 100 {
+		return "", 0, nil, nil, fmt.Errorf("malformed status line %q", line)
+	}
+
+	headers, cookies, err = lr.ReadFields()
+	return proto, status, headers, cookies, err
+}
+
+// body reads a response body off its connection. The connection goes back
+// to the pool once the body has been read to the end, and is closed if
+// the body is closed early or the request context ends.
+type body struct {
+	r       io.Reader
+	res     *Response
+	cc      *conn
+	ctx     context.Context
+	stop    func() bool // stops closing the connection with ctx
+	timeout time.Duration
+
+	closed atomic.Bool
+	once   sync.Once
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed.Load() {
+		return 0, ErrBodyClosed
+	}
+	b.cc.SetReadDeadline(time.Now().Add(b.timeout))
+	n, err := b.r.Read(p)
+	switch {
+	case errors.Is(err, io.EOF):
+		if cr, ok := b.r.(*request.ChunkedReader); ok {
+			b.res.Trailers = cr.Trailers
+		}
+		b.release(true)
+	case err != nil:
+		b.release(false)
+		if b.ctx.Err() != nil {
+			err = b.ctx.Err()
+		}
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.closed.Store(true)
+	b.release(false)
+	return nil
+}
+
+// release hands the connection back to its pool when done is set and the
+// response allows it, or closes it.
+func (b *body) release(done bool) {
+	b.once.Do(func() {
+		if !b.stop() {
+			done = false // ctx already closed the connection
+		}
+		if done && b.res.keepAlive {
+			b.cc.pool.put(b.cc)
+			return
+		}
+		b.cc.Close()
+	})
+}
+
+// upgraded is the body of a 101 response: the connection, with the bytes
+// the server sent after its head first.
+type upgraded struct {
+	*conn
+}
+
+func (u upgraded) Read(p []byte) (int, error) {
+	return u.br.Read(p)
+}
+
+// exactReader reads a body of known length, failing if the server ends it
+// early.
+type exactReader struct {
+	r io.Reader
+	n int64
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	if e.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > e.n {
+		p = p[:e.n]
+	}
+	n, err := e.r.Read(p)
+	e.n -= int64(n)
+	if errors.Is(err, io.EOF) && e.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
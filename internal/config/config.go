@@ -1,6 +1,11 @@
 package config
 
-import "time"
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
 
 type Config struct {
 	BufferLimit  int
@@ -8,6 +13,43 @@ type Config struct {
 	HeaderLimit  int
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+
+	// ResponseBufferLimit is how much body a response buffers before it
+	// starts streaming; zero uses response.DefaultResponseBufferLimit.
+	ResponseBufferLimit int
+
+	// HandlerTimeout, when set, bounds how long a handler may run before the
+	// request is cancelled and answered with 503; routes can override it
+	// with router.Timeout.
+	HandlerTimeout time.Duration
+
+	// H2C accepts cleartext HTTP/2, both from clients with prior knowledge
+	// and through an Upgrade: h2c request.
+	H2C bool
+
+	// MetricsPath, when set, exposes Prometheus metrics on this GET route.
+	MetricsPath string
+	// AdminAddr, when set, starts a debug listener with connection state,
+	// pprof profiles and the route table. Bind it to loopback only.
+	AdminAddr string
+
+	// ProxyProtocol expects a PROXY protocol header (v1 or v2) ahead of
+	// every connection from ProxyTrusted, for servers behind a TCP load
+	// balancer; an empty ProxyTrusted trusts every source.
+	// ProxyHeaderTimeout bounds the wait for the header and defaults to
+	// ReadTimeout.
+	ProxyProtocol      bool
+	ProxyTrusted       []*net.IPNet
+	ProxyHeaderTimeout time.Duration
+
+	// TrustedProxies are the reverse proxies and load balancers whose
+	// forwarding headers are believed when working out the client address,
+	// scheme and host of a request. Only X-Forwarded-* is read, unless
+	// TrustForwarded picks the RFC 7239 Forwarded header instead: name the
+	// one the proxies manage, as one that only appends X-Forwarded-For
+	// passes on whatever Forwarded header the client made up.
+	TrustedProxies []*net.IPNet
+	TrustForwarded bool
 }
 
 func Load(
@@ -21,3 +63,33 @@ func Load(
 		WriteTimeout: WriteTimeout,
 	}
 }
+
+// ParseCIDRs parses a comma-separated list of networks; a bare address
+// stands for itself alone.
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("config: bad address %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
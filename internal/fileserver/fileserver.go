@@ -0,0 +1,316 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+)
+
+const httpDateLayout = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// FileServer serves files below Root. Mount Serve on a wildcard route and
+// name the wildcard in Param, e.g. "/assets/*path" with Param "path".
+type FileServer struct {
+	Root  string
+	Param string
+	// Index is served for directory requests; empty disables it.
+	Index string
+	// ListDirectories renders an HTML listing when a directory has no index.
+	ListDirectories bool
+}
+
+func New(root, param string) *FileServer {
+	return &FileServer{
+		Root:  root,
+		Param: param,
+		Index: "index.html",
+	}
+}
+
+// Serve is a router.Handler for GET and HEAD requests.
+func (fsrv *FileServer) Serve(req *request.Request, res *response.Response) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		res.SetHeader("Allow", "GET, HEAD")
+		replyStatus(req, res, 405)
+		return
+	}
+
+	name, err := fsrv.resolve(req.Params[fsrv.Param])
+	if err != nil {
+		replyStatus(req, res, 404)
+		return
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			replyStatus(req, res, 403)
+			return
+		}
+		replyStatus(req, res, 404)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		replyStatus(req, res, 500)
+		return
+	}
+
+	if info.IsDir() {
+		fsrv.serveDir(req, res, f, name)
+		return
+	}
+
+	serveContent(req, res, f, info)
+}
+
+// resolve maps the wildcard value onto Root, refusing anything that would
+// land outside it, including through symlinks.
+func (fsrv *FileServer) resolve(param string) (string, error) {
+	param, _, _ = strings.Cut(param, "?")
+	decoded, err := url.PathUnescape(param)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(decoded, "\x00") || strings.Contains(decoded, "\\") {
+		return "", errors.New("invalid file path")
+	}
+
+	clean := path.Clean("/" + decoded)
+	root, err := filepath.Abs(fsrv.Root)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Join(root, filepath.FromSlash(clean))
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realName, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	if realName != realRoot && !strings.HasPrefix(realName, realRoot+string(filepath.Separator)) {
+		return "", errors.New("path escapes root")
+	}
+	return realName, nil
+}
+
+func (fsrv *FileServer) serveDir(req *request.Request, res *response.Response, dir *os.File, name string) {
+	urlPath, _, _ := strings.Cut(req.Path, "?")
+	if !strings.HasSuffix(urlPath, "/") {
+		res.SetHeader("Location", urlPath+"/")
+		replyStatus(req, res, 301)
+		return
+	}
+
+	if fsrv.Index != "" {
+		index, err := os.Open(filepath.Join(name, fsrv.Index))
+		if err == nil {
+			defer index.Close()
+			if info, err := index.Stat(); err == nil && !info.IsDir() {
+				serveContent(req, res, index, info)
+				return
+			}
+		}
+	}
+
+	if !fsrv.ListDirectories {
+		replyStatus(req, res, 404)
+		return
+	}
+
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		replyStatus(req, res, 500)
+		return
+	}
+	body := renderListing(urlPath, entries)
+	res.SetHeader("Content-Type", "text/html; charset=utf-8")
+	res.Write(body)
+	res.Flush(req, false)
+}
+
+func serveContent(req *request.Request, res *response.Response, f *os.File, info fs.FileInfo) {
+	modTime := info.ModTime()
+	etag := fmt.Sprintf(`"%x-%x"`, info.Size(), modTime.UnixNano())
+
+	res.SetHeader("Last-Modified", modTime.UTC().Format(httpDateLayout))
+	res.SetHeader("ETag", etag)
+	res.SetHeader("Accept-Ranges", "bytes")
+
+	switch checkPreconditions(req, etag, modTime) {
+	case preconditionFailed:
+		replyStatus(req, res, 412)
+		return
+	case notModified:
+		res.WriteHeader(304)
+		res.Flush(req, false)
+		return
+	}
+
+	ctype, err := contentType(f, info.Name())
+	if err != nil {
+		replyStatus(req, res, 500)
+		return
+	}
+
+	size := info.Size()
+	rangeHeader := req.Headers["range"]
+	if rangeHeader != "" && !ifRangeMatches(req, etag, modTime) {
+		rangeHeader = ""
+	}
+
+	ranges, err := parseRange(rangeHeader, size)
+	if err != nil {
+		res.SetHeader("Content-Range", fmt.Sprintf("bytes */%d", size))
+		replyStatus(req, res, 416)
+		return
+	}
+
+	switch {
+	case len(ranges) == 1:
+		ra := ranges[0]
+		res.SetHeader("Content-Type", ctype)
+		res.SetHeader("Content-Range", ra.contentRange(size))
+		res.SetHeader("Content-Length", strconv.FormatInt(ra.length, 10))
+		res.WriteHeader(206)
+		writeSection(req, res, f, ra.start, ra.length)
+	case len(ranges) > 1:
+		body := newMultipartRanges(f, ranges, ctype, size)
+		length, err := body.length()
+		if err != nil {
+			replyStatus(req, res, 500)
+			return
+		}
+		res.SetHeader("Content-Type", body.contentType())
+		res.SetHeader("Content-Length", strconv.FormatInt(length, 10))
+		res.WriteHeader(206)
+		if req.Method != "HEAD" {
+			if err := body.writeTo(res); err != nil {
+				req.Logger.Debug("file send failed", "error", err)
+				res.Abort(err)
+				return
+			}
+		}
+		res.Flush(req, false)
+	default:
+		res.SetHeader("Content-Type", ctype)
+		res.SetHeader("Content-Length", strconv.FormatInt(size, 10))
+		writeSection(req, res, f, 0, size)
+	}
+}
+
+func writeSection(req *request.Request, res *response.Response, f *os.File, offset, length int64) {
+	if err := res.SendFile(req, f, offset, length); err != nil {
+		req.Logger.Debug("file send failed", "error", err)
+	}
+}
+
+// contentType prefers the extension and falls back to sniffing the content.
+func contentType(f *os.File, name string) (string, error) {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype, nil
+	}
+	head := make([]byte, sniffLen)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return detectContentType(head[:n]), nil
+}
+
+type precondition int
+
+const (
+	proceed precondition = iota
+	notModified
+	preconditionFailed
+)
+
+// checkPreconditions evaluates conditional headers in RFC 9110 13.2.2 order.
+func checkPreconditions(req *request.Request, etag string, modTime time.Time) precondition {
+	if im := req.Headers["if-match"]; im != "" {
+		if !etagListMatches(im, etag, false) {
+			return preconditionFailed
+		}
+	} else if ius := req.Headers["if-unmodified-since"]; ius != "" {
+		if t, err := parseHTTPDate(ius); err == nil && modTime.Truncate(time.Second).After(t) {
+			return preconditionFailed
+		}
+	}
+
+	if inm := req.Headers["if-none-match"]; inm != "" {
+		if etagListMatches(inm, etag, true) {
+			return notModified
+		}
+		return proceed
+	}
+	if ims := req.Headers["if-modified-since"]; ims != "" {
+		if t, err := parseHTTPDate(ims); err == nil && !modTime.Truncate(time.Second).After(t) {
+			return notModified
+		}
+	}
+	return proceed
+}
+
+// ifRangeMatches reports whether a Range header should be honoured.
+func ifRangeMatches(req *request.Request, etag string, modTime time.Time) bool {
+	ir := req.Headers["if-range"]
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return ir == etag // strong comparison only
+	}
+	t, err := parseHTTPDate(ir)
+	return err == nil && modTime.Truncate(time.Second).Equal(t)
+}
+
+func etagListMatches(list, etag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPDate(v string) (time.Time, error) {
+	for _, layout := range []string{httpDateLayout, time.RFC850, time.ANSIC} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid HTTP date")
+}
+
+func replyStatus(req *request.Request, res *response.Response, code int) {
+	res.WriteHeader(code)
+	if code != 304 {
+		res.Write([]byte(strconv.Itoa(code) + " " + response.StatusText(code)))
+	}
+	res.Flush(req, false)
+}
@@ -0,0 +1,250 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/client"
+	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/router"
+	"github.com/brutally-Honest/http-server/internal/server"
+)
+
+// serveFiles mounts a FileServer over root on /files and returns its base
+// URL and a client for it.
+func serveFiles(t *testing.T, root string) (string, *client.Client) {
+	t.Helper()
+	r := router.NewRouter()
+	fsrv := New(root, "path")
+	fsrv.ListDirectories = true
+	r.GET("/files/*path", fsrv.Serve)
+	r.Register("HEAD", "/files/*path", fsrv.Serve)
+
+	cfg := config.Load(4096, 1<<20, 8192, 5*time.Second, 5*time.Second)
+	s := server.NewServer("127.0.0.1:0", cfg, r)
+	s.Logger = logger.Discard()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+
+	c := &client.Client{Timeout: 5 * time.Second}
+	t.Cleanup(func() {
+		c.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return "http://" + ln.Addr().String() + "/files/", c
+}
+
+// fetch sends a request with headers and returns the response and body.
+func fetch(t *testing.T, c *client.Client, method, url string, headers map[string]string) (*client.Response, []byte) {
+	t.Helper()
+	req, err := client.NewRequest(context.Background(), method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Headers[k] = v
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	return res, body
+}
+
+func writeTestFile(t *testing.T, dir, name string, content []byte) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeFile(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, root, "hello.txt", []byte("hello, world"))
+	base, c := serveFiles(t, root)
+
+	res, body := fetch(t, c, "GET", base+"hello.txt", nil)
+	if res.StatusCode != 200 || string(body) != "hello, world" {
+		t.Fatalf("got %d %q", res.StatusCode, body)
+	}
+	if res.Headers["content-type"] != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type %q", res.Headers["content-type"])
+	}
+	if res.Headers["accept-ranges"] != "bytes" || res.Headers["etag"] == "" || res.Headers["last-modified"] == "" {
+		t.Errorf("validators missing: %v", res.Headers)
+	}
+
+	res, body = fetch(t, c, "HEAD", base+"hello.txt", nil)
+	if res.StatusCode != 200 || len(body) != 0 || res.Headers["content-length"] != "12" {
+		t.Errorf("HEAD got %d %q, Content-Length %q", res.StatusCode, body, res.Headers["content-length"])
+	}
+
+	for _, path := range []string{"missing.txt", "../" + filepath.Base(root) + "/hello.txt", "%2e%2e/etc/passwd"} {
+		if res, _ := fetch(t, c, "GET", base+path, nil); res.StatusCode != 404 {
+			t.Errorf("%s: got %d, want 404", path, res.StatusCode)
+		}
+	}
+}
+
+func TestServeFileConditional(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, root, "a.txt", []byte("content"))
+	base, c := serveFiles(t, root)
+
+	res, _ := fetch(t, c, "GET", base+"a.txt", nil)
+	etag, modified := res.Headers["etag"], res.Headers["last-modified"]
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "If-None-Match", headers: map[string]string{"if-none-match": etag}, status: 304},
+		{name: "If-None-Match weak", headers: map[string]string{"if-none-match": "W/" + etag}, status: 304},
+		{name: "If-None-Match list", headers: map[string]string{"if-none-match": `"other", ` + etag}, status: 304},
+		{name: "If-None-Match star", headers: map[string]string{"if-none-match": "*"}, status: 304},
+		{name: "If-None-Match other", headers: map[string]string{"if-none-match": `"other"`}, status: 200},
+		{name: "If-Modified-Since", headers: map[string]string{"if-modified-since": modified}, status: 304},
+		{name: "If-None-Match wins over If-Modified-Since", headers: map[string]string{"if-none-match": `"other"`, "if-modified-since": modified}, status: 200},
+		{name: "If-Modified-Since earlier", headers: map[string]string{"if-modified-since": "Mon, 02 Jan 2006 15:04:05 GMT"}, status: 200},
+		{name: "If-Match", headers: map[string]string{"if-match": etag}, status: 200},
+		{name: "If-Match other", headers: map[string]string{"if-match": `"other"`}, status: 412},
+		{name: "If-Match weak", headers: map[string]string{"if-match": "W/" + etag}, status: 412},
+		{name: "If-Unmodified-Since earlier", headers: map[string]string{"if-unmodified-since": "Mon, 02 Jan 2006 15:04:05 GMT"}, status: 412},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := fetch(t, c, "GET", base+"a.txt", tt.headers)
+			if res.StatusCode != tt.status {
+				t.Errorf("got %d, want %d", res.StatusCode, tt.status)
+			}
+			if tt.status == 304 && len(body) != 0 {
+				t.Errorf("304 with body %q", body)
+			}
+		})
+	}
+}
+
+func TestServeFileRanges(t *testing.T) {
+	root := t.TempDir()
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	writeTestFile(t, root, "data.bin", content)
+	base, c := serveFiles(t, root)
+
+	res, _ := fetch(t, c, "GET", base+"data.bin", nil)
+	etag := res.Headers["etag"]
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{name: "single", headers: map[string]string{"range": "bytes=10-15"}, status: 206, body: "abcdef", contentRange: "bytes 10-15/36"},
+		{name: "suffix", headers: map[string]string{"range": "bytes=-4"}, status: 206, body: "wxyz", contentRange: "bytes 32-35/36"},
+		{name: "overlapping ranges merged", headers: map[string]string{"range": "bytes=0-4,2-9"}, status: 206, body: "0123456789", contentRange: "bytes 0-9/36"},
+		{name: "ranges over the file size", headers: map[string]string{"range": "bytes=0-,0-,0-"}, status: 200, body: string(content)},
+		{name: "unsatisfiable", headers: map[string]string{"range": "bytes=100-"}, status: 416, contentRange: "bytes */36"},
+		{name: "other unit", headers: map[string]string{"range": "lines=1-2"}, status: 200, body: string(content)},
+		{name: "If-Range match", headers: map[string]string{"range": "bytes=0-1", "if-range": etag}, status: 206, body: "01", contentRange: "bytes 0-1/36"},
+		{name: "If-Range mismatch", headers: map[string]string{"range": "bytes=0-1", "if-range": `"old"`}, status: 200, body: string(content)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := fetch(t, c, "GET", base+"data.bin", tt.headers)
+			if res.StatusCode != tt.status {
+				t.Fatalf("got %d, want %d", res.StatusCode, tt.status)
+			}
+			if tt.status != 416 && string(body) != tt.body {
+				t.Errorf("body %q, want %q", body, tt.body)
+			}
+			if res.Headers["content-range"] != tt.contentRange {
+				t.Errorf("Content-Range %q, want %q", res.Headers["content-range"], tt.contentRange)
+			}
+		})
+	}
+}
+
+func TestServeFileMultipartRanges(t *testing.T) {
+	root := t.TempDir()
+	// large enough that the parts outgrow the response buffer
+	content := bytes.Repeat([]byte("0123456789"), 20000)
+	writeTestFile(t, root, "big.bin", content)
+	base, c := serveFiles(t, root)
+
+	ranges := [][2]int{{0, 99999}, {150000, 199999}}
+	header := "bytes=150000-199999,0-99999"
+
+	head, _ := fetch(t, c, "HEAD", base+"big.bin", map[string]string{"range": header})
+	res, body := fetch(t, c, "GET", base+"big.bin", map[string]string{"range": header})
+	if res.StatusCode != 206 {
+		t.Fatalf("got %d", res.StatusCode)
+	}
+	if cl := res.Headers["content-length"]; cl != strconv.Itoa(len(body)) || head.Headers["content-length"] != cl {
+		t.Errorf("Content-Length %q, HEAD %q, body %d bytes", cl, head.Headers["content-length"], len(body))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(res.Headers["content-type"])
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type %q: %v", res.Headers["content-type"], err)
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for i, r := range ranges {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		data, _ := io.ReadAll(part)
+		if !bytes.Equal(data, content[r[0]:r[1]+1]) {
+			t.Errorf("part %d: %d bytes, not the range %d-%d", i, len(data), r[0], r[1])
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("after the last part: %v", err)
+	}
+}
+
+func TestServeDirectory(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "site"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "list"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "site/index.html", []byte("<p>index</p>"))
+	writeTestFile(t, root, "list/entry.txt", []byte("x"))
+	base, c := serveFiles(t, root)
+
+	c.MaxRedirects = -1
+	if res, _ := fetch(t, c, "GET", base+"site", nil); res.StatusCode != 301 || res.Headers["location"] != "/files/site/" {
+		t.Errorf("got %d to %q", res.StatusCode, res.Headers["location"])
+	}
+	if res, body := fetch(t, c, "GET", base+"site/", nil); res.StatusCode != 200 || string(body) != "<p>index</p>" {
+		t.Errorf("index: got %d %q", res.StatusCode, body)
+	}
+	if res, body := fetch(t, c, "GET", base+"list/", nil); res.StatusCode != 200 || !bytes.Contains(body, []byte("entry.txt")) {
+		t.Errorf("listing: got %d %q", res.StatusCode, body)
+	}
+}
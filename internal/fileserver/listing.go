@@ -0,0 +1,38 @@
+package fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/url"
+	"sort"
+)
+
+func renderListing(urlPath string, entries []fs.DirEntry) []byte {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir() != entries[j].IsDir() {
+			return entries[i].IsDir()
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	var b bytes.Buffer
+	title := html.EscapeString(urlPath)
+	fmt.Fprintf(&b, "<!doctype html>\n<html><head><meta charset=\"utf-8\"><title>Index of %s</title></head>\n", title)
+	fmt.Fprintf(&b, "<body>\n<h1>Index of %s</h1>\n<ul>\n", title)
+	if urlPath != "/" {
+		b.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		// "./" keeps names containing ':' from parsing as a scheme
+		href := "./" + (&url.URL{Path: name}).EscapedPath()
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	b.WriteString("</ul>\n</body></html>\n")
+	return b.Bytes()
+}
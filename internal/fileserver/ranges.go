@@ -0,0 +1,198 @@
+package fileserver
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxRanges bounds how many ranges one request may ask for.
+const maxRanges = 32
+
+var errUnsatisfiable = errors.New("range not satisfiable")
+
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a "bytes=" Range header. It returns nil when the whole
+// file should be sent: no header, an unknown unit, or ranges that add up
+// to more than the file itself. Ranges that overlap or touch are merged,
+// so no byte is sent twice.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	var total int64
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, errUnsatisfiable
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// suffix range: the final N bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, errUnsatisfiable
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errUnsatisfiable
+			}
+			if start >= size {
+				continue // this range alone is unsatisfiable
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errUnsatisfiable
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		if r.length == 0 {
+			continue
+		}
+		if len(ranges) == maxRanges {
+			return nil, errUnsatisfiable
+		}
+		ranges = append(ranges, r)
+		total += r.length
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	if total > size {
+		return nil, nil
+	}
+	return mergeRanges(ranges), nil
+}
+
+// mergeRanges sorts ranges and coalesces those that overlap or are
+// adjacent, as RFC 9110 section 14.3 allows.
+func mergeRanges(ranges []byteRange) []byteRange {
+	slices.SortFunc(ranges, func(a, b byteRange) int { return cmp.Compare(a.start, b.start) })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if end := last.start + last.length; r.start <= end {
+			last.length = max(end, r.start+r.length) - last.start
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// multipartRanges is a multipart/byteranges body for several ranges. It is
+// written straight from the file, so serving it holds no more than a copy
+// buffer however many bytes the ranges cover.
+type multipartRanges struct {
+	f        *os.File
+	ranges   []byteRange
+	ctype    string
+	size     int64
+	boundary string
+}
+
+func newMultipartRanges(f *os.File, ranges []byteRange, ctype string, size int64) *multipartRanges {
+	return &multipartRanges{
+		f:        f,
+		ranges:   ranges,
+		ctype:    ctype,
+		size:     size,
+		boundary: multipart.NewWriter(io.Discard).Boundary(),
+	}
+}
+
+// contentType is the Content-Type of the body, boundary included.
+func (m *multipartRanges) contentType() string {
+	return "multipart/byteranges; boundary=" + m.boundary
+}
+
+// length is the size of the body: the part headers and delimiters, counted
+// by writing them without the file data, plus the ranges themselves.
+func (m *multipartRanges) length() (int64, error) {
+	var cw countingWriter
+	if err := m.write(&cw, false); err != nil {
+		return 0, err
+	}
+	n := cw.n
+	for _, r := range m.ranges {
+		n += r.length
+	}
+	return n, nil
+}
+
+// writeTo writes the body to w.
+func (m *multipartRanges) writeTo(w io.Writer) error {
+	return m.write(w, true)
+}
+
+func (m *multipartRanges) write(w io.Writer, withData bool) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(m.boundary); err != nil {
+		return err
+	}
+	for _, r := range m.ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {m.ctype},
+			"Content-Range": {r.contentRange(m.size)},
+		})
+		if err != nil {
+			return err
+		}
+		if !withData {
+			continue
+		}
+		n, err := io.Copy(part, io.NewSectionReader(m.f, r.start, r.length))
+		if err != nil {
+			return err
+		}
+		if n != r.length {
+			return io.ErrUnexpectedEOF // file shrank underneath us
+		}
+	}
+	return mw.Close()
+}
+
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,136 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 100
+	many := strings.TrimSuffix(strings.Repeat("0-0,", maxRanges+1), ",")
+	tests := []struct {
+		header  string
+		want    []byteRange
+		wantErr bool
+	}{
+		{header: "", want: nil},
+		{header: "items=0-5", want: nil},
+		{header: "bytes=0-9", want: []byteRange{{0, 10}}},
+		{header: "bytes=90-", want: []byteRange{{90, 10}}},
+		{header: "bytes=-10", want: []byteRange{{90, 10}}},
+		{header: "bytes=-200", want: []byteRange{{0, 100}}},
+		{header: "bytes=95-200", want: []byteRange{{95, 5}}},
+		{header: "bytes=0-0, 50-59", want: []byteRange{{0, 1}, {50, 10}}},
+		{header: "bytes=50-59,0-9", want: []byteRange{{0, 10}, {50, 10}}},
+		{header: "bytes=0-9,5-14", want: []byteRange{{0, 15}}},
+		{header: "bytes=0-9,10-19", want: []byteRange{{0, 20}}},
+		{header: "bytes=20-29,0-4,3-9,-5", want: []byteRange{{0, 10}, {20, 10}, {95, 5}}},
+		{header: "bytes=150-,0-4", want: []byteRange{{0, 5}}},
+		{header: "bytes=0-,0-,0-", want: nil},
+		{header: "bytes=0-59,40-99", want: nil},
+		{header: "bytes=" + strings.TrimSuffix(strings.Repeat("0-0,", maxRanges), ","), want: []byteRange{{0, 1}}},
+		{header: "bytes=" + many, wantErr: true},
+		{header: "bytes=100-", wantErr: true},
+		{header: "bytes=-0", wantErr: true},
+		{header: "bytes=9-0", wantErr: true},
+		{header: "bytes=a-b", wantErr: true},
+		{header: "bytes=5", wantErr: true},
+		{header: "bytes=", wantErr: true},
+	}
+	for _, tt := range tests {
+		name := tt.header
+		if len(name) > 40 {
+			name = name[:40] + "..."
+		}
+		t.Run(name, func(t *testing.T) {
+			got, err := parseRange(tt.header, size)
+			if tt.wantErr {
+				if !errors.Is(err, errUnsatisfiable) {
+					t.Errorf("got %v, %v, want unsatisfiable", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultipartRanges(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	name := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(name, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ranges := []byteRange{{0, 3}, {10, 5}, {30, 6}}
+	body := newMultipartRanges(f, ranges, "text/plain", int64(len(content)))
+	length, err := body.length()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := body.writeTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if int64(buf.Len()) != length {
+		t.Errorf("wrote %d bytes, length reported %d", buf.Len(), length)
+	}
+	if !strings.HasSuffix(body.contentType(), "boundary="+body.boundary) {
+		t.Errorf("Content-Type %q", body.contentType())
+	}
+
+	mr := multipart.NewReader(&buf, body.boundary)
+	for i, r := range ranges {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		data, _ := io.ReadAll(part)
+		if want := content[r.start : r.start+r.length]; !bytes.Equal(data, want) {
+			t.Errorf("part %d holds %q, want %q", i, data, want)
+		}
+		if got, want := part.Header.Get("Content-Range"), r.contentRange(int64(len(content))); got != want {
+			t.Errorf("part %d Content-Range %q, want %q", i, got, want)
+		}
+		if part.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("part %d Content-Type %q", i, part.Header.Get("Content-Type"))
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("after the last part: %v", err)
+	}
+}
+
+func TestMultipartRangesFileShrank(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(name, []byte("short"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	body := newMultipartRanges(f, []byteRange{{0, 2}, {3, 10}}, "text/plain", 20)
+	if err := body.writeTo(io.Discard); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want ErrUnexpectedEOF", err)
+	}
+}
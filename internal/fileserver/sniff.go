@@ -0,0 +1,71 @@
+package fileserver
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file is inspected when the extension is unknown.
+const sniffLen = 512
+
+var signatures = []struct {
+	prefix []byte
+	ctype  string
+}{
+	{[]byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{[]byte("\xff\xd8\xff"), "image/jpeg"},
+	{[]byte("GIF87a"), "image/gif"},
+	{[]byte("GIF89a"), "image/gif"},
+	{[]byte("%PDF-"), "application/pdf"},
+	{[]byte("PK\x03\x04"), "application/zip"},
+	{[]byte("\x1f\x8b\x08"), "application/x-gzip"},
+	{[]byte("\x00asm"), "application/wasm"},
+	{[]byte("wOFF"), "font/woff"},
+	{[]byte("wOF2"), "font/woff2"},
+	{[]byte("OggS\x00"), "application/ogg"},
+	{[]byte("ID3"), "audio/mpeg"},
+}
+
+// detectContentType is a small subset of the WHATWG MIME sniffing rules:
+// well-known magic numbers, HTML/XML markers, then text vs binary.
+func detectContentType(data []byte) string {
+	for _, sig := range signatures {
+		if bytes.HasPrefix(data, sig.prefix) {
+			return sig.ctype
+		}
+	}
+	if len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")) {
+		return "image/webp"
+	}
+
+	trimmed := bytes.TrimLeft(data, "\t\n\x0c\r ")
+	lower := bytes.ToLower(trimmed)
+	for _, marker := range []string{"<!doctype html", "<html", "<head", "<body", "<script", "<p>", "<div"} {
+		if bytes.HasPrefix(lower, []byte(marker)) {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if bytes.HasPrefix(trimmed, []byte("<?xml")) {
+		return "text/xml; charset=utf-8"
+	}
+
+	if looksLikeText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+func looksLikeText(data []byte) bool {
+	// a truncated multi-byte rune at the sniff boundary is still text
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			return !utf8.FullRune(data)
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\x0c' {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
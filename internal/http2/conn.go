@@ -0,0 +1,630 @@
+package http2
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/http2/hpack"
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/request"
+)
+
+// maxHeaderBlock bounds a header block spread over CONTINUATION frames,
+// before it is even decoded.
+const maxHeaderBlock = 1 << 20
+
+type serverConn struct {
+	srv    *Server
+	conn   net.Conn
+	br     *bufio.Reader
+	log    logger.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// owned by the read loop
+	dec          *hpack.Decoder
+	maxStreams   uint32
+	lastStreamID uint32
+	recvWindow   int64
+	continuing   *headerBlock
+
+	// wmu serialises frame writes so header blocks stay contiguous.
+	wmu sync.Mutex
+
+	// mu guards the stream table and the send windows; cond wakes writers
+	// waiting for window space.
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrame      uint32
+	closed            bool
+
+	// draining is set once GOAWAY announced a shutdown; streams above
+	// drainID are refused from then on.
+	draining bool
+	drainID  uint32
+}
+
+// headerBlock collects a HEADERS frame and its CONTINUATION frames.
+type headerBlock struct {
+	streamID  uint32
+	endStream bool
+	block     []byte
+}
+
+func (sc *serverConn) serve(upgrade *request.Request) (err error) {
+	defer sc.close()
+	defer func() {
+		var ce ConnError
+		if errors.As(err, &ce) {
+			sc.goAway(ce.Code, ce.Reason)
+		}
+	}()
+
+	if upgrade != nil {
+		settings, err := decodeUpgradeSettings(upgrade.Headers["http2-settings"])
+		if err != nil {
+			return err
+		}
+		if err := sc.applySettings(settings); err != nil {
+			return err
+		}
+	}
+
+	// the server preface is our SETTINGS, sent without waiting for the client
+	settings := appendSettings(nil,
+		setting{settingMaxConcurrentStreams, sc.maxStreams},
+		setting{settingEnablePush, 0},
+	)
+	if sc.dec.MaxHeaderListSize > 0 {
+		settings = appendSettings(settings, setting{settingMaxHeaderListSize, sc.dec.MaxHeaderListSize})
+	}
+	if err := sc.writeFrame(frameSettings, 0, 0, settings); err != nil {
+		return err
+	}
+
+	sc.conn.SetReadDeadline(time.Now().Add(sc.srv.Config.ReadTimeout))
+	preface := make([]byte, len(ClientPreface))
+	if _, err := io.ReadFull(sc.br, preface); err != nil || string(preface) != ClientPreface {
+		return ErrBadPreface
+	}
+
+	if upgrade != nil {
+		sc.startUpgradeStream(upgrade)
+	}
+	if sc.srv.Shutdown != nil {
+		go func() {
+			select {
+			case <-sc.srv.Shutdown:
+				sc.drain()
+			case <-sc.ctx.Done():
+			}
+		}()
+	}
+
+	first := true
+	for {
+		sc.setReadDeadline()
+		f, err := readFrame(sc.br, defaultMaxFrame)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if sc.isDraining() {
+					return nil // GOAWAY went out already
+				}
+				return ConnError{ErrCodeNo, "idle timeout"}
+			}
+			return err
+		}
+
+		if first && f.typ != frameSettings {
+			return ConnError{ErrCodeProtocol, "first frame is not SETTINGS"}
+		}
+		first = false
+
+		if err := sc.processFrame(f); err != nil {
+			var se StreamError
+			if errors.As(err, &se) {
+				sc.log.Debug("http2 stream error", "error", se)
+				sc.resetStreamID(se.StreamID, se.Code)
+				continue
+			}
+			return err
+		}
+	}
+}
+
+// setReadDeadline lets an idle connection time out but never cuts a
+// stream that is still running, however quiet the client is. A draining
+// connection with nothing left to answer stops reading at once. The
+// deadline is set under mu so that callers on other goroutines cannot
+// interleave with the read loop and leave a stale one behind.
+func (sc *serverConn) setReadDeadline() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	switch {
+	case len(sc.streams) > 0:
+		sc.conn.SetReadDeadline(time.Time{})
+	case sc.draining:
+		sc.conn.SetReadDeadline(time.Now())
+	default:
+		sc.conn.SetReadDeadline(time.Now().Add(sc.srv.Config.ReadTimeout))
+	}
+}
+
+func (sc *serverConn) processFrame(f frame) error {
+	if sc.continuing != nil && f.typ != frameContinuation {
+		return ConnError{ErrCodeProtocol, "expected CONTINUATION"}
+	}
+
+	switch f.typ {
+	case frameData:
+		return sc.processData(f)
+	case frameHeaders:
+		return sc.processHeaders(f)
+	case frameContinuation:
+		return sc.processContinuation(f)
+	case framePriority:
+		if f.streamID == 0 {
+			return ConnError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if f.length != 5 {
+			return StreamError{f.streamID, ErrCodeFrameSize, "PRIORITY length"}
+		}
+		return nil // priorities are advisory and ignored
+	case frameRSTStream:
+		return sc.processReset(f)
+	case frameSettings:
+		return sc.processSettings(f)
+	case framePushPromise:
+		return ConnError{ErrCodeProtocol, "client sent PUSH_PROMISE"}
+	case framePing:
+		if f.streamID != 0 {
+			return ConnError{ErrCodeProtocol, "PING on a stream"}
+		}
+		if f.length != 8 {
+			return ConnError{ErrCodeFrameSize, "PING length"}
+		}
+		if f.has(flagAck) {
+			return nil
+		}
+		return sc.writeFrame(framePing, flagAck, 0, f.payload)
+	case frameGoAway:
+		if f.streamID != 0 {
+			return ConnError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+		// the client opens no new streams; running ones finish normally
+		return nil
+	case frameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	default:
+		return nil // unknown frame types must be ignored
+	}
+}
+
+func (sc *serverConn) processSettings(f frame) error {
+	if f.streamID != 0 {
+		return ConnError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+	if f.has(flagAck) {
+		if f.length != 0 {
+			return ConnError{ErrCodeFrameSize, "SETTINGS ack with payload"}
+		}
+		return nil
+	}
+
+	settings, err := parseSettings(f.payload)
+	if err != nil {
+		return err
+	}
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	return sc.writeFrame(frameSettings, flagAck, 0, nil)
+}
+
+func (sc *serverConn) applySettings(settings []setting) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for _, s := range settings {
+		switch s.id {
+		case settingEnablePush:
+			if s.val > 1 {
+				return ConnError{ErrCodeProtocol, "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case settingInitialWindowSize:
+			if s.val > maxWindowSize {
+				return ConnError{ErrCodeFlowControl, "SETTINGS_INITIAL_WINDOW_SIZE too large"}
+			}
+			// the change applies to every open stream (RFC 9113 section 6.9.2)
+			delta := int64(s.val) - sc.peerInitialWindow
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					return ConnError{ErrCodeFlowControl, "stream window overflow"}
+				}
+			}
+			sc.peerInitialWindow = int64(s.val)
+		case settingMaxFrameSize:
+			if s.val < defaultMaxFrame || s.val > maxAllowedFrame {
+				return ConnError{ErrCodeProtocol, "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			sc.peerMaxFrame = s.val
+		}
+		// header table size: the encoder never uses the dynamic table;
+		// concurrent streams and header list size limit pushes and
+		// requests the server does not make
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(f frame) error {
+	if f.length != 4 {
+		return ConnError{ErrCodeFrameSize, "WINDOW_UPDATE length"}
+	}
+	inc := int64(binary.BigEndian.Uint32(f.payload) & (1<<31 - 1))
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if f.streamID == 0 {
+		if inc == 0 {
+			return ConnError{ErrCodeProtocol, "zero WINDOW_UPDATE"}
+		}
+		if sc.sendWindow+inc > maxWindowSize {
+			return ConnError{ErrCodeFlowControl, "connection window overflow"}
+		}
+		sc.sendWindow += inc
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	st := sc.streams[f.streamID]
+	if st == nil {
+		if f.streamID > sc.lastStreamID {
+			return ConnError{ErrCodeProtocol, "WINDOW_UPDATE on idle stream"}
+		}
+		return nil // the stream is already closed
+	}
+	if inc == 0 {
+		return StreamError{f.streamID, ErrCodeProtocol, "zero WINDOW_UPDATE"}
+	}
+	if st.sendWindow+inc > maxWindowSize {
+		return StreamError{f.streamID, ErrCodeFlowControl, "stream window overflow"}
+	}
+	st.sendWindow += inc
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processReset(f frame) error {
+	if f.streamID == 0 {
+		return ConnError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if f.length != 4 {
+		return ConnError{ErrCodeFrameSize, "RST_STREAM length"}
+	}
+	if f.streamID > sc.lastStreamID {
+		return ConnError{ErrCodeProtocol, "RST_STREAM on idle stream"}
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[f.streamID]
+	if st != nil {
+		st.reset = true
+		if !st.dispatched {
+			delete(sc.streams, st.id)
+		}
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+
+	if st != nil {
+		st.cancel()
+	}
+	return nil
+}
+
+func (sc *serverConn) processHeaders(f frame) error {
+	if f.streamID == 0 {
+		return ConnError{ErrCodeProtocol, "HEADERS on stream 0"}
+	}
+
+	block, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	if f.has(flagPriority) {
+		if len(block) < 5 {
+			return ConnError{ErrCodeFrameSize, "HEADERS priority truncated"}
+		}
+		if binary.BigEndian.Uint32(block)&(1<<31-1) == f.streamID {
+			return StreamError{f.streamID, ErrCodeProtocol, "stream depends on itself"}
+		}
+		block = block[5:]
+	}
+
+	hb := &headerBlock{
+		streamID:  f.streamID,
+		endStream: f.has(flagEndStream),
+		block:     append([]byte(nil), block...),
+	}
+	if !f.has(flagEndHeaders) {
+		sc.continuing = hb
+		return nil
+	}
+	return sc.processHeaderBlock(hb)
+}
+
+func (sc *serverConn) processContinuation(f frame) error {
+	hb := sc.continuing
+	if hb == nil || f.streamID != hb.streamID {
+		return ConnError{ErrCodeProtocol, "unexpected CONTINUATION"}
+	}
+
+	hb.block = append(hb.block, f.payload...)
+	if len(hb.block) > maxHeaderBlock {
+		return ConnError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+	if !f.has(flagEndHeaders) {
+		return nil
+	}
+	sc.continuing = nil
+	return sc.processHeaderBlock(hb)
+}
+
+func (sc *serverConn) processHeaderBlock(hb *headerBlock) error {
+	id := hb.streamID
+	fields, err := sc.dec.Decode(hb.block)
+	tooLarge := errors.Is(err, hpack.ErrListTooLarge)
+	if err != nil && !tooLarge {
+		return ConnError{ErrCodeCompression, err.Error()}
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[id]
+	sc.mu.Unlock()
+
+	if st != nil {
+		// a second header block on a stream carries the request trailers
+		if st.remoteDone {
+			return StreamError{id, ErrCodeStreamClosed, "HEADERS after end of stream"}
+		}
+		if !hb.endStream {
+			return StreamError{id, ErrCodeProtocol, "trailers without END_STREAM"}
+		}
+		for _, f := range fields {
+			if len(f.Name) > 0 && f.Name[0] == ':' {
+				return StreamError{id, ErrCodeProtocol, "pseudo-header in trailers"}
+			}
+		}
+		return sc.endRequest(st)
+	}
+
+	if id%2 == 0 || id <= sc.lastStreamID {
+		return ConnError{ErrCodeProtocol, "invalid stream identifier"}
+	}
+
+	sc.mu.Lock()
+	sc.lastStreamID = id
+	draining := sc.draining
+	open := uint32(len(sc.streams))
+	sc.mu.Unlock()
+	if draining {
+		// above the GOAWAY stream id, so the client may retry it elsewhere
+		return StreamError{id, ErrCodeRefusedStream, "connection is shutting down"}
+	}
+	if open >= sc.maxStreams {
+		return StreamError{id, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+
+	if tooLarge {
+		sc.writeSimpleResponse(id, 431)
+		if !hb.endStream {
+			sc.writeRST(id, ErrCodeNo)
+		}
+		return nil
+	}
+
+	req, err := newRequest(fields)
+	if err != nil {
+		return StreamError{id, ErrCodeProtocol, err.Error()}
+	}
+	req.BytesRead = len(hb.block)
+
+	st = sc.newStream(id, req)
+	if hb.endStream {
+		return sc.endRequest(st)
+	}
+	return nil
+}
+
+func (sc *serverConn) processData(f frame) error {
+	if f.streamID == 0 {
+		return ConnError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+
+	// flow control counts the whole payload, padding included
+	n := int64(f.length)
+	if n > sc.recvWindow {
+		return ConnError{ErrCodeFlowControl, "connection window exceeded"}
+	}
+	sc.recvWindow -= n
+	if err := sc.replenish(0, &sc.recvWindow); err != nil {
+		return err
+	}
+
+	data, err := unpad(f)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[f.streamID]
+	sc.mu.Unlock()
+
+	if st == nil || st.remoteDone {
+		if f.streamID > sc.lastStreamID {
+			return ConnError{ErrCodeProtocol, "DATA on idle stream"}
+		}
+		return StreamError{f.streamID, ErrCodeStreamClosed, "DATA after end of stream"}
+	}
+
+	if n > st.recvWindow {
+		return StreamError{st.id, ErrCodeFlowControl, "stream window exceeded"}
+	}
+	st.recvWindow -= n
+
+	if len(st.body)+len(data) > sc.srv.Config.BodyLimit {
+		sc.writeSimpleResponse(st.id, 413)
+		sc.resetStream(st, ErrCodeNo)
+		return nil
+	}
+	st.body = append(st.body, data...)
+
+	if f.has(flagEndStream) {
+		return sc.endRequest(st)
+	}
+	return sc.replenish(st.id, &st.recvWindow)
+}
+
+// replenish returns consumed window to the client once half of it is
+// used. Request bodies are buffered whole, so the window only has to keep
+// uploads flowing; BodyLimit bounds the memory.
+func (sc *serverConn) replenish(streamID uint32, window *int64) error {
+	if *window > defaultWindowSize/2 {
+		return nil
+	}
+	inc := defaultWindowSize - *window
+	*window = defaultWindowSize
+	return sc.writeFrame(frameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(inc)))
+}
+
+// endRequest runs the handler once the client has sent the whole request.
+func (sc *serverConn) endRequest(st *stream) error {
+	st.remoteDone = true
+
+	if cl, ok := st.req.Headers["content-length"]; ok && cl != strconv.Itoa(len(st.body)) {
+		return StreamError{st.id, ErrCodeProtocol, "body does not match content-length"}
+	}
+	st.req.Body = st.body
+	st.req.BytesRead += len(st.body)
+
+	sc.mu.Lock()
+	st.dispatched = true
+	sc.mu.Unlock()
+
+	go sc.runHandler(st)
+	return nil
+}
+
+func (sc *serverConn) runHandler(st *stream) {
+	defer func() {
+		if p := recover(); p != nil {
+			sc.log.Error("panic in http2 handler", "panic", p, "stream", st.id)
+			sc.resetStream(st, ErrCodeInternal)
+		}
+		sc.closeStream(st)
+	}()
+	sc.srv.Handler(st.req, st)
+}
+
+// startUpgradeStream answers the request that switched to h2c as stream 1,
+// which the client has already half-closed.
+func (sc *serverConn) startUpgradeStream(req *request.Request) {
+	req.Version = "HTTP/2.0"
+	sc.lastStreamID = 1
+	st := sc.newStream(1, req)
+	st.body = req.Body
+	st.remoteDone = true
+
+	sc.mu.Lock()
+	st.dispatched = true
+	sc.mu.Unlock()
+	go sc.runHandler(st)
+}
+
+func (sc *serverConn) writeFrame(typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	return sc.writeFrameLocked(typ, flags, streamID, payload)
+}
+
+func (sc *serverConn) writeFrameLocked(typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	buf := appendFrame(make([]byte, 0, frameHeaderLen+len(payload)), typ, flags, streamID, payload)
+	sc.conn.SetWriteDeadline(time.Now().Add(sc.srv.Config.WriteTimeout))
+	if _, err := sc.conn.Write(buf); err != nil {
+		// unblock the read loop; the connection is unusable
+		sc.conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (sc *serverConn) goAway(code ErrCode, reason string) {
+	sc.mu.Lock()
+	last := sc.lastStreamID
+	if sc.draining {
+		// a later GOAWAY must not raise the stream id already announced
+		last = sc.drainID
+	}
+	sc.mu.Unlock()
+
+	payload := binary.BigEndian.AppendUint32(nil, last)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, reason...)
+	sc.writeFrame(frameGoAway, 0, 0, payload)
+}
+
+// drain starts a graceful shutdown: GOAWAY tells the client which streams
+// will still be answered, and the connection closes once they finish.
+func (sc *serverConn) drain() {
+	sc.mu.Lock()
+	if sc.draining || sc.closed {
+		sc.mu.Unlock()
+		return
+	}
+	sc.draining = true
+	sc.drainID = sc.lastStreamID
+	last := sc.drainID
+	sc.mu.Unlock()
+
+	sc.log.Debug("http2 draining", "last_stream", last)
+	sc.goAway(ErrCodeNo, "server shutting down")
+	sc.setReadDeadline()
+}
+
+func (sc *serverConn) isDraining() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.draining
+}
+
+// close cancels every stream still running and wakes their writers.
+func (sc *serverConn) close() {
+	sc.mu.Lock()
+	sc.closed = true
+	streams := make([]*stream, 0, len(sc.streams))
+	for _, st := range sc.streams {
+		streams = append(streams, st)
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	for _, st := range streams {
+		st.cancel()
+	}
+	sc.cancel()
+}
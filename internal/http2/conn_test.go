@@ -0,0 +1,712 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/http2/hpack"
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	b := appendFrame(nil, frameHeaders, flagEndHeaders|flagEndStream, 7, []byte("block"))
+	b[5] |= 0x80 // the reserved bit must be ignored
+
+	f, err := readFrame(bytes.NewReader(b), defaultMaxFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.typ != frameHeaders || f.streamID != 7 || !f.has(flagEndHeaders) || !f.has(flagEndStream) || string(f.payload) != "block" {
+		t.Errorf("got %+v", f)
+	}
+
+	big := appendFrame(nil, frameData, 0, 1, make([]byte, defaultMaxFrame+1))
+	var ce ConnError
+	if _, err := readFrame(bytes.NewReader(big), defaultMaxFrame); !errors.As(err, &ce) || ce.Code != ErrCodeFrameSize {
+		t.Errorf("oversized frame: got %v, want FRAME_SIZE_ERROR", err)
+	}
+}
+
+func TestUnpad(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   uint8
+		payload []byte
+		want    string
+		ok      bool
+	}{
+		{"not padded", 0, []byte("abc"), "abc", true},
+		{"padded", flagPadded, []byte("\x02abcxx"), "abc", true},
+		{"all padding", flagPadded, []byte("\x03xxx"), "", true},
+		{"no pad length", flagPadded, nil, "", false},
+		{"padding past the payload", flagPadded, []byte("\x04xxx"), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := frame{frameHeader: frameHeader{flags: tt.flags, length: uint32(len(tt.payload))}, payload: tt.payload}
+			got, err := unpad(f)
+			if (err == nil) != tt.ok || string(got) != tt.want {
+				t.Errorf("got %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestParseSettings(t *testing.T) {
+	payload := appendSettings(nil, setting{settingInitialWindowSize, 1}, setting{settingMaxFrameSize, 1 << 20})
+	settings, err := parseSettings(payload)
+	if err != nil || len(settings) != 2 || settings[1] != (setting{settingMaxFrameSize, 1 << 20}) {
+		t.Errorf("got %+v, %v", settings, err)
+	}
+	if _, err := parseSettings(payload[:7]); err == nil {
+		t.Error("7 byte SETTINGS accepted")
+	}
+}
+
+// testConn is the client end of a connection served over net.Pipe.
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	frames chan frame
+	done   chan error
+	dec    *hpack.Decoder
+	// stashed holds stream frames readResponse saw while waiting for
+	// another stream
+	stashed map[uint32][]frame
+}
+
+func testConfig() *config.Config {
+	return config.Load(4096, 1<<20, 8192, 5*time.Second, 2*time.Second)
+}
+
+// newTestConn serves a connection with srv and sends the client
+// preface and an empty SETTINGS. settings, if any, go in that SETTINGS.
+func newTestConn(t *testing.T, srv *Server, settings ...setting) *testConn {
+	t.Helper()
+	client, server := net.Pipe()
+	if srv.Config == nil {
+		srv.Config = testConfig()
+	}
+
+	tc := &testConn{
+		t:       t,
+		conn:    client,
+		frames:  make(chan frame, 64),
+		done:    make(chan error, 1),
+		dec:     hpack.NewDecoder(defaultHeaderTable),
+		stashed: map[uint32][]frame{},
+	}
+	go func() {
+		tc.done <- srv.ServeConn(context.Background(), server, nil, nil)
+		server.Close()
+	}()
+	go func() {
+		defer close(tc.frames)
+		for {
+			f, err := readFrame(client, maxAllowedFrame)
+			if err != nil {
+				return
+			}
+			tc.frames <- f
+		}
+	}()
+	t.Cleanup(func() { client.Close() })
+
+	if _, err := client.Write([]byte(ClientPreface)); err != nil {
+		t.Fatal(err)
+	}
+	tc.write(frameSettings, 0, 0, appendSettings(nil, settings...))
+
+	f := tc.next()
+	if f.typ != frameSettings || f.has(flagAck) {
+		t.Fatalf("server preface is %+v, want SETTINGS", f.frameHeader)
+	}
+	tc.expect(frameSettings, func(f frame) bool { return f.has(flagAck) })
+	return tc
+}
+
+func (tc *testConn) write(typ frameType, flags uint8, streamID uint32, payload []byte) {
+	tc.t.Helper()
+	if _, err := tc.conn.Write(appendFrame(nil, typ, flags, streamID, payload)); err != nil {
+		tc.t.Fatalf("writing %v frame: %v", typ, err)
+	}
+}
+
+func (tc *testConn) next() frame {
+	tc.t.Helper()
+	select {
+	case f, ok := <-tc.frames:
+		if !ok {
+			tc.t.Fatal("connection closed")
+		}
+		return f
+	case <-time.After(3 * time.Second):
+		tc.t.Fatal("timed out waiting for a frame")
+	}
+	return frame{}
+}
+
+// expect skips frames until one of typ that matches, when match is set.
+func (tc *testConn) expect(typ frameType, match func(frame) bool) frame {
+	tc.t.Helper()
+	for {
+		f := tc.next()
+		if f.typ == typ && (match == nil || match(f)) {
+			return f
+		}
+	}
+}
+
+// expectGoAway waits for GOAWAY and checks its error code, then for the
+// server to hang up.
+func (tc *testConn) expectGoAway(code ErrCode) {
+	tc.t.Helper()
+	f := tc.expect(frameGoAway, nil)
+	if got := ErrCode(binary.BigEndian.Uint32(f.payload[4:])); got != code {
+		tc.t.Errorf("GOAWAY %v (%s), want %v", got, f.payload[8:], code)
+	}
+	select {
+	case <-tc.done:
+	case <-time.After(3 * time.Second):
+		tc.t.Error("connection still open after GOAWAY")
+	}
+}
+
+func (tc *testConn) expectRST(id uint32, code ErrCode) {
+	tc.t.Helper()
+	f := tc.expect(frameRSTStream, nil)
+	if f.streamID != id {
+		tc.t.Errorf("RST_STREAM on stream %d, want %d", f.streamID, id)
+	}
+	if got := ErrCode(binary.BigEndian.Uint32(f.payload)); got != code {
+		tc.t.Errorf("RST_STREAM %v, want %v", got, code)
+	}
+}
+
+func requestBlock(method, path string, extra ...hpack.HeaderField) []byte {
+	var enc hpack.Encoder
+	fields := append([]hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: path},
+		{Name: ":authority", Value: "example.test"},
+	}, extra...)
+	var b []byte
+	for _, f := range fields {
+		b = enc.AppendField(b, f)
+	}
+	return b
+}
+
+func (tc *testConn) get(id uint32, path string) {
+	tc.t.Helper()
+	tc.write(frameHeaders, flagEndHeaders|flagEndStream, id, requestBlock("GET", path))
+}
+
+// readResponse collects the status and body of stream id.
+func (tc *testConn) readResponse(id uint32) (string, string) {
+	tc.t.Helper()
+	var status string
+	var body []byte
+	for {
+		var f frame
+		if stashed := tc.stashed[id]; len(stashed) > 0 {
+			f, tc.stashed[id] = stashed[0], stashed[1:]
+		} else if f = tc.next(); f.streamID != id {
+			if f.streamID != 0 {
+				tc.stashed[f.streamID] = append(tc.stashed[f.streamID], f)
+			}
+			continue
+		}
+		switch f.typ {
+		case frameHeaders:
+			fields, err := tc.dec.Decode(f.payload)
+			if err != nil {
+				tc.t.Fatal(err)
+			}
+			for _, hf := range fields {
+				if hf.Name == ":status" {
+					status = hf.Value
+				}
+			}
+		case frameData:
+			body = append(body, f.payload...)
+		case frameRSTStream:
+			tc.t.Fatalf("stream %d reset: %v", id, ErrCode(binary.BigEndian.Uint32(f.payload)))
+		}
+		if f.has(flagEndStream) {
+			return status, string(body)
+		}
+	}
+}
+
+// echoPath answers with the request path.
+func echoPath(req *request.Request, st response.Stream) {
+	st.WriteHeaders(200, map[string]string{"Content-Type": "text/plain"}, nil)
+	st.WriteData([]byte(req.Path))
+	st.End(nil)
+}
+
+func TestBadPreface(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	srv := &Server{Config: testConfig(), Handler: echoPath}
+	go func() { done <- srv.ServeConn(context.Background(), server, nil, nil) }()
+
+	go readFrame(client, maxAllowedFrame) // the server's SETTINGS
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	if err := <-done; !errors.Is(err, ErrBadPreface) {
+		t.Errorf("got %v, want ErrBadPreface", err)
+	}
+}
+
+func TestServerPreface(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	srv := &Server{Config: testConfig(), Handler: echoPath, MaxConcurrentStreams: 7}
+	go srv.ServeConn(context.Background(), server, nil, nil)
+
+	f, err := readFrame(client, maxAllowedFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, err := parseSettings(f.payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[settingID]uint32{settingMaxConcurrentStreams: 7, settingEnablePush: 0, settingMaxHeaderListSize: 8192}
+	for _, s := range settings {
+		if v, ok := want[s.id]; ok && v != s.val {
+			t.Errorf("setting %d = %d, want %d", s.id, s.val, v)
+		}
+		delete(want, s.id)
+	}
+	if len(want) != 0 {
+		t.Errorf("settings missing: %v", want)
+	}
+}
+
+func TestRequestResponse(t *testing.T) {
+	tc := newTestConn(t, &Server{Handler: echoPath})
+	tc.get(1, "/one")
+	tc.get(3, "/three")
+	for id, path := range map[uint32]string{1: "/one", 3: "/three"} {
+		if status, body := tc.readResponse(id); status != "200" || body != path {
+			t.Errorf("stream %d: %s %q", id, status, body)
+		}
+	}
+
+	tc.write(framePing, 0, 0, []byte("12345678"))
+	f := tc.expect(framePing, nil)
+	if !f.has(flagAck) || string(f.payload) != "12345678" {
+		t.Errorf("PING answered with %+v", f)
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	tc := newTestConn(t, &Server{Handler: func(req *request.Request, st response.Stream) {
+		st.WriteHeaders(200, nil, nil)
+		st.WriteData(req.Body)
+		st.End(nil)
+	}})
+	tc.write(frameHeaders, flagEndHeaders, 1, requestBlock("POST", "/", hpack.HeaderField{Name: "content-length", Value: "10"}))
+	tc.write(frameData, flagPadded, 1, []byte("\x03hello___"))
+	tc.write(frameData, flagEndStream, 1, []byte("world"))
+	if status, body := tc.readResponse(1); status != "200" || body != "helloworld" {
+		t.Errorf("got %s %q", status, body)
+	}
+
+	// a body that disagrees with content-length is malformed
+	tc.write(frameHeaders, flagEndHeaders, 3, requestBlock("POST", "/", hpack.HeaderField{Name: "content-length", Value: "3"}))
+	tc.write(frameData, flagEndStream, 3, []byte("toolong"))
+	tc.expectRST(3, ErrCodeProtocol)
+}
+
+func TestContinuation(t *testing.T) {
+	tc := newTestConn(t, &Server{Handler: echoPath})
+	block := requestBlock("GET", "/continued", hpack.HeaderField{Name: "x-long", Value: strings.Repeat("a", 100)})
+	tc.write(frameHeaders, flagEndStream, 1, block[:10])
+	tc.write(frameContinuation, 0, 1, block[10:50])
+	tc.write(frameContinuation, flagEndHeaders, 1, block[50:])
+	if status, body := tc.readResponse(1); status != "200" || body != "/continued" {
+		t.Errorf("got %s %q", status, body)
+	}
+}
+
+func TestConnectionErrors(t *testing.T) {
+	block := requestBlock("GET", "/")
+	tests := []struct {
+		name string
+		send func(tc *testConn)
+		code ErrCode
+	}{
+		{"HEADERS on stream 0", func(tc *testConn) {
+			tc.write(frameHeaders, flagEndHeaders|flagEndStream, 0, block)
+		}, ErrCodeProtocol},
+		{"even stream id", func(tc *testConn) {
+			tc.write(frameHeaders, flagEndHeaders|flagEndStream, 2, block)
+		}, ErrCodeProtocol},
+		{"decreasing stream id", func(tc *testConn) {
+			tc.get(5, "/")
+			tc.readResponse(5)
+			tc.get(3, "/")
+		}, ErrCodeProtocol},
+		{"frame between HEADERS and CONTINUATION", func(tc *testConn) {
+			tc.write(frameHeaders, flagEndStream, 1, block[:3])
+			tc.write(framePing, 0, 0, make([]byte, 8))
+		}, ErrCodeProtocol},
+		{"CONTINUATION on another stream", func(tc *testConn) {
+			tc.write(frameHeaders, flagEndStream, 1, block[:3])
+			tc.write(frameContinuation, flagEndHeaders, 3, block[3:])
+		}, ErrCodeProtocol},
+		{"CONTINUATION without HEADERS", func(tc *testConn) {
+			tc.write(frameContinuation, flagEndHeaders, 1, block)
+		}, ErrCodeProtocol},
+		{"undecodable header block", func(tc *testConn) {
+			tc.write(frameHeaders, flagEndHeaders|flagEndStream, 1, []byte{0xff, 0xff, 0xff})
+		}, ErrCodeCompression},
+		{"DATA on stream 0", func(tc *testConn) {
+			tc.write(frameData, 0, 0, []byte("x"))
+		}, ErrCodeProtocol},
+		{"DATA on an idle stream", func(tc *testConn) {
+			tc.write(frameData, 0, 9, []byte("x"))
+		}, ErrCodeProtocol},
+		{"RST_STREAM on stream 0", func(tc *testConn) {
+			tc.write(frameRSTStream, 0, 0, make([]byte, 4))
+		}, ErrCodeProtocol},
+		{"RST_STREAM on an idle stream", func(tc *testConn) {
+			tc.write(frameRSTStream, 0, 11, make([]byte, 4))
+		}, ErrCodeProtocol},
+		{"RST_STREAM of 3 bytes", func(tc *testConn) {
+			tc.write(frameRSTStream, 0, 1, make([]byte, 3))
+		}, ErrCodeFrameSize},
+		{"SETTINGS on a stream", func(tc *testConn) {
+			tc.write(frameSettings, 0, 1, nil)
+		}, ErrCodeProtocol},
+		{"SETTINGS ack with payload", func(tc *testConn) {
+			tc.write(frameSettings, flagAck, 0, make([]byte, 6))
+		}, ErrCodeFrameSize},
+		{"SETTINGS of 5 bytes", func(tc *testConn) {
+			tc.write(frameSettings, 0, 0, make([]byte, 5))
+		}, ErrCodeFrameSize},
+		{"ENABLE_PUSH of 2", func(tc *testConn) {
+			tc.write(frameSettings, 0, 0, appendSettings(nil, setting{settingEnablePush, 2}))
+		}, ErrCodeProtocol},
+		{"INITIAL_WINDOW_SIZE above 2^31-1", func(tc *testConn) {
+			tc.write(frameSettings, 0, 0, appendSettings(nil, setting{settingInitialWindowSize, 1 << 31}))
+		}, ErrCodeFlowControl},
+		{"MAX_FRAME_SIZE below 16384", func(tc *testConn) {
+			tc.write(frameSettings, 0, 0, appendSettings(nil, setting{settingMaxFrameSize, 16383}))
+		}, ErrCodeProtocol},
+		{"PING on a stream", func(tc *testConn) {
+			tc.write(framePing, 0, 1, make([]byte, 8))
+		}, ErrCodeProtocol},
+		{"PING of 7 bytes", func(tc *testConn) {
+			tc.write(framePing, 0, 0, make([]byte, 7))
+		}, ErrCodeFrameSize},
+		{"PUSH_PROMISE from the client", func(tc *testConn) {
+			tc.write(framePushPromise, flagEndHeaders, 1, make([]byte, 4))
+		}, ErrCodeProtocol},
+		{"oversized frame", func(tc *testConn) {
+			// the server hangs up before reading the payload
+			go tc.conn.Write(appendFrame(nil, frameData, 0, 1, make([]byte, defaultMaxFrame+1)))
+		}, ErrCodeFrameSize},
+		{"zero connection WINDOW_UPDATE", func(tc *testConn) {
+			tc.write(frameWindowUpdate, 0, 0, make([]byte, 4))
+		}, ErrCodeProtocol},
+		{"connection WINDOW_UPDATE overflow", func(tc *testConn) {
+			tc.write(frameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, maxWindowSize))
+		}, ErrCodeFlowControl},
+		{"WINDOW_UPDATE of 3 bytes", func(tc *testConn) {
+			tc.write(frameWindowUpdate, 0, 0, make([]byte, 3))
+		}, ErrCodeFrameSize},
+		{"WINDOW_UPDATE on an idle stream", func(tc *testConn) {
+			tc.write(frameWindowUpdate, 0, 9, binary.BigEndian.AppendUint32(nil, 1))
+		}, ErrCodeProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestConn(t, &Server{Handler: echoPath})
+			tt.send(tc)
+			tc.expectGoAway(tt.code)
+		})
+	}
+}
+
+func TestFirstFrameMustBeSettings(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	srv := &Server{Config: testConfig(), Handler: echoPath}
+	go srv.ServeConn(context.Background(), server, nil, nil)
+
+	frames := make(chan frame, 8)
+	go func() {
+		for {
+			f, err := readFrame(client, maxAllowedFrame)
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- f
+		}
+	}()
+	client.Write([]byte(ClientPreface))
+	client.Write(appendFrame(nil, framePing, 0, 0, make([]byte, 8)))
+
+	for f := range frames {
+		if f.typ == frameGoAway {
+			if code := ErrCode(binary.BigEndian.Uint32(f.payload[4:])); code != ErrCodeProtocol {
+				t.Errorf("GOAWAY %v, want PROTOCOL_ERROR", code)
+			}
+			return
+		}
+	}
+	t.Error("no GOAWAY")
+}
+
+// blockingServer runs handlers that wait for release, so streams stay
+// open; started receives each stream's request.
+func blockingServer(release <-chan struct{}, started chan<- *request.Request) *Server {
+	return &Server{Handler: func(req *request.Request, st response.Stream) {
+		started <- req
+		select {
+		case <-release:
+		case <-req.Context.Done():
+			return
+		}
+		echoPath(req, st)
+	}}
+}
+
+func TestStreamErrors(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan *request.Request, 4)
+	tc := newTestConn(t, blockingServer(release, started))
+	tc.get(1, "/running")
+	<-started
+
+	// stream errors leave the connection and stream 1 alone
+	tc.write(frameWindowUpdate, 0, 1, make([]byte, 4))
+	tc.expectRST(1, ErrCodeProtocol)
+
+	tc.get(3, "/running")
+	<-started
+	tc.write(frameWindowUpdate, 0, 3, binary.BigEndian.AppendUint32(nil, maxWindowSize))
+	tc.expectRST(3, ErrCodeFlowControl)
+
+	tc.write(frameHeaders, flagEndHeaders|flagEndStream, 7, requestBlock("GET", "relative"))
+	tc.expectRST(7, ErrCodeProtocol)
+
+	tc.write(frameHeaders, flagEndHeaders|flagEndStream, 9, requestBlock("GET", "/", hpack.HeaderField{Name: "connection", Value: "close"}))
+	tc.expectRST(9, ErrCodeProtocol)
+
+	tc.write(framePriority, 0, 11, make([]byte, 4))
+	tc.expectRST(11, ErrCodeFrameSize)
+
+	close(release)
+	tc.get(13, "/after")
+	if status, body := tc.readResponse(13); status != "200" || body != "/after" {
+		t.Errorf("got %s %q", status, body)
+	}
+}
+
+func TestClientReset(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan *request.Request, 1)
+	tc := newTestConn(t, blockingServer(release, started))
+	tc.get(1, "/cancel-me")
+	req := <-started
+
+	tc.write(frameRSTStream, 0, 1, binary.BigEndian.AppendUint32(nil, uint32(ErrCodeCancel)))
+	select {
+	case <-req.Context.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("handler context not cancelled by RST_STREAM")
+	}
+
+	// a late frame on the closed stream leaves the connection alone
+	tc.write(frameData, flagEndStream, 1, []byte("late"))
+	tc.write(framePing, 0, 0, make([]byte, 8))
+	tc.expect(framePing, nil)
+	tc.get(3, "/next")
+	close(release)
+	if status, _ := tc.readResponse(3); status != "200" {
+		t.Errorf("stream 3 got %s", status)
+	}
+}
+
+func TestMaxConcurrentStreams(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan *request.Request, 4)
+	srv := blockingServer(release, started)
+	srv.MaxConcurrentStreams = 1
+	tc := newTestConn(t, srv)
+
+	tc.get(1, "/first")
+	<-started
+	tc.get(3, "/second")
+	tc.expectRST(3, ErrCodeRefusedStream)
+	close(release)
+	if status, _ := tc.readResponse(1); status != "200" {
+		t.Errorf("stream 1 got %s", status)
+	}
+}
+
+func TestFlowControl(t *testing.T) {
+	body := strings.Repeat("x", 25)
+	srv := &Server{Handler: func(req *request.Request, st response.Stream) {
+		st.WriteHeaders(200, nil, nil)
+		st.WriteData([]byte(body))
+		st.End(nil)
+	}}
+	tc := newTestConn(t, srv, setting{settingInitialWindowSize, 10})
+	tc.get(1, "/")
+
+	var got []byte
+	read := func() {
+		f := tc.expect(frameData, nil)
+		got = append(got, f.payload...)
+	}
+	read()
+	if len(got) != 10 {
+		t.Fatalf("first DATA of %d bytes, want the 10 byte window", len(got))
+	}
+	select {
+	case f := <-tc.frames:
+		t.Fatalf("sent %v with an exhausted window", f.typ)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// a larger initial window applies to the open stream too
+	tc.write(frameSettings, 0, 0, appendSettings(nil, setting{settingInitialWindowSize, 15}))
+	read()
+	tc.write(frameWindowUpdate, 0, 1, binary.BigEndian.AppendUint32(nil, 100))
+	for len(got) < len(body) {
+		read()
+	}
+	if string(got) != body {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRecvWindowReplenished(t *testing.T) {
+	tc := newTestConn(t, &Server{Handler: func(req *request.Request, st response.Stream) {
+		st.WriteHeaders(200, nil, nil)
+		st.WriteData([]byte{byte(len(req.Body) >> 16), byte(len(req.Body) >> 8), byte(len(req.Body))})
+		st.End(nil)
+	}})
+	tc.write(frameHeaders, flagEndHeaders, 1, requestBlock("POST", "/"))
+	chunk := make([]byte, defaultMaxFrame)
+	var updates int
+	// twice the initial window only gets through if it is handed back
+	for sent := 0; sent < 2*defaultWindowSize; sent += len(chunk) {
+		tc.write(frameData, 0, 1, chunk)
+		for {
+			select {
+			case f := <-tc.frames:
+				if f.typ == frameWindowUpdate {
+					updates++
+				}
+				continue
+			default:
+			}
+			break
+		}
+	}
+	tc.write(frameData, flagEndStream, 1, nil)
+	if status, _ := tc.readResponse(1); status != "200" {
+		t.Errorf("got %s", status)
+	}
+	if updates == 0 {
+		t.Error("no WINDOW_UPDATE for the request body")
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan *request.Request, 2)
+	shutdown := make(chan struct{})
+	srv := blockingServer(release, started)
+	srv.Shutdown = shutdown
+	tc := newTestConn(t, srv)
+
+	tc.get(1, "/in-flight")
+	<-started
+	close(shutdown)
+
+	f := tc.expect(frameGoAway, nil)
+	if last := binary.BigEndian.Uint32(f.payload); last != 1 {
+		t.Errorf("GOAWAY last stream %d, want 1", last)
+	}
+	if code := ErrCode(binary.BigEndian.Uint32(f.payload[4:])); code != ErrCodeNo {
+		t.Errorf("GOAWAY %v, want NO_ERROR", code)
+	}
+
+	tc.get(3, "/too-late")
+	tc.expectRST(3, ErrCodeRefusedStream)
+
+	close(release)
+	if status, body := tc.readResponse(1); status != "200" || body != "/in-flight" {
+		t.Errorf("stream 1 got %s %q", status, body)
+	}
+	select {
+	case err := <-tc.done:
+		if err != nil {
+			t.Errorf("ServeConn returned %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("connection still open once drained")
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	base := []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: "/p"},
+		{Name: ":authority", Value: "example.test"},
+	}
+	with := func(extra ...hpack.HeaderField) []hpack.HeaderField {
+		return append(append([]hpack.HeaderField(nil), base...), extra...)
+	}
+
+	req, err := newRequest(with(
+		hpack.HeaderField{Name: "cookie", Value: "a=1"},
+		hpack.HeaderField{Name: "cookie", Value: "b=2"},
+		hpack.HeaderField{Name: "accept", Value: "text/html"},
+		hpack.HeaderField{Name: "accept", Value: "*/*"},
+		hpack.HeaderField{Name: "te", Value: "trailers"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Headers["host"] != "example.test" || req.Headers["cookie"] != "a=1; b=2" || req.Headers["accept"] != "text/html, */*" {
+		t.Errorf("headers %v", req.Headers)
+	}
+
+	bad := map[string][]hpack.HeaderField{
+		"pseudo after regular": with(hpack.HeaderField{Name: "x", Value: "1"}, hpack.HeaderField{Name: ":status", Value: "1"}),
+		"unknown pseudo":       with(hpack.HeaderField{Name: ":protocol", Value: "ws"}),
+		"duplicate :path":      append([]hpack.HeaderField{{Name: ":path", Value: "/x"}}, base...),
+		"uppercase name":       with(hpack.HeaderField{Name: "X-Up", Value: "1"}),
+		"connection header":    with(hpack.HeaderField{Name: "keep-alive", Value: "1"}),
+		"TE gzip":              with(hpack.HeaderField{Name: "te", Value: "gzip"}),
+		"missing :scheme":      {base[0], base[2]},
+		"CONNECT":              {{Name: ":method", Value: "CONNECT"}, {Name: ":authority", Value: "example.test:443"}},
+		"relative :path":       {base[0], base[1], {Name: ":path", Value: "p"}},
+	}
+	for name, fields := range bad {
+		if _, err := newRequest(fields); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
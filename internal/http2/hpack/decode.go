@@ -0,0 +1,189 @@
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTruncated       = errors.New("hpack: truncated header block")
+	ErrIntegerOverflow = errors.New("hpack: integer overflow")
+	ErrStringTooLong   = errors.New("hpack: string exceeds limit")
+	ErrListTooLarge    = errors.New("hpack: header list exceeds limit")
+)
+
+// Decoder decompresses the header blocks of one connection, in order.
+type Decoder struct {
+	table dynamicTable
+	// allowedMax is the table size we advertised; the peer may only shrink
+	// the table below it.
+	allowedMax uint32
+
+	// MaxStringLength caps single names and values, and MaxHeaderListSize
+	// the whole decoded list as counted by RFC 7540 section 6.5.2; zero
+	// means unlimited.
+	MaxStringLength   int
+	MaxHeaderListSize uint32
+}
+
+// NewDecoder returns a decoder whose dynamic table may grow to maxTableSize,
+// the SETTINGS_HEADER_TABLE_SIZE advertised to the peer.
+func NewDecoder(maxTableSize uint32) *Decoder {
+	d := &Decoder{allowedMax: maxTableSize}
+	d.table.setMaxSize(maxTableSize)
+	return d
+}
+
+// Decode returns the fields of a complete header block. A block over
+// MaxHeaderListSize is still decoded in full, then rejected with
+// ErrListTooLarge, so the connection can carry on.
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	var listSize uint32
+	sawField, tooLarge := false, false
+
+	for len(block) > 0 {
+		b := block[0]
+		var f HeaderField
+		var err error
+
+		switch {
+		case b&0x80 != 0: // indexed
+			var i uint64
+			if i, block, err = readInt(block, 7); err != nil {
+				return nil, err
+			}
+			var ok bool
+			if f, ok = d.table.at(i); !ok {
+				return nil, fmt.Errorf("hpack: invalid index %d", i)
+			}
+		case b&0xc0 == 0x40: // literal with incremental indexing
+			if f, block, err = d.readLiteral(block, 6); err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+		case b&0xe0 == 0x20: // dynamic table size update
+			if sawField {
+				return nil, errors.New("hpack: table size update after a header field")
+			}
+			var n uint64
+			if n, block, err = readInt(block, 5); err != nil {
+				return nil, err
+			}
+			if n > uint64(d.allowedMax) {
+				return nil, errors.New("hpack: table size update above the advertised limit")
+			}
+			d.table.setMaxSize(uint32(n))
+			continue
+		default: // literal without indexing (0000) or never indexed (0001)
+			if f, block, err = d.readLiteral(block, 4); err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0x10 != 0
+		}
+
+		sawField = true
+		listSize += f.Size()
+		if d.MaxHeaderListSize > 0 && listSize > d.MaxHeaderListSize {
+			// keep decoding so the dynamic table stays in step with the peer
+			tooLarge = true
+			fields = nil
+			continue
+		}
+		fields = append(fields, f)
+	}
+	if tooLarge {
+		return nil, ErrListTooLarge
+	}
+	return fields, nil
+}
+
+func (d *Decoder) readLiteral(block []byte, prefix uint8) (HeaderField, []byte, error) {
+	var f HeaderField
+	i, block, err := readInt(block, prefix)
+	if err != nil {
+		return f, nil, err
+	}
+
+	if i > 0 {
+		named, ok := d.table.at(i)
+		if !ok {
+			return f, nil, fmt.Errorf("hpack: invalid index %d", i)
+		}
+		f.Name = named.Name
+	} else if f.Name, block, err = d.readString(block); err != nil {
+		return f, nil, err
+	}
+
+	if f.Value, block, err = d.readString(block); err != nil {
+		return f, nil, err
+	}
+	return f, block, nil
+}
+
+func (d *Decoder) readString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, ErrTruncated
+	}
+	huffman := block[0]&0x80 != 0
+
+	n, block, err := readInt(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if n > uint64(len(block)) {
+		return "", nil, ErrTruncated
+	}
+	raw := block[:n]
+	block = block[n:]
+
+	if !huffman {
+		if d.MaxStringLength > 0 && len(raw) > d.MaxStringLength {
+			return "", nil, ErrStringTooLong
+		}
+		return string(raw), block, nil
+	}
+
+	// Huffman never shrinks below 5 bits a byte, so check before decoding
+	if d.MaxStringLength > 0 && len(raw)*8/5 > d.MaxStringLength {
+		return "", nil, ErrStringTooLong
+	}
+	s, err := huffmanDecode(nil, raw)
+	if err != nil {
+		return "", nil, err
+	}
+	if d.MaxStringLength > 0 && len(s) > d.MaxStringLength {
+		return "", nil, ErrStringTooLong
+	}
+	return string(s), block, nil
+}
+
+// readInt decodes an integer with an n-bit prefix (RFC 7541 section 5.1).
+func readInt(block []byte, n uint8) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, ErrTruncated
+	}
+	mask := uint64(1)<<n - 1
+	i := uint64(block[0]) & mask
+	block = block[1:]
+	if i < mask {
+		return i, block, nil
+	}
+
+	var shift uint
+	for {
+		if len(block) == 0 {
+			return 0, nil, ErrTruncated
+		}
+		b := block[0]
+		block = block[1:]
+		if shift >= 63 {
+			return 0, nil, ErrIntegerOverflow
+		}
+		i += uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return i, block, nil
+		}
+	}
+}
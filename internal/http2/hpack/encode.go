@@ -0,0 +1,60 @@
+package hpack
+
+// Encoder compresses header blocks. It only references the static table
+// and never adds entries to the peer's dynamic table, so it needs no
+// state and stays valid whatever table size the peer allows.
+type Encoder struct{}
+
+// AppendField appends the representation of f to dst: an indexed field
+// when the static table holds it, otherwise a literal that reuses a static
+// name where possible.
+func (Encoder) AppendField(dst []byte, f HeaderField) []byte {
+	nameIndex := 0
+	for i, s := range staticTable {
+		if s.Name != f.Name {
+			continue
+		}
+		if s.Value == f.Value && !f.Sensitive {
+			return appendInt(dst, 0x80, 7, uint64(i+1))
+		}
+		if nameIndex == 0 {
+			nameIndex = i + 1
+		}
+	}
+
+	first := byte(0x00) // literal without indexing
+	if f.Sensitive {
+		first = 0x10 // never indexed
+	}
+	dst = appendInt(dst, first, 4, uint64(nameIndex))
+	if nameIndex == 0 {
+		dst = appendString(dst, f.Name)
+	}
+	return appendString(dst, f.Value)
+}
+
+// appendString writes s as a string literal, Huffman-coded when shorter.
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanEncodedLen(s); n < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return huffmanEncode(dst, s)
+	}
+	dst = appendInt(dst, 0x00, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// appendInt encodes i with an n-bit prefix, first carrying the bits above
+// the prefix (RFC 7541 section 5.1).
+func appendInt(dst []byte, first byte, n uint8, i uint64) []byte {
+	mask := uint64(1)<<n - 1
+	if i < mask {
+		return append(dst, first|byte(i))
+	}
+	dst = append(dst, first|byte(mask))
+	i -= mask
+	for i >= 0x80 {
+		dst = append(dst, byte(i&0x7f)|0x80)
+		i >>= 7
+	}
+	return append(dst, byte(i))
+}
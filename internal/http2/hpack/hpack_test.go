@@ -0,0 +1,378 @@
+package hpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func fieldsEqual(a, b []HeaderField) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestIntegers is RFC 7541 Appendix C.1.
+func TestIntegers(t *testing.T) {
+	tests := []struct {
+		name   string
+		i      uint64
+		prefix uint8
+		enc    string
+	}{
+		{"C.1.1 10 in a 5-bit prefix", 10, 5, "0a"},
+		{"C.1.2 1337 in a 5-bit prefix", 1337, 5, "1f9a0a"},
+		{"C.1.3 42 at an octet boundary", 42, 8, "2a"},
+		{"prefix boundary", 31, 5, "1f00"},
+		{"max uint32", 1<<32 - 1, 7, "7f80ffffff0f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := mustHex(t, tt.enc)
+			if got := appendInt(nil, 0, tt.prefix, tt.i); !bytes.Equal(got, want) {
+				t.Errorf("appendInt = %x, want %x", got, want)
+			}
+			i, rest, err := readInt(want, tt.prefix)
+			if err != nil || i != tt.i || len(rest) != 0 {
+				t.Errorf("readInt = %d, %x, %v", i, rest, err)
+			}
+		})
+	}
+}
+
+func TestIntegerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want error
+	}{
+		{"empty", "", ErrTruncated},
+		{"missing continuation", "1f", ErrTruncated},
+		{"continuation bit never cleared", "1fffff", ErrTruncated},
+		{"overflow", "1f" + strings.Repeat("ff", 10) + "01", ErrIntegerOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := readInt(mustHex(t, tt.in), 5); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestFieldRepresentations is RFC 7541 Appendix C.2.
+func TestFieldRepresentations(t *testing.T) {
+	tests := []struct {
+		name      string
+		block     string
+		want      HeaderField
+		tableSize uint32
+	}{
+		{"C.2.1 literal with indexing",
+			"400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572",
+			HeaderField{Name: "custom-key", Value: "custom-header"}, 55},
+		{"C.2.2 literal without indexing",
+			"040c 2f73 616d 706c 652f 7061 7468",
+			HeaderField{Name: ":path", Value: "/sample/path"}, 0},
+		{"C.2.3 literal never indexed",
+			"1008 7061 7373 776f 7264 0673 6563 7265 74",
+			HeaderField{Name: "password", Value: "secret", Sensitive: true}, 0},
+		{"C.2.4 indexed",
+			"82",
+			HeaderField{Name: ":method", Value: "GET"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(4096)
+			fields, err := d.Decode(mustHex(t, tt.block))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fieldsEqual(fields, []HeaderField{tt.want}) {
+				t.Errorf("got %+v, want %+v", fields, tt.want)
+			}
+			if d.table.size != tt.tableSize {
+				t.Errorf("table size %d, want %d", d.table.size, tt.tableSize)
+			}
+		})
+	}
+}
+
+type block struct {
+	hex       string
+	fields    []HeaderField
+	table     []HeaderField // newest first
+	tableSize uint32
+}
+
+func runBlocks(t *testing.T, maxTable uint32, blocks []block) {
+	t.Helper()
+	d := NewDecoder(maxTable)
+	for i, b := range blocks {
+		fields, err := d.Decode(mustHex(t, b.hex))
+		if err != nil {
+			t.Fatalf("block %d: %v", i+1, err)
+		}
+		if !fieldsEqual(fields, b.fields) {
+			t.Errorf("block %d: got %+v, want %+v", i+1, fields, b.fields)
+		}
+		var table []HeaderField
+		for j := len(d.table.entries) - 1; j >= 0; j-- {
+			table = append(table, d.table.entries[j])
+		}
+		if !fieldsEqual(table, b.table) {
+			t.Errorf("block %d: table %+v, want %+v", i+1, table, b.table)
+		}
+		if d.table.size != b.tableSize {
+			t.Errorf("block %d: table size %d, want %d", i+1, d.table.size, b.tableSize)
+		}
+	}
+}
+
+var (
+	authority    = HeaderField{Name: ":authority", Value: "www.example.com"}
+	noCache      = HeaderField{Name: "cache-control", Value: "no-cache"}
+	customValue  = HeaderField{Name: "custom-key", Value: "custom-value"}
+	requestBlock = [][]HeaderField{
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, authority},
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, authority, noCache},
+		{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "https"}, {Name: ":path", Value: "/index.html"}, authority, customValue},
+	}
+)
+
+// TestRequestsWithoutHuffman is RFC 7541 Appendix C.3.
+func TestRequestsWithoutHuffman(t *testing.T) {
+	runBlocks(t, 4096, []block{
+		{"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+			requestBlock[0], []HeaderField{authority}, 57},
+		{"8286 84be 5808 6e6f 2d63 6163 6865",
+			requestBlock[1], []HeaderField{noCache, authority}, 110},
+		{"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+			requestBlock[2], []HeaderField{customValue, noCache, authority}, 164},
+	})
+}
+
+// TestRequestsWithHuffman is RFC 7541 Appendix C.4.
+func TestRequestsWithHuffman(t *testing.T) {
+	runBlocks(t, 4096, []block{
+		{"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+			requestBlock[0], []HeaderField{authority}, 57},
+		{"8286 84be 5886 a8eb 1064 9cbf",
+			requestBlock[1], []HeaderField{noCache, authority}, 110},
+		{"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+			requestBlock[2], []HeaderField{customValue, noCache, authority}, 164},
+	})
+}
+
+var (
+	status302  = HeaderField{Name: ":status", Value: "302"}
+	status307  = HeaderField{Name: ":status", Value: "307"}
+	private    = HeaderField{Name: "cache-control", Value: "private"}
+	date21     = HeaderField{Name: "date", Value: "Mon, 21 Oct 2013 20:13:21 GMT"}
+	date22     = HeaderField{Name: "date", Value: "Mon, 21 Oct 2013 20:13:22 GMT"}
+	location   = HeaderField{Name: "location", Value: "https://www.example.com"}
+	gzip       = HeaderField{Name: "content-encoding", Value: "gzip"}
+	setCookie  = HeaderField{Name: "set-cookie", Value: "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"}
+	responses  = [][]HeaderField{{status302, private, date21, location}, {status307, private, date21, location}, {{Name: ":status", Value: "200"}, private, date22, location, gzip, setCookie}}
+	respTables = [][]HeaderField{{location, date21, private, status302}, {status307, location, date21, private}, {setCookie, gzip, date22}}
+)
+
+// TestResponsesWithoutHuffman is RFC 7541 Appendix C.5; the 256 byte
+// table makes every block evict entries.
+func TestResponsesWithoutHuffman(t *testing.T) {
+	runBlocks(t, 256, []block{
+		{"4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3120 474d 546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+			responses[0], respTables[0], 222},
+		{"4803 3330 37c1 c0bf",
+			responses[1], respTables[1], 222},
+		{"88c1 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3220 474d 54c0 5a04 677a 6970 7738 666f 6f3d 4153 444a 4b48 514b 425a 584f 5157 454f 5049 5541 5851 5745 4f49 553b 206d 6178 2d61 6765 3d33 3630 303b 2076 6572 7369 6f6e 3d31",
+			responses[2], respTables[2], 215},
+	})
+}
+
+// TestResponsesWithHuffman is RFC 7541 Appendix C.6.
+func TestResponsesWithHuffman(t *testing.T) {
+	runBlocks(t, 256, []block{
+		{"4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3",
+			responses[0], respTables[0], 222},
+		{"4883 640e ffc1 c0bf",
+			responses[1], respTables[1], 222},
+		{"88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab 77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed 4ee5 b106 3d50 07",
+			responses[2], respTables[2], 215},
+	})
+}
+
+func TestHuffman(t *testing.T) {
+	tests := []struct{ s, enc string }{
+		{"www.example.com", "f1e3 c2e5 f23a 6ba0 ab90 f4ff"},
+		{"no-cache", "a8eb 1064 9cbf"},
+		{"custom-key", "25a8 49e9 5ba9 7d7f"},
+		{"302", "6402"},
+		{"private", "aec3 771a 4b"},
+	}
+	for _, tt := range tests {
+		want := mustHex(t, tt.enc)
+		if n := huffmanEncodedLen(tt.s); n != len(want) {
+			t.Errorf("%q: encoded length %d, want %d", tt.s, n, len(want))
+		}
+		if got := huffmanEncode(nil, tt.s); !bytes.Equal(got, want) {
+			t.Errorf("%q: encoded %x, want %x", tt.s, got, want)
+		}
+		if got, err := huffmanDecode(nil, want); err != nil || string(got) != tt.s {
+			t.Errorf("%x: decoded %q, %v", want, got, err)
+		}
+	}
+
+	// every byte value survives the round trip
+	var all []byte
+	for i := 0; i < 256; i++ {
+		all = append(all, byte(i))
+	}
+	if got, err := huffmanDecode(nil, huffmanEncode(nil, string(all))); err != nil || !bytes.Equal(got, all) {
+		t.Errorf("all bytes: %v", err)
+	}
+}
+
+func TestHuffmanInvalid(t *testing.T) {
+	tests := []struct{ name, in string }{
+		// "a" is 00011; padding with zeros is not a prefix of EOS
+		{"padding not all ones", "18"},
+		// a whole byte of ones is more padding than allowed
+		{"padding of 8 bits", "1fff"},
+		// EOS, 30 ones, decoded as a symbol
+		{"EOS in the string", "ffff ffff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := huffmanDecode(nil, mustHex(t, tt.in)); !errors.Is(err, ErrInvalidHuffman) {
+				t.Errorf("got %v, want ErrInvalidHuffman", err)
+			}
+		})
+	}
+}
+
+func TestDynamicTableSizeUpdate(t *testing.T) {
+	d := NewDecoder(4096)
+	if _, err := d.Decode(mustHex(t, "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572")); err != nil {
+		t.Fatal(err)
+	}
+
+	// shrinking to zero empties the table, so index 62 is gone
+	if _, err := d.Decode(mustHex(t, "20 82")); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.table.entries) != 0 || d.table.size != 0 {
+		t.Errorf("table holds %+v after a size update to 0", d.table.entries)
+	}
+	if _, err := d.Decode(mustHex(t, "be")); err == nil {
+		t.Error("index 62 resolved in an empty table")
+	}
+
+	// an entry larger than the table empties it and is not added
+	d = NewDecoder(50)
+	if _, err := d.Decode(mustHex(t, "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572")); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.table.entries) != 0 {
+		t.Errorf("55 byte entry kept in a 50 byte table")
+	}
+
+	tests := []struct{ name, in string }{
+		{"above the advertised size", "3fe2 1f"}, // 4097
+		{"after a header field", "82 20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDecoder(4096).Decode(mustHex(t, tt.in)); err == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct{ name, in string }{
+		{"index 0", "80"},
+		{"index past the tables", "ff00"},
+		{"literal name index past the tables", "7f00 0161"},
+		{"truncated string", "0003 6162"},
+		{"missing value", "0001 61"},
+		{"bad Huffman string", "0081 18 00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDecoder(4096).Decode(mustHex(t, tt.in)); err == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	d := NewDecoder(4096)
+	d.MaxStringLength = 8
+	if _, err := d.Decode(mustHex(t, "0009 6162 6364 6566 6768 69 00")); !errors.Is(err, ErrStringTooLong) {
+		t.Errorf("9 byte name: got %v, want ErrStringTooLong", err)
+	}
+
+	// an over-long list is rejected but still indexed, so the table stays
+	// in step with the encoder's
+	d = NewDecoder(4096)
+	d.MaxHeaderListSize = 60
+	block := mustHex(t, "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572 82")
+	if _, err := d.Decode(block); !errors.Is(err, ErrListTooLarge) {
+		t.Fatalf("got %v, want ErrListTooLarge", err)
+	}
+	fields, err := d.Decode(mustHex(t, "be"))
+	if err != nil || len(fields) != 1 || fields[0].Value != "custom-header" {
+		t.Errorf("next block got %+v, %v", fields, err)
+	}
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	fields := []HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: ":status", Value: "418"},
+		{Name: "content-type", Value: "text/plain; charset=utf-8"},
+		{Name: "x-custom", Value: strings.Repeat("v", 200)},
+		{Name: "set-cookie", Value: "id=1", Sensitive: true},
+		{Name: "x-empty", Value: ""},
+	}
+	var enc Encoder
+	var b []byte
+	for _, f := range fields {
+		b = enc.AppendField(b, f)
+	}
+	if b[0] != 0x88 {
+		t.Errorf(":status 200 encoded as %#x, want the static index 0x88", b[0])
+	}
+
+	d := NewDecoder(4096)
+	got, err := d.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fieldsEqual(got, fields) {
+		t.Errorf("got %+v, want %+v", got, fields)
+	}
+	if len(d.table.entries) != 0 {
+		t.Error("encoder added entries to the dynamic table")
+	}
+}
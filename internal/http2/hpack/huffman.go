@@ -0,0 +1,92 @@
+package hpack
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrInvalidHuffman = errors.New("hpack: invalid Huffman-encoded data")
+
+// huffmanNode is a node of the decoding trie; leaves carry a symbol.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      byte
+	leaf     bool
+}
+
+var (
+	huffmanRootOnce sync.Once
+	huffmanRoot     *huffmanNode
+)
+
+func buildHuffmanTrie() {
+	huffmanRoot = &huffmanNode{}
+	for sym, code := range huffmanCodes {
+		n := huffmanRoot
+		for i := int(huffmanCodeLen[sym]) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if n.children[bit] == nil {
+				n.children[bit] = &huffmanNode{}
+			}
+			n = n.children[bit]
+		}
+		n.sym = byte(sym)
+		n.leaf = true
+	}
+}
+
+// huffmanDecode appends the decoding of src to dst. The padding must be a
+// prefix of EOS (all ones) shorter than a byte, per RFC 7541 section 5.2.
+func huffmanDecode(dst, src []byte) ([]byte, error) {
+	huffmanRootOnce.Do(buildHuffmanTrie)
+
+	n := huffmanRoot
+	depth, ones := 0, true
+	for _, b := range src {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			n = n.children[bit]
+			if n == nil {
+				// only EOS, 30 ones, leads off the trie
+				return nil, ErrInvalidHuffman
+			}
+			depth++
+			ones = ones && bit == 1
+			if n.leaf {
+				dst = append(dst, n.sym)
+				n, depth, ones = huffmanRoot, 0, true
+			}
+		}
+	}
+	if depth > 7 || !ones {
+		return nil, ErrInvalidHuffman
+	}
+	return dst, nil
+}
+
+func huffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLen[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+func huffmanEncode(dst []byte, s string) []byte {
+	var acc uint64
+	var bits uint
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLen[s[i]] | uint64(huffmanCodes[s[i]])
+		bits += uint(huffmanCodeLen[s[i]])
+		for bits >= 8 {
+			bits -= 8
+			dst = append(dst, byte(acc>>bits))
+		}
+	}
+	if bits > 0 {
+		// pad with the most significant bits of EOS
+		acc = acc<<(8-bits) | (1<<(8-bits) - 1)
+		dst = append(dst, byte(acc))
+	}
+	return dst
+}
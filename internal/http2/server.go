@@ -0,0 +1,160 @@
+// Package http2 serves HTTP/2 connections (RFC 9113) into the same
+// handlers as HTTP/1: every stream becomes a request.Request answered
+// through a response.Response that frames its output for the stream.
+//
+// Server push is never used: the server advertises SETTINGS_ENABLE_PUSH 0
+// and sends no PUSH_PROMISE frames.
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/http2/hpack"
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+)
+
+// ClientPreface opens every HTTP/2 connection (RFC 9113 section 3.4).
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// DefaultMaxConcurrentStreams is advertised when
+// Server.MaxConcurrentStreams is unset.
+const DefaultMaxConcurrentStreams = 100
+
+var ErrBadPreface = errors.New("http2: invalid client preface")
+
+// Handler serves one stream: it answers req through a response built on
+// stream. Handlers of different streams run concurrently.
+type Handler func(req *request.Request, stream response.Stream)
+
+type Server struct {
+	Config  *config.Config
+	Logger  logger.Logger
+	Handler Handler
+
+	// MaxConcurrentStreams caps the streams a client may have open on one
+	// connection; zero uses DefaultMaxConcurrentStreams.
+	MaxConcurrentStreams uint32
+	// Shutdown, when closed, makes every connection send GOAWAY, finish
+	// the streams it has accepted and then close.
+	Shutdown <-chan struct{}
+}
+
+// ServeConn speaks HTTP/2 on conn until the client leaves, a protocol
+// error ends the connection or ctx is cancelled. buffered holds bytes
+// already read from conn, starting with the client preface or part of it.
+// upgrade, when not nil, is the HTTP/1.1 request that switched to h2c: its
+// HTTP2-Settings are applied and it is answered as stream 1. The caller
+// closes conn afterwards.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn, buffered []byte, upgrade *request.Request) error {
+	log := s.Logger
+	if log == nil {
+		log = logger.Discard()
+	}
+	maxStreams := s.MaxConcurrentStreams
+	if maxStreams == 0 {
+		maxStreams = DefaultMaxConcurrentStreams
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sc := &serverConn{
+		srv:               s,
+		conn:              conn,
+		br:                bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn)),
+		log:               log,
+		ctx:               ctx,
+		cancel:            cancel,
+		dec:               hpack.NewDecoder(defaultHeaderTable),
+		maxStreams:        maxStreams,
+		streams:           map[uint32]*stream{},
+		sendWindow:        defaultWindowSize,
+		recvWindow:        defaultWindowSize,
+		peerInitialWindow: defaultWindowSize,
+		peerMaxFrame:      defaultMaxFrame,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	if s.Config.HeaderLimit > 0 {
+		sc.dec.MaxHeaderListSize = uint32(s.Config.HeaderLimit)
+	}
+	return sc.serve(upgrade)
+}
+
+// AdequateTLS reports whether a TLS connection may carry HTTP/2: TLS 1.3,
+// or TLS 1.2 with an ephemeral key exchange and an AEAD cipher. The other
+// TLS 1.2 suites are on the blocklist of RFC 9113 Appendix A.
+func AdequateTLS(state *tls.ConnectionState) bool {
+	if state.Version >= tls.VersionTLS13 {
+		return true
+	}
+	if state.Version < tls.VersionTLS12 {
+		return false
+	}
+	switch state.CipherSuite {
+	case tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256:
+		return true
+	}
+	return false
+}
+
+// RejectConn turns down an HTTP/2 connection before serving it, with a
+// GOAWAY carrying code, as for INADEQUATE_SECURITY (RFC 9113 section
+// 9.2.2). The caller closes conn afterwards.
+func RejectConn(conn net.Conn, code ErrCode, reason string) error {
+	payload := binary.BigEndian.AppendUint32(nil, 0)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, reason...)
+	_, err := conn.Write(appendFrame(nil, frameGoAway, 0, 0, payload))
+	return err
+}
+
+// IsUpgrade reports whether req asks to switch to h2c (RFC 7540 section
+// 3.2). Only cleartext HTTP/1.1 connections may be upgraded.
+func IsUpgrade(req *request.Request) bool {
+	if req.Version != "HTTP/1.1" {
+		return false
+	}
+	if _, ok := req.Headers["http2-settings"]; !ok {
+		return false
+	}
+	conn := req.Headers["connection"]
+	return hasToken(req.Headers["upgrade"], "h2c") &&
+		hasToken(conn, "upgrade") && hasToken(conn, "http2-settings")
+}
+
+// decodeUpgradeSettings reads the base64url SETTINGS payload of an
+// HTTP2-Settings header.
+func decodeUpgradeSettings(value string) ([]setting, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, ConnError{ErrCodeProtocol, "malformed HTTP2-Settings"}
+	}
+	return parseSettings(payload)
+}
+
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,379 @@
+package http2
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/http2/hpack"
+	"github.com/brutally-Honest/http-server/internal/request"
+)
+
+var (
+	errStreamClosed = errors.New("http2: stream closed")
+	errConnClosed   = errors.New("http2: connection closed")
+	errWriteTimeout = errors.New("http2: timed out waiting for flow-control window")
+)
+
+// stream is one request/response exchange. It implements response.Stream
+// for the handler goroutine; the read loop fills in the request.
+type stream struct {
+	sc     *serverConn
+	id     uint32
+	req    *request.Request
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// owned by the read loop
+	body       []byte
+	recvWindow int64
+	remoteDone bool
+
+	// guarded by sc.mu
+	sendWindow int64
+	dispatched bool
+	localDone  bool
+	reset      bool
+}
+
+func (sc *serverConn) newStream(id uint32, req *request.Request) *stream {
+	ctx, cancel := context.WithCancel(sc.ctx)
+	req.Context = ctx
+
+	st := &stream{
+		sc:         sc,
+		id:         id,
+		req:        req,
+		ctx:        ctx,
+		cancel:     cancel,
+		recvWindow: defaultWindowSize,
+	}
+
+	sc.mu.Lock()
+	st.sendWindow = sc.peerInitialWindow
+	sc.streams[id] = st
+	sc.mu.Unlock()
+	return st
+}
+
+// closeStream forgets a stream whose handler returned, resetting it if the
+// response never ended.
+func (sc *serverConn) closeStream(st *stream) {
+	sc.mu.Lock()
+	unfinished := !st.localDone && !st.reset
+	sc.mu.Unlock()
+	if unfinished {
+		sc.resetStream(st, ErrCodeCancel)
+	}
+
+	sc.mu.Lock()
+	delete(sc.streams, st.id)
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+	st.cancel()
+
+	if idle {
+		// the read loop may be blocked without a deadline
+		sc.setReadDeadline()
+	}
+}
+
+func (sc *serverConn) resetStream(st *stream, code ErrCode) {
+	sc.mu.Lock()
+	if st.reset {
+		sc.mu.Unlock()
+		return
+	}
+	st.reset = true
+	if !st.dispatched {
+		delete(sc.streams, st.id)
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	st.cancel()
+	sc.writeRST(st.id, code)
+}
+
+// resetStreamID resets a stream that may no longer, or never, exist.
+func (sc *serverConn) resetStreamID(id uint32, code ErrCode) {
+	sc.mu.Lock()
+	st := sc.streams[id]
+	sc.mu.Unlock()
+
+	if st != nil {
+		sc.resetStream(st, code)
+		return
+	}
+	sc.writeRST(id, code)
+}
+
+func (sc *serverConn) writeRST(id uint32, code ErrCode) {
+	sc.writeFrame(frameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+// writeSimpleResponse answers a stream the handlers never see, such as a
+// request whose body or headers are over the limits.
+func (sc *serverConn) writeSimpleResponse(id uint32, status int) {
+	var enc hpack.Encoder
+	block := enc.AppendField(nil, hpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
+	sc.writeFrame(frameHeaders, flagEndHeaders|flagEndStream, id, block)
+}
+
+// connectionHeaders may not appear in HTTP/2 messages (RFC 9113 section
+// 8.2.2); the response drops them, requests carrying them are malformed.
+var connectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+func (st *stream) WriteHeaders(status int, headers map[string]string, cookies []string) error {
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(status)}}
+	fields = appendFields(fields, headers)
+	for _, c := range cookies {
+		fields = append(fields, hpack.HeaderField{Name: "set-cookie", Value: c, Sensitive: true})
+	}
+	return st.sc.writeHeaders(st, fields, false)
+}
+
+func (st *stream) WriteData(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	return st.sc.writeData(st, p, false)
+}
+
+func (st *stream) End(trailers map[string]string) error {
+	if len(trailers) > 0 {
+		return st.sc.writeHeaders(st, appendFields(nil, trailers), true)
+	}
+	return st.sc.writeData(st, nil, true)
+}
+
+func (st *stream) Reset() {
+	st.sc.resetStream(st, ErrCodeInternal)
+}
+
+// appendFields converts response headers to lowercase HTTP/2 fields in a
+// stable order.
+func appendFields(fields []hpack.HeaderField, headers map[string]string) []hpack.HeaderField {
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		name := strings.ToLower(k)
+		if connectionHeaders[name] {
+			continue
+		}
+		fields = append(fields, hpack.HeaderField{
+			Name:      name,
+			Value:     headers[k],
+			Sensitive: name == "set-cookie",
+		})
+	}
+	return fields
+}
+
+// writeHeaders sends a header block, split into CONTINUATION frames when
+// it exceeds the client's frame size.
+func (sc *serverConn) writeHeaders(st *stream, fields []hpack.HeaderField, endStream bool) error {
+	var enc hpack.Encoder
+	var block []byte
+	for _, f := range fields {
+		block = enc.AppendField(block, f)
+	}
+
+	sc.mu.Lock()
+	if err := st.writable(); err != nil {
+		sc.mu.Unlock()
+		return err
+	}
+	if endStream {
+		st.localDone = true
+	}
+	maxFrame := int(sc.peerMaxFrame)
+	sc.mu.Unlock()
+
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	typ := frameHeaders
+	for {
+		chunk := block
+		if len(chunk) > maxFrame {
+			chunk = chunk[:maxFrame]
+		}
+		block = block[len(chunk):]
+
+		var flags uint8
+		if typ == frameHeaders && endStream {
+			flags |= flagEndStream
+		}
+		if len(block) == 0 {
+			flags |= flagEndHeaders
+		}
+		if err := sc.writeFrameLocked(typ, flags, st.id, chunk); err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		typ = frameContinuation
+	}
+}
+
+// writeData sends p within the client's flow-control windows, waiting up
+// to the write timeout for them to open.
+func (sc *serverConn) writeData(st *stream, p []byte, endStream bool) error {
+	var timer *time.Timer
+	var deadline time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		sc.mu.Lock()
+		for len(p) > 0 && st.writable() == nil && (st.sendWindow <= 0 || sc.sendWindow <= 0) {
+			if timer == nil {
+				deadline = time.Now().Add(sc.srv.Config.WriteTimeout)
+				timer = time.AfterFunc(sc.srv.Config.WriteTimeout, func() {
+					sc.mu.Lock()
+					sc.cond.Broadcast()
+					sc.mu.Unlock()
+				})
+			}
+			if !time.Now().Before(deadline) {
+				sc.mu.Unlock()
+				sc.resetStream(st, ErrCodeFlowControl)
+				return errWriteTimeout
+			}
+			sc.cond.Wait()
+		}
+		if err := st.writable(); err != nil {
+			sc.mu.Unlock()
+			return err
+		}
+
+		var chunk []byte
+		if len(p) > 0 {
+			n := min(int64(len(p)), st.sendWindow, sc.sendWindow, int64(sc.peerMaxFrame))
+			st.sendWindow -= n
+			sc.sendWindow -= n
+			chunk, p = p[:n], p[n:]
+		}
+
+		var flags uint8
+		if endStream && len(p) == 0 {
+			flags = flagEndStream
+			st.localDone = true
+		}
+		sc.mu.Unlock()
+
+		if err := sc.writeFrame(frameData, flags, st.id, chunk); err != nil {
+			return err
+		}
+		if len(p) == 0 {
+			return nil
+		}
+	}
+}
+
+// writable reports why nothing more may be sent on the stream; sc.mu must
+// be held.
+func (st *stream) writable() error {
+	switch {
+	case st.sc.closed:
+		return errConnClosed
+	case st.reset, st.localDone:
+		return errStreamClosed
+	}
+	return nil
+}
+
+// newRequest validates the header fields of a request (RFC 9113 section
+// 8.3) and builds the Request handlers see.
+func newRequest(fields []hpack.HeaderField) (*request.Request, error) {
+	var method, path, scheme, authority string
+	headers := map[string]string{}
+	regular := false
+
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			if regular {
+				return nil, errors.New("pseudo-header after regular header")
+			}
+			var dst *string
+			switch f.Name {
+			case ":method":
+				dst = &method
+			case ":path":
+				dst = &path
+			case ":scheme":
+				dst = &scheme
+			case ":authority":
+				dst = &authority
+			default:
+				return nil, errors.New("unknown pseudo-header " + f.Name)
+			}
+			if *dst != "" {
+				return nil, errors.New("duplicate pseudo-header " + f.Name)
+			}
+			*dst = f.Value
+			continue
+		}
+
+		regular = true
+		if f.Name != strings.ToLower(f.Name) {
+			return nil, errors.New("uppercase header name " + f.Name)
+		}
+		if connectionHeaders[f.Name] {
+			return nil, errors.New("connection-specific header " + f.Name)
+		}
+		if f.Name == "te" && f.Value != "trailers" {
+			return nil, errors.New("TE other than trailers")
+		}
+
+		// repeated fields fold into one value, as an HTTP/1 client would send them
+		if prev, ok := headers[f.Name]; ok {
+			sep := ", "
+			if f.Name == "cookie" {
+				sep = "; "
+			}
+			headers[f.Name] = prev + sep + f.Value
+		} else {
+			headers[f.Name] = f.Value
+		}
+	}
+
+	if method == "CONNECT" {
+		return nil, errors.New("CONNECT is not supported")
+	}
+	if method == "" || path == "" || scheme == "" {
+		return nil, errors.New("missing :method, :path or :scheme")
+	}
+	if !strings.HasPrefix(path, "/") && !(method == "OPTIONS" && path == "*") {
+		return nil, errors.New("invalid :path")
+	}
+	if _, ok := headers["host"]; !ok && authority != "" {
+		headers["host"] = authority
+	}
+
+	return &request.Request{
+		Method:  method,
+		Path:    path,
+		Version: "HTTP/2.0",
+		Headers: headers,
+	}, nil
+}
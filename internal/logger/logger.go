@@ -0,0 +1,80 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// Logger is the logging surface used by the server. The method set mirrors
+// *slog.Logger so any slog handler can be plugged in through FromSlog.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+	With(args ...any) Logger
+	Enabled(level slog.Level) bool
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func FromSlog(l *slog.Logger) Logger {
+	return &slogLogger{l: l}
+}
+
+// New returns a text logger writing records at or above level to w.
+func New(w io.Writer, level slog.Level) Logger {
+	return FromSlog(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})))
+}
+
+// Discard returns a logger that drops every record.
+func Discard() Logger {
+	return FromSlog(slog.New(slog.DiscardHandler))
+}
+
+func (s *slogLogger) Debug(msg string, args ...any) { s.l.Debug(msg, args...) }
+func (s *slogLogger) Info(msg string, args ...any)  { s.l.Info(msg, args...) }
+func (s *slogLogger) Warn(msg string, args ...any)  { s.l.Warn(msg, args...) }
+func (s *slogLogger) Error(msg string, args ...any) { s.l.Error(msg, args...) }
+
+func (s *slogLogger) With(args ...any) Logger {
+	return &slogLogger{l: s.l.With(args...)}
+}
+
+func (s *slogLogger) Enabled(level slog.Level) bool {
+	return s.l.Enabled(context.Background(), level)
+}
+
+const redacted = "[REDACTED]"
+
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"x-api-key":           true,
+}
+
+// Headers builds a log attribute for a header map with credentials masked.
+func Headers(key string, headers map[string]string) slog.Attr {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]any, 0, len(keys))
+	for _, k := range keys {
+		v := headers[k]
+		if sensitiveHeaders[strings.ToLower(k)] {
+			v = redacted
+		}
+		attrs = append(attrs, slog.String(k, v))
+	}
+	return slog.Group(key, attrs...)
+}
@@ -0,0 +1,60 @@
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, slog.LevelInfo)
+
+	l.Debug("hidden")
+	l.Info("shown", "n", 1)
+	l.With("conn", "c1").Warn("tagged")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("debug record written at info level: %q", out)
+	}
+	if !strings.Contains(out, "level=INFO msg=shown n=1") {
+		t.Errorf("info record missing: %q", out)
+	}
+	if !strings.Contains(out, "level=WARN msg=tagged conn=c1") {
+		t.Errorf("With attributes missing: %q", out)
+	}
+	if l.Enabled(slog.LevelDebug) || !l.Enabled(slog.LevelError) {
+		t.Error("Enabled does not follow the level")
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	l := Discard()
+	if l.Enabled(slog.LevelError) {
+		t.Error("Discard enabled for errors")
+	}
+	l.With("k", "v").Error("dropped")
+}
+
+func TestHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, slog.LevelInfo)
+	l.Info("request", Headers("headers", map[string]string{
+		"Authorization": "Bearer secret",
+		"cookie":        "session=secret",
+		"X-Api-Key":     "secret",
+		"accept":        "text/html",
+		"host":          "example.com",
+	}))
+
+	out := buf.String()
+	if strings.Contains(out, "secret") {
+		t.Errorf("credentials logged: %q", out)
+	}
+	want := "headers.Authorization=[REDACTED] headers.X-Api-Key=[REDACTED] headers.accept=text/html headers.cookie=[REDACTED] headers.host=example.com"
+	if !strings.Contains(out, want) {
+		t.Errorf("got %q, want it to contain %q", out, want)
+	}
+}
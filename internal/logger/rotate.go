@@ -0,0 +1,122 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an io.WriteCloser that renames the file to path.1, path.2,
+// ... once it grows past MaxBytes, keeping at most MaxBackups old files.
+type RotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(path); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func (rf *RotatingFile) open(name string) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+	rf.file = f
+	rf.size = info.Size()
+	return nil
+}
+
+// rotate moves the file aside and starts a new one. When a step fails the
+// file written so far is reopened, so logging carries on there instead of
+// every later Write failing, and the next attempt waits for another
+// maxBytes.
+func (rf *RotatingFile) rotate() error {
+	err := rf.file.Close()
+	rf.file = nil
+	if err != nil {
+		return rf.reopen(rf.path, err)
+	}
+
+	current := rf.path
+	if rf.maxBackups <= 0 {
+		os.Remove(rf.path)
+	} else {
+		os.Remove(rf.backupName(rf.maxBackups))
+		for i := rf.maxBackups - 1; i >= 1; i-- {
+			os.Rename(rf.backupName(i), rf.backupName(i+1))
+		}
+		if err := os.Rename(rf.path, rf.backupName(1)); err != nil {
+			return rf.reopen(rf.path, fmt.Errorf("rotate log file: %w", err))
+		}
+		current = rf.backupName(1)
+	}
+
+	if err := rf.open(rf.path); err != nil {
+		return rf.reopen(current, err)
+	}
+	return nil
+}
+
+// reopen appends to name again after a failed rotation and returns err.
+func (rf *RotatingFile) reopen(name string, err error) error {
+	if rerr := rf.open(name); rerr != nil {
+		return errors.Join(err, rerr)
+	}
+	rf.size = 0
+	return err
+}
+
+func (rf *RotatingFile) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", rf.path, n)
+}
@@ -0,0 +1,140 @@
+package logger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	rf, err := NewRotatingFile(path, 5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q): %v", line, err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "five\n",
+		path + ".1": "four\n",
+		path + ".2": "three\n",
+	}
+	for name, content := range want {
+		if got := readFile(t, name); got != content {
+			t.Errorf("%s holds %q, want %q", filepath.Base(name), got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than MaxBackups kept: %v", err)
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	if err := os.WriteFile(path, []byte("earlier\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := NewRotatingFile(path, 12, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	// the existing size counts towards the limit
+	rf.Write([]byte("later\n"))
+	if got := readFile(t, path); got != "later\n" {
+		t.Errorf("current file holds %q", got)
+	}
+	if got := readFile(t, path+".1"); got != "earlier\n" {
+		t.Errorf("backup holds %q", got)
+	}
+}
+
+func TestRotatingFileWithoutBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	rf, err := NewRotatingFile(path, 8, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	rf.Write([]byte("first\n"))
+	rf.Write([]byte("second\n"))
+	if got := readFile(t, path); got != "second\n" {
+		t.Errorf("file holds %q", got)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("backup kept with MaxBackups 0: %v", err)
+	}
+}
+
+func TestRotatingFileRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	rf, err := NewRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	// a directory that is not empty cannot be renamed over or removed
+	if err := os.MkdirAll(filepath.Join(path+".1", "taken"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	rf.Write([]byte("one\n"))
+	if _, err := rf.Write([]byte("two two\n")); err == nil {
+		t.Fatal("the failed rotation was not reported")
+	}
+	for _, line := range []string{"3\n", "4\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q) after the failed rotation: %v", line, err)
+		}
+	}
+	if got := readFile(t, path); got != "one\n3\n4\n" {
+		t.Errorf("file holds %q", got)
+	}
+
+	// once the way is clear rotation picks up again
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte(strings.Repeat("x", 8) + "\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got := readFile(t, path+".1"); got != "one\n3\n4\n" {
+		t.Errorf("backup holds %q", got)
+	}
+}
+
+func TestRotatingFileClosed(t *testing.T) {
+	rf, err := NewRotatingFile(filepath.Join(t.TempDir(), "server.log"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("late\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Write after Close: %v", err)
+	}
+	if err := rf.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"bytes"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the registry in Prometheus text exposition format.
+func Handler(reg *Registry) router.Handler {
+	return func(req *request.Request, res *response.Response) {
+		var buf bytes.Buffer
+		if _, err := reg.WriteTo(&buf); err != nil {
+			res.WriteHeader(500)
+			res.Flush(req, false)
+			return
+		}
+		res.SetHeader("Content-Type", contentType)
+		res.Write(buf.Bytes())
+		res.Flush(req, false)
+	}
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func render(t *testing.T, reg *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	n, err := reg.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, buf.Len())
+	}
+	return buf.String()
+}
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("requests_total", "Requests served.", "method", "status")
+	active := reg.NewGauge("active", "Open connections.")
+	latency := reg.NewHistogram("latency_seconds", "Handler time.", []float64{0.1, 1}, "route")
+
+	requests.Inc("GET", "200")
+	requests.Add(2, "GET", "200")
+	requests.Inc("POST", "500")
+	active.Inc()
+	active.Inc()
+	active.Dec()
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(3, "/a")
+
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 3
+requests_total{method="POST",status="500"} 1
+# HELP active Open connections.
+# TYPE active gauge
+active 1
+# HELP latency_seconds Handler time.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 1
+latency_seconds_bucket{route="/a",le="1"} 2
+latency_seconds_bucket{route="/a",le="+Inf"} 3
+latency_seconds_sum{route="/a"} 3.55
+latency_seconds_count{route="/a"} 3
+`
+	if got := render(t, reg); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnlabelledStartsAtZero(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("hits_total", "Hits.")
+	reg.NewCounter("labelled_total", "Labelled.", "kind")
+
+	want := `# HELP hits_total Hits.
+# TYPE hits_total counter
+hits_total 0
+# HELP labelled_total Labelled.
+# TYPE labelled_total counter
+`
+	if got := render(t, reg); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("errors_total", "Errors by message,\nsee \\docs.", "msg")
+	c.Inc("bad \"quote\"\nand \\slash")
+
+	want := `# HELP errors_total Errors by message,\nsee \\docs.
+# TYPE errors_total counter
+errors_total{msg="bad \"quote\"\nand \\slash"} 1
+`
+	if got := render(t, reg); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMisuse(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(reg *Registry)
+	}{
+		{name: "duplicate name", fn: func(reg *Registry) {
+			reg.NewGauge("x", "")
+			reg.NewCounter("x", "")
+		}},
+		{name: "label count", fn: func(reg *Registry) {
+			reg.NewCounter("x", "", "a", "b").Inc("only one")
+		}},
+		{name: "counter decrease", fn: func(reg *Registry) {
+			reg.NewCounter("x", "").Add(-1)
+		}},
+		{name: "unsorted buckets", fn: func(reg *Registry) {
+			reg.NewHistogram("x", "", []float64{1, 0.5})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("did not panic")
+				}
+			}()
+			tt.fn(NewRegistry())
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+)
+
+type LogFormat int
+
+const (
+	// CommonFormat is the NCSA Common Log Format.
+	CommonFormat LogFormat = iota
+	// CombinedFormat is Common Log Format plus Referer and User-Agent.
+	CombinedFormat
+	// JSONFormat writes one JSON object per request, including latency.
+	JSONFormat
+)
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+type accessEntry struct {
+	start     time.Time
+	Time      string  `json:"time"`
+	Remote    string  `json:"remote_addr"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Proto     string  `json:"proto"`
+	Status    int     `json:"status"`
+	Bytes     int64   `json:"bytes"`
+	LatencyMS float64 `json:"latency_ms"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"user_agent,omitempty"`
+	RequestID string  `json:"request_id,omitempty"`
+}
+
+// AccessLog records one line per request to w in the given format.
+// Writes to w are serialised, so a shared file or stdout is safe.
+func AccessLog(w io.Writer, format LogFormat) router.Middleware {
+	var mu sync.Mutex
+
+	return func(next router.Handler) router.Handler {
+		return func(req *request.Request, res *response.Response) {
+			start := time.Now()
+			next(req, res)
+			// finish here so the logged status and byte count are final
+			res.Finish()
+
+			entry := accessEntry{
+				start:     start,
+				Remote:    req.RealIP(),
+				Method:    req.Method,
+				Path:      req.Path,
+				Proto:     req.Version,
+				Status:    res.Status(),
+				Bytes:     res.BytesWritten(),
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+				Referer:   req.Headers["referer"],
+				UserAgent: req.Headers["user-agent"],
+				RequestID: RequestIDFromContext(req.Context),
+			}
+
+			line := formatEntry(entry, format)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if _, err := io.WriteString(w, line); err != nil && req.Logger != nil {
+				req.Logger.Warn("access log write failed", "error", err)
+			}
+		}
+	}
+}
+
+func formatEntry(e accessEntry, format LogFormat) string {
+	switch format {
+	case JSONFormat:
+		e.Time = e.start.UTC().Format(time.RFC3339Nano)
+		b, err := json.Marshal(e)
+		if err != nil {
+			return ""
+		}
+		return string(b) + "\n"
+	case CombinedFormat:
+		return fmt.Sprintf("%s %q %q\n", commonLine(e), orDash(e.Referer), orDash(e.UserAgent))
+	default:
+		return commonLine(e) + "\n"
+	}
+}
+
+func commonLine(e accessEntry) string {
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+		orDash(e.Remote), e.start.Format(clfTimeLayout), e.Method, e.Path, e.Proto, e.Status, size)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
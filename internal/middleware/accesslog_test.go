@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/client"
+	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+	"github.com/brutally-Honest/http-server/internal/server"
+)
+
+// serve runs r behind mws and returns the server's base URL and a client
+// for it.
+func serve(t *testing.T, r *router.Router, mws ...router.Middleware) (string, *client.Client) {
+	t.Helper()
+	cfg := config.Load(4096, 1<<20, 8192, 5*time.Second, 5*time.Second)
+	s := server.NewServer("127.0.0.1:0", cfg, r)
+	s.Logger = logger.Discard()
+	s.Use(mws...)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+
+	c := &client.Client{Timeout: 5 * time.Second}
+	t.Cleanup(func() {
+		c.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return "http://" + ln.Addr().String(), c
+}
+
+// fetch sends a request with headers and body and returns the response and
+// its body.
+func fetch(t *testing.T, c *client.Client, method, url string, headers map[string]string, body []byte) (*client.Response, []byte) {
+	t.Helper()
+	req, err := client.NewRequest(context.Background(), method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Headers[k] = v
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	return res, b
+}
+
+// lineWriter hands every write to a channel, so a test can wait for a
+// line written after the response went out.
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func (w lineWriter) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case line := <-w:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("no access log line written")
+		return ""
+	}
+}
+
+func TestFormatEntry(t *testing.T) {
+	e := accessEntry{
+		start:     time.Date(2024, 3, 9, 14, 5, 7, 0, time.FixedZone("", 3600)),
+		Remote:    "192.0.2.1",
+		Method:    "GET",
+		Path:      "/index.html",
+		Proto:     "HTTP/1.1",
+		Status:    200,
+		Bytes:     512,
+		LatencyMS: 1.5,
+		UserAgent: "curl/8.0",
+	}
+	empty := e
+	empty.Remote, empty.Bytes, empty.Status = "", 0, 304
+
+	tests := []struct {
+		name   string
+		entry  accessEntry
+		format LogFormat
+		want   string
+	}{
+		{
+			name:   "common",
+			entry:  e,
+			format: CommonFormat,
+			want:   "192.0.2.1 - - [09/Mar/2024:14:05:07 +0100] \"GET /index.html HTTP/1.1\" 200 512\n",
+		},
+		{
+			name:   "common without body or address",
+			entry:  empty,
+			format: CommonFormat,
+			want:   "- - - [09/Mar/2024:14:05:07 +0100] \"GET /index.html HTTP/1.1\" 304 -\n",
+		},
+		{
+			name:   "combined",
+			entry:  e,
+			format: CombinedFormat,
+			want:   "192.0.2.1 - - [09/Mar/2024:14:05:07 +0100] \"GET /index.html HTTP/1.1\" 200 512 \"-\" \"curl/8.0\"\n",
+		},
+		{
+			name:   "json",
+			entry:  e,
+			format: JSONFormat,
+			want:   `{"time":"2024-03-09T13:05:07Z","remote_addr":"192.0.2.1","method":"GET","path":"/index.html","proto":"HTTP/1.1","status":200,"bytes":512,"latency_ms":1.5,"user_agent":"curl/8.0"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatEntry(tt.entry, tt.format); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	r := router.NewRouter()
+	r.GET("/hello", func(req *request.Request, res *response.Response) {
+		res.WriteString("hello")
+	})
+	r.GET("/stream", func(req *request.Request, res *response.Response) {
+		res.SetHeader("Transfer-Encoding", "chunked")
+		res.WriteChunk([]byte("one"))
+		res.WriteChunk([]byte("two"))
+	})
+
+	common, combined, jsonLog := make(lineWriter, 4), make(lineWriter, 4), make(lineWriter, 4)
+	base, c := serve(t, r, RequestID(),
+		AccessLog(common, CommonFormat), AccessLog(combined, CombinedFormat), AccessLog(jsonLog, JSONFormat))
+
+	headers := map[string]string{"referer": "http://example.com/", "user-agent": "test-agent"}
+	fetch(t, c, "GET", base+"/hello", headers, nil)
+
+	const clf = `^127\.0\.0\.1 - - \[[^\]]+\] "GET /hello HTTP/1\.1" 200 5`
+	if line := common.next(t); !regexp.MustCompile(clf + `\n$`).MatchString(line) {
+		t.Errorf("common line %q", line)
+	}
+	if line := combined.next(t); !regexp.MustCompile(clf + ` "http://example\.com/" "test-agent"\n$`).MatchString(line) {
+		t.Errorf("combined line %q", line)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(jsonLog.next(t)), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["status"] != 200.0 || entry["bytes"] != 5.0 || entry["request_id"] == nil || entry["latency_ms"] == nil {
+		t.Errorf("json entry %v", entry)
+	}
+
+	// streamed bodies are counted without their chunk framing
+	fetch(t, c, "GET", base+"/stream", nil, nil)
+	if line := common.next(t); !regexp.MustCompile(`"GET /stream HTTP/1\.1" 200 6\n$`).MatchString(line) {
+		t.Errorf("common line %q", line)
+	}
+
+	fetch(t, c, "GET", base+"/missing", nil, nil)
+	if line := common.next(t); !regexp.MustCompile(`"GET /missing HTTP/1\.1" 404 `).MatchString(line) {
+		t.Errorf("common line %q", line)
+	}
+}
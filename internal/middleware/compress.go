@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+)
+
+// DefaultCompressMinSize is below the point where gzip framing pays off.
+const DefaultCompressMinSize = 1024
+
+// supported encodings in server preference order
+var compressors = []struct {
+	name      string
+	newWriter func(w io.Writer) response.Encoder
+}{
+	{"gzip", func(w io.Writer) response.Encoder { return gzip.NewWriter(w) }},
+	// HTTP "deflate" is the zlib format (RFC 9110 8.4.1.2), not raw DEFLATE
+	{"deflate", func(w io.Writer) response.Encoder { return zlib.NewWriter(w) }},
+}
+
+// Compress negotiates gzip or deflate from Accept-Encoding and compresses
+// response bodies of at least minSize bytes; streamed responses are always
+// compressed. Content types that are already compressed are left alone.
+func Compress(minSize int) router.Middleware {
+	return func(next router.Handler) router.Handler {
+		return func(req *request.Request, res *response.Response) {
+			if req.Method == "HEAD" {
+				next(req, res)
+				return
+			}
+
+			var enc *response.Encoding
+			if name := negotiateEncoding(req.Headers["accept-encoding"]); name != "" {
+				for _, c := range compressors {
+					if c.name == name {
+						enc = &response.Encoding{
+							Name:      c.name,
+							NewWriter: c.newWriter,
+							MinSize:   minSize,
+							Encodable: compressible,
+						}
+					}
+				}
+			}
+			res.SetEncoding(enc)
+			next(req, res)
+		}
+	}
+}
+
+// negotiateEncoding picks the supported coding with the highest q-value,
+// breaking ties by server preference. It returns "" for identity.
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, q := parseQuality(part)
+		if coding == "" {
+			continue
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		weights[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, c := range compressors {
+		q, ok := weights[c.name]
+		if !ok && c.name == "gzip" {
+			q, ok = weights["x-gzip"]
+		}
+		if !ok {
+			if wildcard < 0 {
+				continue
+			}
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = c.name, q
+		}
+	}
+	return best
+}
+
+func parseQuality(part string) (string, float64) {
+	fields := strings.Split(part, ";")
+	coding := strings.ToLower(strings.TrimSpace(fields[0]))
+	q := 1.0
+	for _, param := range fields[1:] {
+		k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || strings.ToLower(strings.TrimSpace(k)) != "q" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return "", 0
+		}
+		q = parsed
+	}
+	return coding, q
+}
+
+var precompressedTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zip":              true,
+	"application/x-bzip2":          true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/x-xz":             true,
+	"application/zstd":             true,
+	"application/pdf":              true,
+	"application/octet-stream":     true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return true
+	}
+	if precompressedTypes[mediaType] {
+		return false
+	}
+	switch {
+	case mediaType == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"):
+		return false
+	}
+	return true
+}
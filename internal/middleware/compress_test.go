@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "gzip", want: "gzip"},
+		{header: "deflate", want: "deflate"},
+		{header: "deflate, gzip", want: "gzip"},
+		{header: "gzip;q=0.5, deflate", want: "deflate"},
+		{header: "GZIP; Q=0.8", want: "gzip"},
+		{header: "x-gzip", want: "gzip"},
+		{header: "gzip;q=0", want: ""},
+		{header: "*", want: "gzip"},
+		{header: "*;q=0.1, gzip;q=0", want: "deflate"},
+		{header: "br, identity", want: ""},
+		{header: "gzip;q=2", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := negotiateEncoding(tt.header); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{contentType: "", want: true},
+		{contentType: "text/html; charset=utf-8", want: true},
+		{contentType: "application/json", want: true},
+		{contentType: "image/svg+xml", want: true},
+		{contentType: "image/png", want: false},
+		{contentType: "Video/MP4", want: false},
+		{contentType: "application/gzip", want: false},
+		{contentType: "font/woff2", want: false},
+	}
+	for _, tt := range tests {
+		if got := compressible(tt.contentType); got != tt.want {
+			t.Errorf("compressible(%q) = %v", tt.contentType, got)
+		}
+	}
+}
+
+func decode(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	var err error
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return string(body)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", encoding, err)
+	}
+	return string(b)
+}
+
+func TestCompress(t *testing.T) {
+	text := strings.Repeat("compress me please ", 100)
+	r := router.NewRouter()
+	textHandler := func(req *request.Request, res *response.Response) {
+		res.SetHeader("Content-Type", "text/plain")
+		res.WriteString(text)
+	}
+	r.GET("/text", textHandler)
+	r.HEAD("/text", textHandler)
+	r.GET("/small", func(req *request.Request, res *response.Response) {
+		res.WriteString("tiny")
+	})
+	r.GET("/image", func(req *request.Request, res *response.Response) {
+		res.SetHeader("Content-Type", "image/png")
+		res.WriteString(text)
+	})
+	r.GET("/stream", func(req *request.Request, res *response.Response) {
+		res.SetHeader("Transfer-Encoding", "chunked")
+		res.WriteChunk([]byte("a"))
+		res.WriteChunk([]byte("b"))
+	})
+	r.GET("/encoded", func(req *request.Request, res *response.Response) {
+		res.SetHeader("Content-Encoding", "br")
+		res.WriteString(text)
+	})
+	base, c := serve(t, r, Compress(DefaultCompressMinSize))
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		encoding       string
+		body           string
+	}{
+		{name: "gzip", path: "/text", acceptEncoding: "gzip, deflate", encoding: "gzip", body: text},
+		{name: "deflate", path: "/text", acceptEncoding: "deflate", encoding: "deflate", body: text},
+		{name: "not accepted", path: "/text", body: text},
+		{name: "below the minimum size", path: "/small", acceptEncoding: "gzip", body: "tiny"},
+		{name: "already compressed type", path: "/image", acceptEncoding: "gzip", body: text},
+		{name: "streamed", path: "/stream", acceptEncoding: "gzip", encoding: "gzip", body: "ab"},
+		{name: "encoded by the handler", path: "/encoded", acceptEncoding: "gzip", encoding: "br", body: text},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.acceptEncoding != "" {
+				headers["accept-encoding"] = tt.acceptEncoding
+			}
+			res, body := fetch(t, c, "GET", base+tt.path, headers, nil)
+			if res.Headers["content-encoding"] != tt.encoding {
+				t.Fatalf("Content-Encoding %q, want %q", res.Headers["content-encoding"], tt.encoding)
+			}
+			if res.Headers["vary"] != "Accept-Encoding" {
+				t.Errorf("Vary %q", res.Headers["vary"])
+			}
+			if got := decode(t, tt.encoding, body); got != tt.body {
+				t.Errorf("body %q, want %q", got, tt.body)
+			}
+			if tt.path == "/text" && tt.encoding != "" && len(body) >= len(text) {
+				t.Errorf("%s body of %d bytes for %d", tt.encoding, len(body), len(text))
+			}
+		})
+	}
+
+	res, body := fetch(t, c, "HEAD", base+"/text", map[string]string{"accept-encoding": "gzip"}, nil)
+	if res.StatusCode != 200 || res.Headers["content-encoding"] != "" || len(body) != 0 {
+		t.Errorf("HEAD got %d, Content-Encoding %q, %d bytes", res.StatusCode, res.Headers["content-encoding"], len(body))
+	}
+}
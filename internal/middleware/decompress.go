@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+)
+
+var errDecodedTooLarge = errors.New("decoded body exceeds body limit")
+
+type unsupportedEncodingError struct {
+	coding string
+}
+
+func (e *unsupportedEncodingError) Error() string {
+	return "unsupported content coding: " + e.coding
+}
+
+// Decompress decodes gzip and deflate request bodies before the handler
+// sees them. The decoded size is held to Config.BodyLimit so a small
+// compressed payload cannot expand without bound; unknown codings get 415.
+func Decompress() router.Middleware {
+	return func(next router.Handler) router.Handler {
+		return func(req *request.Request, res *response.Response) {
+			codings := req.Headers["content-encoding"]
+			if codings == "" || strings.EqualFold(strings.TrimSpace(codings), "identity") {
+				next(req, res)
+				return
+			}
+
+			body, err := decodeBody(req.Body, codings, res.Cfg.BodyLimit)
+			if err != nil {
+				var unsupported *unsupportedEncodingError
+				switch {
+				case errors.As(err, &unsupported):
+					res.SetHeader("Accept-Encoding", "gzip, deflate")
+					res.WriteHeader(415)
+					res.Write([]byte("Unsupported Media Type"))
+				case errors.Is(err, errDecodedTooLarge):
+					res.WriteHeader(413)
+					res.Write([]byte("Payload Too Large"))
+				default:
+					res.WriteHeader(400)
+					res.Write([]byte("Bad Request"))
+				}
+				if req.Logger != nil {
+					req.Logger.Debug("request body decode failed", "error", err)
+				}
+				res.Flush(req, false)
+				return
+			}
+
+			req.Body = body
+			delete(req.Headers, "content-encoding")
+			req.Headers["content-length"] = strconv.Itoa(len(body))
+			next(req, res)
+		}
+	}
+}
+
+// decodeBody undoes each coding in reverse order of application.
+func decodeBody(body []byte, codings string, limit int) ([]byte, error) {
+	list := strings.Split(codings, ",")
+	for i := len(list) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(list[i]))
+		var (
+			rc  io.ReadCloser
+			err error
+		)
+		switch coding {
+		case "identity", "":
+			continue
+		case "gzip", "x-gzip":
+			rc, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			rc, err = newDeflateReader(body)
+		default:
+			return nil, &unsupportedEncodingError{coding: coding}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		body, err = readLimited(rc, limit)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// newDeflateReader accepts zlib-wrapped data as the spec requires, and raw
+// DEFLATE streams that some clients send instead.
+func newDeflateReader(body []byte) (io.ReadCloser, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(body))
+	if err == nil {
+		return zr, nil
+	}
+	if errors.Is(err, zlib.ErrHeader) {
+		return flate.NewReader(bytes.NewReader(body)), nil
+	}
+	return nil, err
+}
+
+func readLimited(r io.Reader, limit int) ([]byte, error) {
+	decoded, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) > limit {
+		return nil, errDecodedTooLarge
+	}
+	return decoded, nil
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+)
+
+// encode applies coding to data the way a client would.
+func encode(t *testing.T, coding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		t.Fatalf("unknown coding %s", coding)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	const text = "decode me, decode me"
+	gzipped := encode(t, "gzip", []byte(text))
+
+	tests := []struct {
+		name    string
+		body    []byte
+		codings string
+		limit   int
+		want    string
+		wantErr error
+	}{
+		{name: "gzip", body: gzipped, codings: "gzip", limit: 100, want: text},
+		{name: "x-gzip", body: gzipped, codings: "X-Gzip", limit: 100, want: text},
+		{name: "deflate", body: encode(t, "deflate", []byte(text)), codings: "deflate", limit: 100, want: text},
+		{name: "raw deflate", body: encode(t, "raw deflate", []byte(text)), codings: "deflate", limit: 100, want: text},
+		{name: "stacked", body: encode(t, "deflate", gzipped), codings: "gzip, deflate", limit: 100, want: text},
+		{name: "identity in the list", body: gzipped, codings: "identity, gzip", limit: 100, want: text},
+		{name: "exactly the limit", body: gzipped, codings: "gzip", limit: len(text), want: text},
+		{name: "over the limit", body: gzipped, codings: "gzip", limit: len(text) - 1, wantErr: errDecodedTooLarge},
+		{name: "corrupt", body: []byte("definitely not gzip data"), codings: "gzip", limit: 100, wantErr: gzip.ErrHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBody(tt.body, tt.codings, tt.limit)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q", got)
+			}
+		})
+	}
+
+	var unsupported *unsupportedEncodingError
+	if _, err := decodeBody(gzipped, "gzip, br", 100); !errors.As(err, &unsupported) || unsupported.coding != "br" {
+		t.Errorf("br: %v", err)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	r := router.NewRouter()
+	r.POST("/echo", func(req *request.Request, res *response.Response) {
+		res.SetHeader("X-Content-Encoding", req.Headers["content-encoding"])
+		res.SetHeader("X-Content-Length", req.Headers["content-length"])
+		res.Write(req.Body)
+	})
+	base, c := serve(t, r, Decompress())
+
+	text := strings.Repeat("squeeze ", 64)
+	// a small body that expands past the server's 1MB body limit
+	bomb := encode(t, "gzip", make([]byte, 2<<20))
+
+	tests := []struct {
+		name           string
+		encoding       string
+		body           []byte
+		status         int
+		echo           string
+		acceptEncoding string
+	}{
+		{name: "gzip", encoding: "gzip", body: encode(t, "gzip", []byte(text)), status: 200, echo: text},
+		{name: "identity", encoding: "identity", body: []byte(text), status: 200, echo: text},
+		{name: "plain", body: []byte(text), status: 200, echo: text},
+		{name: "unsupported", encoding: "br", body: []byte(text), status: 415, acceptEncoding: "gzip, deflate"},
+		{name: "corrupt", encoding: "gzip", body: []byte(text), status: 400},
+		{name: "too large once decoded", encoding: "gzip", body: bomb, status: 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.encoding != "" {
+				headers["content-encoding"] = tt.encoding
+			}
+			res, body := fetch(t, c, "POST", base+"/echo", headers, tt.body)
+			if res.StatusCode != tt.status {
+				t.Fatalf("got %d %q, want %d", res.StatusCode, body, tt.status)
+			}
+			if res.Headers["accept-encoding"] != tt.acceptEncoding {
+				t.Errorf("Accept-Encoding %q", res.Headers["accept-encoding"])
+			}
+			if tt.status != 200 {
+				return
+			}
+			if string(body) != tt.echo {
+				t.Errorf("handler saw %q", body)
+			}
+			if tt.encoding == "gzip" && (res.Headers["x-content-encoding"] != "" || res.Headers["x-content-length"] != "512") {
+				t.Errorf("handler saw Content-Encoding %q, Content-Length %q",
+					res.Headers["x-content-encoding"], res.Headers["x-content-length"])
+			}
+		})
+	}
+}
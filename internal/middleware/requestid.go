@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID stored by the RequestID middleware.
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID tags every request with an identifier. A well-formed incoming
+// X-Request-ID is reused so IDs survive hops between services; otherwise a
+// new time-sortable ID is generated. The ID is stored on Request.Context,
+// echoed in the response headers and attached to Request.Logger.
+func RequestID() router.Middleware {
+	return func(next router.Handler) router.Handler {
+		return func(req *request.Request, res *response.Response) {
+			id := req.Headers["x-request-id"]
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+
+			req.Context = context.WithValue(req.Context, requestIDKey{}, id)
+			if req.Logger != nil {
+				req.Logger = req.Logger.With("request_id", id)
+			}
+			res.SetHeader(RequestIDHeader, id)
+
+			next(req, res)
+		}
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Crockford base32, as used by ULID: lexical order matches numeric order.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var idGen struct {
+	mu      sync.Mutex
+	lastMS  uint64
+	entropy [10]byte
+}
+
+// newRequestID returns a 26 character ULID: 48 bits of millisecond time
+// followed by 80 bits of randomness, incremented within the same millisecond
+// so IDs generated by this process stay strictly ordered.
+func newRequestID() string {
+	idGen.mu.Lock()
+	ms := uint64(time.Now().UnixMilli())
+	if ms == idGen.lastMS {
+		incrementEntropy(&idGen.entropy)
+	} else {
+		idGen.lastMS = ms
+		rand.Read(idGen.entropy[:])
+	}
+
+	var raw [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(raw[:6], ts[2:])
+	copy(raw[6:], idGen.entropy[:])
+	idGen.mu.Unlock()
+
+	return encodeULID(raw)
+}
+
+func incrementEntropy(e *[10]byte) {
+	for i := len(e) - 1; i >= 0; i-- {
+		e[i]++
+		if e[i] != 0 {
+			return
+		}
+	}
+}
+
+// encodeULID encodes 128 bits as 26 base32 characters, most significant first.
+func encodeULID(raw [16]byte) string {
+	hi := binary.BigEndian.Uint64(raw[:8])
+	lo := binary.BigEndian.Uint64(raw[8:])
+
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
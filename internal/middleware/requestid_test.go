@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+)
+
+func TestRequestID(t *testing.T) {
+	r := router.NewRouter()
+	r.GET("/id", func(req *request.Request, res *response.Response) {
+		res.WriteString(RequestIDFromContext(req.Context))
+	})
+	base, c := serve(t, r, RequestID())
+
+	tests := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{name: "none", incoming: ""},
+		{name: "reused", incoming: "upstream-1234", reused: true},
+		{name: "with a space", incoming: "two words"},
+		{name: "too long", incoming: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.incoming != "" {
+				headers["x-request-id"] = tt.incoming
+			}
+			res, body := fetch(t, c, "GET", base+"/id", headers, nil)
+			id := res.Headers["x-request-id"]
+			if string(body) != id {
+				t.Errorf("context holds %q, header %q", body, id)
+			}
+			if tt.reused {
+				if id != tt.incoming {
+					t.Errorf("got %q, want the incoming %q", id, tt.incoming)
+				}
+			} else if len(id) != 26 || strings.Trim(id, crockford) != "" {
+				t.Errorf("got %q, want a new ULID", id)
+			}
+		})
+	}
+}
+
+func TestNewRequestIDOrdered(t *testing.T) {
+	prev := newRequestID()
+	for i := 0; i < 1000; i++ {
+		id := newRequestID()
+		if id <= prev {
+			t.Fatalf("%q generated after %q", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestEncodeULID(t *testing.T) {
+	var zero, full [16]byte
+	for i := range full {
+		full[i] = 0xff
+	}
+	if got := encodeULID(zero); got != strings.Repeat("0", 26) {
+		t.Errorf("zero encodes to %q", got)
+	}
+	if got := encodeULID(full); got != "7"+strings.Repeat("Z", 25) {
+		t.Errorf("full encodes to %q", got)
+	}
+}
+
+func TestIncrementEntropy(t *testing.T) {
+	e := [10]byte{0, 0, 0, 0, 0, 0, 0, 1, 0xff, 0xff}
+	incrementEntropy(&e)
+	if e != [10]byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 0} {
+		t.Errorf("carry gave %v", e)
+	}
+}
+
+func TestRequestIDFromContextUnset(t *testing.T) {
+	if id := RequestIDFromContext(nil); id != "" {
+		t.Errorf("nil context gave %q", id)
+	}
+}
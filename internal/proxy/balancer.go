@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+)
+
+const (
+	// DefaultMaxFails is how many failures in a row eject a backend when
+	// Upstream.MaxFails is unset.
+	DefaultMaxFails = 3
+	// DefaultFailTimeout is how long an ejected backend sits out when
+	// Upstream.FailTimeout is unset.
+	DefaultFailTimeout = 30 * time.Second
+)
+
+// Backend is one server of an Upstream.
+type Backend struct {
+	URL *url.URL
+
+	active atomic.Int64
+
+	mu           sync.Mutex
+	down         bool // failed its last active health check
+	fails        int  // consecutive failures seen while proxying
+	ejectedUntil time.Time
+}
+
+// Active reports the requests the backend is serving right now.
+func (b *Backend) Active() int64 {
+	return b.active.Load()
+}
+
+// Available reports whether the backend takes requests: it passed its
+// last health check and is not ejected for failing requests.
+func (b *Backend) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.down && !time.Now().Before(b.ejectedUntil)
+}
+
+// Upstream is a set of interchangeable backends and the policy that picks
+// one for each request.
+type Upstream struct {
+	Policy Policy
+	// MaxFails requests in a row that a backend fails to answer take it
+	// out of rotation for FailTimeout; the Default constants apply to
+	// unset fields.
+	MaxFails    int
+	FailTimeout time.Duration
+
+	backends []*Backend
+}
+
+// NewUpstream builds an upstream over targets, http or https URLs. A nil
+// policy balances round-robin.
+func NewUpstream(policy Policy, targets ...string) (*Upstream, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("proxy upstream: no targets")
+	}
+	if policy == nil {
+		policy = RoundRobin()
+	}
+
+	u := &Upstream{Policy: policy}
+	for _, target := range targets {
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("proxy target: %v", err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, fmt.Errorf("proxy target: unsupported scheme %q", parsed.Scheme)
+		}
+		if parsed.Host == "" {
+			return nil, errors.New("proxy target: missing host")
+		}
+		u.backends = append(u.backends, &Backend{URL: parsed})
+	}
+	return u, nil
+}
+
+// Backends returns the backends in the order they were given.
+func (u *Upstream) Backends() []*Backend {
+	return u.backends
+}
+
+// pick asks the policy for an available backend not tried yet, or returns
+// nil when none is left. When every backend is down or ejected it picks
+// among them anyway, as nginx does: a stale verdict should not turn all
+// traffic away.
+func (u *Upstream) pick(req *request.Request, clientIP string, tried []*Backend) *Backend {
+	candidates := make([]*Backend, 0, len(u.backends))
+	var unavailable []*Backend
+	anyAvailable := false
+	for _, b := range u.backends {
+		available := b.Available()
+		anyAvailable = anyAvailable || available
+		if contains(tried, b) {
+			continue
+		}
+		if available {
+			candidates = append(candidates, b)
+		} else {
+			unavailable = append(unavailable, b)
+		}
+	}
+	if len(candidates) == 0 && !anyAvailable {
+		candidates = unavailable
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return u.Policy.Pick(req, clientIP, candidates)
+}
+
+// failed counts a request the backend could not serve and ejects it once
+// MaxFails failures follow each other.
+func (u *Upstream) failed(b *Backend) (ejected bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fails++
+	if b.fails < intOr(u.MaxFails, DefaultMaxFails) {
+		return false
+	}
+	b.fails = 0
+	b.ejectedUntil = time.Now().Add(durationOr(u.FailTimeout, DefaultFailTimeout))
+	return true
+}
+
+func (u *Upstream) succeeded(b *Backend) {
+	b.mu.Lock()
+	b.fails = 0
+	b.mu.Unlock()
+}
+
+func contains(backends []*Backend, b *Backend) bool {
+	for _, x := range backends {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy chooses the backend for a request among available candidates,
+// of which there is at least one.
+type Policy interface {
+	Pick(req *request.Request, clientIP string, candidates []*Backend) *Backend
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// RoundRobin hands requests to the candidates in turn.
+func RoundRobin() Policy {
+	return &roundRobin{}
+}
+
+func (rr *roundRobin) Pick(_ *request.Request, _ string, candidates []*Backend) *Backend {
+	n := rr.next.Add(1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+type leastConnections struct {
+	next atomic.Uint64
+}
+
+// LeastConnections picks the candidate serving the fewest requests,
+// rotating between those that tie.
+func LeastConnections() Policy {
+	return &leastConnections{}
+}
+
+func (lc *leastConnections) Pick(_ *request.Request, _ string, candidates []*Backend) *Backend {
+	start := int((lc.next.Add(1) - 1) % uint64(len(candidates)))
+	var best *Backend
+	for i := range candidates {
+		b := candidates[(start+i)%len(candidates)]
+		if best == nil || b.Active() < best.Active() {
+			best = b
+		}
+	}
+	return best
+}
+
+type consistentHash struct {
+	header string
+}
+
+// ConsistentHash sends requests with the same key to the same backend:
+// the value of header, or the client IP when header is empty or missing.
+// It uses rendezvous hashing, so a backend leaving or joining only moves
+// the keys that belong to it.
+func ConsistentHash(header string) Policy {
+	return &consistentHash{header: strings.ToLower(header)}
+}
+
+func (ch *consistentHash) Pick(req *request.Request, clientIP string, candidates []*Backend) *Backend {
+	key := clientIP
+	if ch.header != "" {
+		if v := req.Headers[ch.header]; v != "" {
+			key = v
+		}
+	}
+
+	var best *Backend
+	var bestScore uint64
+	for _, b := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(b.URL.String()))
+		if score := mix(h.Sum64()); best == nil || score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
+
+// mix spreads FNV output over the whole range (splitmix64 finaliser), so
+// similar keys do not favour one backend.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,320 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+)
+
+func newTestUpstream(t *testing.T, policy Policy, n int) *Upstream {
+	t.Helper()
+	targets := make([]string, n)
+	for i := range targets {
+		targets[i] = fmt.Sprintf("http://10.0.0.%d:8080", i+1)
+	}
+	u, err := NewUpstream(policy, targets...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestNewUpstream(t *testing.T) {
+	for _, targets := range [][]string{
+		nil,
+		{"ftp://10.0.0.1"},
+		{"http://"},
+		{"http://10.0.0.1", "://bad"},
+	} {
+		if _, err := NewUpstream(nil, targets...); err == nil {
+			t.Errorf("NewUpstream(%q) accepted", targets)
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	u := newTestUpstream(t, RoundRobin(), 3)
+	counts := map[*Backend]int{}
+	var prev *Backend
+	for i := 0; i < 30; i++ {
+		b := u.pick(nil, "", nil)
+		if b == prev {
+			t.Fatalf("pick %d repeated %s", i, b.URL.Host)
+		}
+		prev = b
+		counts[b]++
+	}
+	for _, b := range u.Backends() {
+		if counts[b] != 10 {
+			t.Errorf("%s got %d of 30", b.URL.Host, counts[b])
+		}
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	u := newTestUpstream(t, LeastConnections(), 3)
+	bs := u.Backends()
+	bs[0].active.Store(5)
+	bs[1].active.Store(2)
+	bs[2].active.Store(7)
+	for i := 0; i < 5; i++ {
+		if b := u.pick(nil, "", nil); b != bs[1] {
+			t.Fatalf("picked %s with %d active", b.URL.Host, b.Active())
+		}
+	}
+
+	// ties rotate rather than piling onto the first backend
+	for _, b := range bs {
+		b.active.Store(0)
+	}
+	counts := map[*Backend]int{}
+	for i := 0; i < 30; i++ {
+		counts[u.pick(nil, "", nil)]++
+	}
+	for _, b := range bs {
+		if counts[b] != 10 {
+			t.Errorf("%s got %d of 30 ties", b.URL.Host, counts[b])
+		}
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	u := newTestUpstream(t, ConsistentHash("X-User"), 4)
+	bs := u.Backends()
+
+	pick := func(user, ip string, candidates []*Backend) *Backend {
+		req := &request.Request{Headers: map[string]string{}}
+		if user != "" {
+			req.Headers["x-user"] = user
+		}
+		return u.Policy.Pick(req, ip, candidates)
+	}
+
+	t.Run("stable", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("user-%d", i)
+			if a, b := pick(key, "192.0.2.1", bs), pick(key, "192.0.2.2", bs); a != b {
+				t.Fatalf("%s went to %s and %s", key, a.URL.Host, b.URL.Host)
+			}
+		}
+	})
+
+	t.Run("client IP without the header", func(t *testing.T) {
+		if a, b := pick("", "192.0.2.1", bs), pick("", "192.0.2.1", bs); a != b {
+			t.Errorf("same client went to %s and %s", a.URL.Host, b.URL.Host)
+		}
+		spread := map[*Backend]bool{}
+		for i := 0; i < 100; i++ {
+			spread[pick("", fmt.Sprintf("192.0.2.%d", i), bs)] = true
+		}
+		if len(spread) != len(bs) {
+			t.Errorf("100 clients reached %d of %d backends", len(spread), len(bs))
+		}
+	})
+
+	t.Run("even spread", func(t *testing.T) {
+		const keys = 4000
+		counts := map[*Backend]int{}
+		for i := 0; i < keys; i++ {
+			counts[pick(fmt.Sprintf("user-%d", i), "", bs)]++
+		}
+		for _, b := range bs {
+			if share := float64(counts[b]) / keys; share < 0.2 || share > 0.3 {
+				t.Errorf("%s got %.2f of the keys", b.URL.Host, share)
+			}
+		}
+	})
+
+	t.Run("removing a backend moves only its keys", func(t *testing.T) {
+		gone := bs[2]
+		rest := []*Backend{bs[0], bs[1], bs[3]}
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("user-%d", i)
+			before, after := pick(key, "", bs), pick(key, "", rest)
+			if before != gone && before != after {
+				t.Fatalf("%s moved from %s to %s", key, before.URL.Host, after.URL.Host)
+			}
+		}
+	})
+}
+
+func TestPick(t *testing.T) {
+	u := newTestUpstream(t, RoundRobin(), 3)
+	bs := u.Backends()
+	set := func(down, ejected []bool) {
+		for i, b := range bs {
+			b.down = down[i]
+			b.ejectedUntil = time.Time{}
+			if ejected[i] {
+				b.ejectedUntil = time.Now().Add(time.Minute)
+			}
+		}
+	}
+	pickAll := func(tried []*Backend) map[*Backend]bool {
+		got := map[*Backend]bool{}
+		for i := 0; i < 12; i++ {
+			if b := u.pick(nil, "", tried); b != nil {
+				got[b] = true
+			}
+		}
+		return got
+	}
+
+	tests := []struct {
+		name          string
+		down, ejected []bool
+		tried         []*Backend
+		want          []*Backend
+	}{
+		{"all available", []bool{false, false, false}, []bool{false, false, false}, nil, bs},
+		{"down and ejected skipped", []bool{true, false, false}, []bool{false, false, true}, nil, bs[1:2]},
+		{"tried skipped", []bool{false, false, false}, []bool{false, false, false}, bs[:2], bs[2:]},
+		{"all out falls back to every one", []bool{true, true, false}, []bool{false, false, true}, nil, bs},
+		{"fallback skips tried", []bool{true, true, true}, []bool{false, false, false}, bs[:1], bs[1:]},
+		{"no fallback while one is up", []bool{true, false, true}, []bool{false, false, false}, bs[1:2], nil},
+		{"all tried", []bool{false, false, false}, []bool{false, false, false}, bs, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set(tt.down, tt.ejected)
+			got := pickAll(tt.tried)
+			if len(got) != len(tt.want) {
+				t.Errorf("picked %d backends, want %d", len(got), len(tt.want))
+			}
+			for _, b := range tt.want {
+				if !got[b] {
+					t.Errorf("%s never picked", b.URL.Host)
+				}
+			}
+		})
+	}
+}
+
+func TestPassiveEjection(t *testing.T) {
+	u := newTestUpstream(t, nil, 1)
+	u.MaxFails = 2
+	u.FailTimeout = 50 * time.Millisecond
+	b := u.Backends()[0]
+
+	if u.failed(b) || !b.Available() {
+		t.Fatal("ejected after one failure")
+	}
+	u.succeeded(b)
+	if u.failed(b) {
+		t.Fatal("a success did not reset the failure count")
+	}
+	if !u.failed(b) || b.Available() {
+		t.Fatal("not ejected after MaxFails failures in a row")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !b.Available() {
+		t.Error("still ejected after FailTimeout")
+	}
+}
+
+// countingUpstream is a backend that hangs up on every request unless ok
+// is set, counting what it received.
+func countingUpstream(t *testing.T, ok bool) (*url.URL, *atomic.Int32) {
+	t.Helper()
+	var n atomic.Int32
+	u := fakeUpstream(t, func(conn net.Conn, req upstreamRequest) bool {
+		n.Add(1)
+		if !ok {
+			return false
+		}
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+		return true
+	})
+	return u, &n
+}
+
+// refusedUpstream is an address nothing listens on.
+func refusedUpstream(t *testing.T) *url.URL {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://" + ln.Addr().String())
+	ln.Close()
+	return u
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		maxRetries int
+		wantTries  int
+	}{
+		{"idempotent retried up to the default", "GET", 0, DefaultMaxRetries + 1},
+		{"MaxRetries raised", "GET", 3, 4},
+		{"retries disabled", "GET", -1, 1},
+		{"POST not retried once sent", "POST", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var targets []*url.URL
+			var counts []*atomic.Int32
+			for i := 0; i < 5; i++ {
+				u, n := countingUpstream(t, false)
+				targets, counts = append(targets, u), append(counts, n)
+			}
+			p := newProxy(t, targets...)
+			p.MaxRetries = tt.maxRetries
+			addr := serveProxy(t, p)
+
+			_, _, status, _ := roundTrip(t, addr, tt.method+" /legacy HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n")
+			if !strings.Contains(status, " 502 ") {
+				t.Errorf("status %q, want 502", status)
+			}
+			tries := 0
+			for _, n := range counts {
+				tries += int(n.Load())
+			}
+			if tries != tt.wantTries {
+				t.Errorf("backends saw %d requests, want %d", tries, tt.wantTries)
+			}
+		})
+	}
+}
+
+func TestRetryAfterDialError(t *testing.T) {
+	ok, n := countingUpstream(t, true)
+	// round-robin starts at the first of the refused ones
+	p := newProxy(t, refusedUpstream(t), refusedUpstream(t), ok)
+	addr := serveProxy(t, p)
+
+	// nothing reached a backend, so even a POST may go to the next one
+	_, _, status, _ := roundTrip(t, addr, "POST /legacy HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n")
+	if !strings.Contains(status, " 200 ") {
+		t.Errorf("status %q, want 200", status)
+	}
+	if n.Load() != 1 {
+		t.Errorf("backend saw %d requests", n.Load())
+	}
+}
+
+func TestNoBackendAnswersInTime(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := fakeUpstream(t, func(conn net.Conn, req upstreamRequest) bool {
+		<-release
+		return false
+	})
+	p := newProxy(t, slow)
+	p.Timeout = 100 * time.Millisecond
+	addr := serveProxy(t, p)
+
+	_, _, status, _ := roundTrip(t, addr, "GET /legacy HTTP/1.1\r\nHost: x\r\n\r\n")
+	if !strings.Contains(status, " 504 ") {
+		t.Errorf("status %q, want 504", status)
+	}
+}
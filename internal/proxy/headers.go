@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/brutally-Honest/http-server/internal/client"
+	"github.com/brutally-Honest/http-server/internal/request"
+)
+
+// hopByHopHeaders describe a single connection and are never forwarded
+// (RFC 9110 section 7.6.1).
+var hopByHopHeaders = map[string]bool{
+	"connection":          true,
+	"proxy-connection":    true,
+	"keep-alive":          true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"te":                  true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+}
+
+// hopByHop reports whether the lower-case header name k stays on this hop,
+// either by definition or because the Connection header lists it.
+func hopByHop(k, connection string) bool {
+	return hopByHopHeaders[k] || hasToken(connection, k)
+}
+
+// outRequest builds the request sent to b: the client's headers without
+// the hop-by-hop ones, plus the forwarding headers. The client sets the
+// framing; the body is always sent with a Content-Length, as the server
+// has read it in full already.
+func (p *ReverseProxy) outRequest(req *request.Request, b *Backend) *client.Request {
+	headers := make(map[string]string, len(req.Headers)+5)
+	for k, v := range req.Headers {
+		if hopByHop(k, req.Headers["connection"]) {
+			continue
+		}
+		headers[k] = v
+	}
+	setForwarded(headers, req)
+
+	if !p.PreserveHost {
+		delete(headers, "host") // the client names the backend
+	}
+	if isUpgrade(req) {
+		// the one hop-by-hop exchange that has to reach the upstream
+		headers["connection"] = "Upgrade"
+		headers["upgrade"] = req.Headers["upgrade"]
+	}
+	return &client.Request{
+		Method:  req.Method,
+		URL:     b.URL,
+		Headers: headers,
+		Body:    req.Body,
+		Target:  joinPath(b.URL, req.Path),
+		Context: req.Context,
+	}
+}
+
+// setForwarded records the client on the request: X-Forwarded-For and
+// Forwarded gain an entry for this hop, X-Forwarded-Proto and
+// X-Forwarded-Host describe the request as the client made it, which
+// trusted proxies in front may have told us.
+func setForwarded(headers map[string]string, req *request.Request) {
+	proto := req.Scheme()
+	host := req.Host()
+	clientIP := remoteIP(req)
+
+	if clientIP != "" {
+		headers["x-forwarded-for"] = appendList(headers["x-forwarded-for"], clientIP)
+	}
+	headers["x-forwarded-proto"] = proto
+	if host != "" {
+		headers["x-forwarded-host"] = host
+	}
+
+	// RFC 7239: IPv6 addresses are bracketed and quoted
+	forwardedFor := clientIP
+	if strings.Contains(clientIP, ":") {
+		forwardedFor = "[" + clientIP + "]"
+	}
+	elem := make([]string, 0, 3)
+	if forwardedFor != "" {
+		elem = append(elem, "for="+forwardedValue(forwardedFor))
+	}
+	if host != "" {
+		elem = append(elem, "host="+forwardedValue(host))
+	}
+	elem = append(elem, "proto="+proto)
+	headers["forwarded"] = appendList(headers["forwarded"], strings.Join(elem, ";"))
+}
+
+// remoteIP is the peer this hop received the request from.
+func remoteIP(req *request.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func appendList(list, v string) string {
+	if list == "" {
+		return v
+	}
+	return list + ", " + v
+}
+
+// forwardedValue quotes v unless it is a plain token.
+func forwardedValue(v string) string {
+	for i := 0; i < len(v); i++ {
+		if !isTokenChar(v[i]) {
+			return strconv.Quote(v)
+		}
+	}
+	return v
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
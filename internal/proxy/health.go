@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/client"
+)
+
+const (
+	// DefaultHealthInterval applies when HealthCheck.Interval is unset.
+	DefaultHealthInterval = 10 * time.Second
+	// DefaultHealthTimeout applies when HealthCheck.Timeout is unset.
+	DefaultHealthTimeout = 2 * time.Second
+)
+
+// HealthCheck probes every backend with GET Path. A 2xx or 3xx answer
+// marks the backend up, anything else marks it down until a later probe
+// passes.
+type HealthCheck struct {
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// StartHealthChecks probes the backends of p's upstream right away and
+// then every Interval, until ctx is done.
+func (p *ReverseProxy) StartHealthChecks(ctx context.Context, hc HealthCheck) {
+	if hc.Path == "" {
+		hc.Path = "/"
+	}
+	interval := durationOr(hc.Interval, DefaultHealthInterval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.checkAll(ctx, hc)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *ReverseProxy) checkAll(ctx context.Context, hc HealthCheck) {
+	var wg sync.WaitGroup
+	for _, b := range p.Upstream.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.probe(ctx, b, hc)
+
+			b.mu.Lock()
+			wasDown := b.down
+			b.down = err != nil
+			b.mu.Unlock()
+
+			switch {
+			case err != nil && !wasDown:
+				p.logger().Warn("proxy backend down", "backend", b.URL.Host, "error", err)
+			case err == nil && wasDown:
+				p.logger().Info("proxy backend up", "backend", b.URL.Host)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// probe sends one health check request and reads the status.
+func (p *ReverseProxy) probe(ctx context.Context, b *Backend, hc HealthCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, durationOr(hc.Timeout, DefaultHealthTimeout))
+	defer cancel()
+
+	res, err := p.upstreamClient().Do(&client.Request{
+		Method: "GET",
+		URL:    b.URL,
+		Headers: map[string]string{
+			"connection": "close",
+			"user-agent": "http-server-health-check",
+		},
+		Target:  joinPath(b.URL, hc.Path),
+		Context: ctx,
+	})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return &statusError{res.StatusCode}
+	}
+	return nil
+}
+
+// statusError is a health check answered with a failing status.
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string { return fmt.Sprintf("health check answered %d", e.status) }
@@ -0,0 +1,280 @@
+// Package proxy forwards requests to upstream HTTP/1.1 servers and relays
+// their answers, so the server can front legacy backends itself.
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/client"
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+)
+
+const (
+	// DefaultTimeout applies when ReverseProxy.Timeout is unset.
+	DefaultTimeout = 30 * time.Second
+	// DefaultDialTimeout applies when ReverseProxy.DialTimeout is unset.
+	DefaultDialTimeout = 5 * time.Second
+	// DefaultMaxIdleConns is how many keep-alive upstream connections are
+	// kept when ReverseProxy.MaxIdleConns is unset.
+	DefaultMaxIdleConns = 16
+	// DefaultIdleConnTimeout is how long an unused upstream connection is
+	// kept when ReverseProxy.IdleConnTimeout is unset.
+	DefaultIdleConnTimeout = 90 * time.Second
+	// DefaultMaxRetries applies when ReverseProxy.MaxRetries is zero.
+	DefaultMaxRetries = 2
+
+	copyBufferSize = 32 * 1024
+)
+
+// ReverseProxy is a router.Handler that forwards every request to a
+// backend of Upstream over pooled keep-alive connections and streams the
+// response back.
+type ReverseProxy struct {
+	Upstream *Upstream
+	// PreserveHost forwards the client's Host header instead of the
+	// backend's host.
+	PreserveHost bool
+	// TLSConfig is used for https backends; nil uses the defaults.
+	TLSConfig *tls.Config
+	// MaxRetries is how many other backends an idempotent request is sent
+	// to after one fails without answering; zero uses DefaultMaxRetries
+	// and a negative value disables retries.
+	MaxRetries int
+	Logger     logger.Logger
+
+	// Timeout bounds the wait for the response head and for each read of
+	// the body; the Default constants below apply to unset fields.
+	Timeout         time.Duration
+	DialTimeout     time.Duration
+	MaxIdleConns    int
+	IdleConnTimeout time.Duration
+
+	clientOnce sync.Once
+	httpClient *client.Client
+}
+
+// New builds a proxy for a single backend, an http or https URL such as
+// http://10.0.0.5:8080/legacy; the URL path is prefixed to every
+// forwarded request path.
+func New(target string) (*ReverseProxy, error) {
+	u, err := NewUpstream(nil, target)
+	if err != nil {
+		return nil, err
+	}
+	return &ReverseProxy{Upstream: u}, nil
+}
+
+// Serve is a router.Handler; mount it on a wildcard route to proxy a whole
+// subtree. A request that fails before the backend answers is retried on
+// another backend when that is safe: always if the connection could not
+// be made, otherwise only for idempotent methods.
+func (p *ReverseProxy) Serve(req *request.Request, res *response.Response) {
+	clientIP := req.RealIP()
+	var tried []*Backend
+	var lastErr error
+	for {
+		b := p.Upstream.pick(req, clientIP, tried)
+		if b == nil {
+			if lastErr == nil {
+				req.Logger.Warn("proxy has no available backend")
+				res.WriteHeader(503)
+				res.WriteString(response.StatusText(503))
+				return
+			}
+			p.fail(req, res, lastErr)
+			return
+		}
+		tried = append(tried, b)
+
+		b.active.Add(1)
+		err := p.forward(req, res, b)
+		b.active.Add(-1)
+		if err == nil {
+			p.Upstream.succeeded(b)
+			return
+		}
+		if req.Context.Err() != nil {
+			req.Logger.Debug("proxy request cancelled", "error", err)
+			return
+		}
+
+		lastErr = err
+		if p.Upstream.failed(b) {
+			p.logger().Warn("proxy backend ejected", "backend", b.URL.Host, "error", err)
+		}
+		var de *client.DialError
+		if !idempotent(req.Method) && !errors.As(err, &de) || len(tried) > p.maxRetries() {
+			p.fail(req, res, err)
+			return
+		}
+		req.Logger.Debug("proxy retrying on another backend", "backend", b.URL.Host, "error", err)
+	}
+}
+
+// forward proxies the request to b. An error means b did not answer and
+// nothing was sent to the client yet.
+func (p *ReverseProxy) forward(req *request.Request, res *response.Response, b *Backend) error {
+	if isUpgrade(req) {
+		return p.serveUpgrade(req, res, b)
+	}
+
+	up, err := p.upstreamClient().Do(p.outRequest(req, b))
+	if err != nil {
+		return err
+	}
+	p.copyResponse(req, res, up)
+	return nil
+}
+
+// CloseIdleConnections closes the pooled backend connections.
+func (p *ReverseProxy) CloseIdleConnections() {
+	p.upstreamClient().CloseIdleConnections()
+}
+
+// copyResponse relays the upstream response. Chunked bodies are passed on
+// chunk by chunk, so event streams reach the client as they are produced.
+func (p *ReverseProxy) copyResponse(req *request.Request, res *response.Response, up *client.Response) {
+	defer up.Body.Close()
+
+	chunked := up.Chunked && req.Version != "HTTP/1.0"
+	for k, v := range up.Headers {
+		if hopByHop(k, up.Headers["connection"]) {
+			continue
+		}
+		if k == "trailer" && !chunked || k == "set-cookie" {
+			continue
+		}
+		res.SetHeader(client.CanonicalKey(k), v)
+	}
+	for _, c := range up.SetCookies {
+		res.AddHeader("Set-Cookie", c)
+	}
+	if chunked {
+		res.SetHeader("Transfer-Encoding", "chunked")
+	}
+	res.WriteHeader(up.StatusCode)
+
+	if err := copyBody(res, up, chunked); err != nil {
+		req.Logger.Debug("proxy body copy failed", "error", err)
+		res.Abort(err)
+		return
+	}
+	if chunked {
+		for k, v := range up.Trailers {
+			res.SetTrailer(client.CanonicalKey(k), v)
+		}
+		res.EndChunked()
+	}
+}
+
+func copyBody(res *response.Response, up *client.Response, chunked bool) error {
+	buf := make([]byte, copyBufferSize)
+	for {
+		n, err := up.Body.Read(buf)
+		if n > 0 {
+			var werr error
+			if chunked {
+				werr = res.WriteChunk(buf[:n])
+			} else {
+				_, werr = res.Write(buf[:n])
+			}
+			if werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// fail answers a request no backend could serve: 504 when the last one
+// did not answer in time, 502 otherwise.
+func (p *ReverseProxy) fail(req *request.Request, res *response.Response, err error) {
+	code := 502
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		code = 504
+	}
+	req.Logger.Warn("proxy upstream failed", "error", err)
+	res.WriteHeader(code)
+	res.WriteString(response.StatusText(code))
+}
+
+// upstreamClient sets the backend client up on first use, once the fields
+// of p are final. It keeps a pool of connections per backend and leaves
+// redirects to the client of the proxy.
+func (p *ReverseProxy) upstreamClient() *client.Client {
+	p.clientOnce.Do(func() {
+		p.httpClient = &client.Client{
+			TLSConfig:           p.TLSConfig,
+			Timeout:             durationOr(p.Timeout, DefaultTimeout),
+			DialTimeout:         durationOr(p.DialTimeout, DefaultDialTimeout),
+			MaxIdleConnsPerHost: intOr(p.MaxIdleConns, DefaultMaxIdleConns),
+			IdleConnTimeout:     durationOr(p.IdleConnTimeout, DefaultIdleConnTimeout),
+			MaxRedirects:        -1,
+		}
+	})
+	return p.httpClient
+}
+
+func (p *ReverseProxy) maxRetries() int {
+	if p.MaxRetries < 0 {
+		return 0
+	}
+	return intOr(p.MaxRetries, DefaultMaxRetries)
+}
+
+func (p *ReverseProxy) logger() logger.Logger {
+	if p.Logger == nil {
+		return logger.Discard()
+	}
+	return p.Logger
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+func intOr(n, def int) int {
+	if n > 0 {
+		return n
+	}
+	return def
+}
+
+// joinPath joins the path prefix of u and the request target, which still
+// carries its query.
+func joinPath(u *url.URL, path string) string {
+	prefix := strings.TrimSuffix(u.EscapedPath(), "/")
+	if prefix == "" {
+		return path
+	}
+	if path == "" || path == "*" {
+		return prefix + "/"
+	}
+	return prefix + path
+}
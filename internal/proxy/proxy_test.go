@@ -0,0 +1,546 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/client"
+	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/router"
+	"github.com/brutally-Honest/http-server/internal/server"
+)
+
+// upstreamRequest is what a fake backend received.
+type upstreamRequest struct {
+	line    string
+	headers map[string]string
+	body    string
+}
+
+// fakeUpstream runs a backend on a loopback port; handle answers each
+// request read off a connection and returns false to close it.
+func fakeUpstream(t *testing.T, handle func(conn net.Conn, req upstreamRequest) bool) *url.URL {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					req, err := readUpstreamRequest(br)
+					if err != nil || !handle(conn, req) {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	u, _ := url.Parse("http://" + ln.Addr().String())
+	return u
+}
+
+func readUpstreamRequest(br *bufio.Reader) (upstreamRequest, error) {
+	var req upstreamRequest
+	line, headers, err := readHead(br)
+	if err != nil {
+		return req, err
+	}
+	req.line, req.headers = line, headers
+	if cl := headers["content-length"]; cl != "" {
+		n, _ := strconv.Atoi(cl)
+		body := make([]byte, n)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return req, err
+		}
+		req.body = string(body)
+	}
+	return req, nil
+}
+
+// readHead reads a request or status line and the fields after it.
+func readHead(br *bufio.Reader) (string, map[string]string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", nil, err
+	}
+	headers := map[string]string{}
+	for {
+		field, err := br.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		field = strings.TrimRight(field, "\r\n")
+		if field == "" {
+			return strings.TrimRight(line, "\r\n"), headers, nil
+		}
+		k, v, _ := strings.Cut(field, ":")
+		headers[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+}
+
+// serveProxy mounts p on /legacy of a server and returns its address.
+func serveProxy(t *testing.T, p *ReverseProxy) string {
+	t.Helper()
+	r := router.NewRouter()
+	for _, route := range []string{"/legacy", "/legacy/*path"} {
+		for _, method := range []string{"GET", "HEAD", "POST", "PUT"} {
+			r.Register(method, route, p.Serve)
+		}
+	}
+	cfg := config.Load(4096, 1<<20, 8192, 5*time.Second, 5*time.Second)
+	s := server.NewServer("127.0.0.1:0", cfg, r)
+	s.Logger = logger.Discard()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() {
+		p.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return ln.Addr().String()
+}
+
+// received waits for the request a fake backend got.
+func received(t *testing.T, got <-chan upstreamRequest) upstreamRequest {
+	t.Helper()
+	select {
+	case req := <-got:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("the backend got no request")
+		return upstreamRequest{}
+	}
+}
+
+func newProxy(t *testing.T, targets ...*url.URL) *ReverseProxy {
+	t.Helper()
+	raw := make([]string, len(targets))
+	for i, u := range targets {
+		raw[i] = u.String()
+	}
+	u, err := NewUpstream(nil, raw...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ReverseProxy{Upstream: u, Timeout: 2 * time.Second}
+}
+
+// roundTrip sends raw to addr on a fresh connection and reads one
+// response head.
+func roundTrip(t *testing.T, addr, raw string) (net.Conn, *bufio.Reader, string, map[string]string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	status, headers, err := readHead(br)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	return conn, br, status, headers
+}
+
+func TestOutRequest(t *testing.T) {
+	backend, _ := url.Parse("http://10.0.0.5:8080/app/")
+	b := &Backend{URL: backend}
+	tests := []struct {
+		name         string
+		remote       string
+		headers      map[string]string
+		preserveHost bool
+		want         map[string]string
+	}{
+		{
+			name:   "hop-by-hop fields are dropped",
+			remote: "192.0.2.1:4000",
+			headers: map[string]string{
+				"host":       "example.com",
+				"connection": "keep-alive, X-Secret",
+				"keep-alive": "timeout=5",
+				"x-secret":   "1",
+				"te":         "trailers",
+				"accept":     "*/*",
+			},
+			want: map[string]string{
+				"accept":            "*/*",
+				"x-forwarded-for":   "192.0.2.1",
+				"x-forwarded-proto": "http",
+				"x-forwarded-host":  "example.com",
+				"forwarded":         "for=192.0.2.1;host=example.com;proto=http",
+			},
+		},
+		{
+			name:   "forwarding headers are appended to",
+			remote: "192.0.2.1:4000",
+			headers: map[string]string{
+				"host":            "example.com",
+				"x-forwarded-for": "203.0.113.9",
+				"forwarded":       "for=203.0.113.9",
+			},
+			want: map[string]string{
+				"x-forwarded-for":   "203.0.113.9, 192.0.2.1",
+				"x-forwarded-proto": "http",
+				"x-forwarded-host":  "example.com",
+				"forwarded":         "for=203.0.113.9, for=192.0.2.1;host=example.com;proto=http",
+			},
+		},
+		{
+			name:    "IPv6 clients are bracketed and quoted in Forwarded",
+			remote:  "[2001:db8::1]:4000",
+			headers: map[string]string{"host": "example.com:8443"},
+			want: map[string]string{
+				"x-forwarded-for":   "2001:db8::1",
+				"x-forwarded-proto": "http",
+				"x-forwarded-host":  "example.com:8443",
+				"forwarded":         `for="[2001:db8::1]";host="example.com:8443";proto=http`,
+			},
+		},
+		{
+			name:         "PreserveHost keeps the client's Host",
+			remote:       "192.0.2.1:4000",
+			headers:      map[string]string{"host": "example.com"},
+			preserveHost: true,
+			want: map[string]string{
+				"host":              "example.com",
+				"x-forwarded-for":   "192.0.2.1",
+				"x-forwarded-proto": "http",
+				"x-forwarded-host":  "example.com",
+				"forwarded":         "for=192.0.2.1;host=example.com;proto=http",
+			},
+		},
+		{
+			name:   "upgrades keep Connection and Upgrade",
+			remote: "192.0.2.1:4000",
+			headers: map[string]string{
+				"connection": "keep-alive, Upgrade",
+				"upgrade":    "websocket",
+			},
+			want: map[string]string{
+				"connection":        "Upgrade",
+				"upgrade":           "websocket",
+				"x-forwarded-for":   "192.0.2.1",
+				"x-forwarded-proto": "http",
+				"forwarded":         "for=192.0.2.1;proto=http",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ReverseProxy{PreserveHost: tt.preserveHost}
+			req := &request.Request{
+				Method:     "GET",
+				Path:       "/users?id=1",
+				Version:    "HTTP/1.1",
+				Headers:    tt.headers,
+				RemoteAddr: tt.remote,
+			}
+			out := p.outRequest(req, b)
+			if out.Target != "/app/users?id=1" {
+				t.Errorf("target %q", out.Target)
+			}
+			if len(out.Headers) != len(tt.want) {
+				t.Errorf("headers %v, want %v", out.Headers, tt.want)
+			}
+			for k, v := range tt.want {
+				if out.Headers[k] != v {
+					t.Errorf("%s: %q, want %q", k, out.Headers[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestJoinPath(t *testing.T) {
+	tests := []struct {
+		base, path, want string
+	}{
+		{"http://b", "/x?q=1", "/x?q=1"},
+		{"http://b/", "/x", "/x"},
+		{"http://b/app", "/x", "/app/x"},
+		{"http://b/app/", "/x", "/app/x"},
+		{"http://b/app", "", "/app/"},
+		{"http://b/app", "*", "/app/"},
+		{"http://b/a%2Fb", "/x", "/a%2Fb/x"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.base)
+		if got := joinPath(u, tt.path); got != tt.want {
+			t.Errorf("joinPath(%q, %q) = %q, want %q", tt.base, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestProxyRelaysHeaders(t *testing.T) {
+	got := make(chan upstreamRequest, 1)
+	backend := fakeUpstream(t, func(conn net.Conn, req upstreamRequest) bool {
+		got <- req
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\n"+
+			"Connection: X-Private\r\n"+
+			"X-Private: 1\r\n"+
+			"Keep-Alive: timeout=5\r\n"+
+			"Proxy-Authenticate: Basic\r\n"+
+			"X-Kept: yes\r\n"+
+			"Content-Length: 2\r\n\r\nok")
+		return true
+	})
+	addr := serveProxy(t, newProxy(t, backend))
+
+	_, br, status, headers := roundTrip(t, addr, "GET /legacy/a?b=c HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Connection: keep-alive, X-Secret\r\n"+
+		"X-Secret: 1\r\n"+
+		"Te: trailers\r\n"+
+		"X-Forwarded-For: 203.0.113.9\r\n\r\n")
+
+	req := received(t, got)
+	if req.line != "GET /legacy/a?b=c HTTP/1.1" {
+		t.Errorf("upstream request line %q", req.line)
+	}
+	for _, k := range []string{"x-secret", "te"} {
+		if v, ok := req.headers[k]; ok {
+			t.Errorf("upstream got hop-by-hop %s: %q", k, v)
+		}
+	}
+	if req.headers["host"] != backend.Host {
+		t.Errorf("upstream Host %q, want %q", req.headers["host"], backend.Host)
+	}
+	if req.headers["x-forwarded-for"] != "203.0.113.9, 127.0.0.1" {
+		t.Errorf("X-Forwarded-For %q", req.headers["x-forwarded-for"])
+	}
+	if req.headers["x-forwarded-host"] != "example.com" {
+		t.Errorf("X-Forwarded-Host %q", req.headers["x-forwarded-host"])
+	}
+
+	if status != "HTTP/1.1 200 OK" || headers["x-kept"] != "yes" {
+		t.Errorf("got %s %v", status, headers)
+	}
+	for _, k := range []string{"x-private", "keep-alive", "proxy-authenticate"} {
+		if v, ok := headers[k]; ok {
+			t.Errorf("client got hop-by-hop %s: %q", k, v)
+		}
+	}
+	if headers["connection"] != "keep-alive" {
+		t.Errorf("Connection %q, the proxy's own", headers["connection"])
+	}
+	body := make([]byte, 2)
+	if _, err := io.ReadFull(br, body); err != nil || string(body) != "ok" {
+		t.Errorf("body %q: %v", body, err)
+	}
+}
+
+func TestProxySetCookies(t *testing.T) {
+	backend := fakeUpstream(t, func(conn net.Conn, req upstreamRequest) bool {
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\n"+
+			"Set-Cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\n"+
+			"Vary: Accept\r\n"+
+			"set-cookie: b=2\r\n"+
+			"Vary: Origin\r\n"+
+			"Content-Length: 2\r\n\r\nok")
+		return true
+	})
+	addr := serveProxy(t, newProxy(t, backend))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /legacy HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	// readHead would fold the repeated fields, so read the lines as sent
+	br := bufio.NewReader(conn)
+	var cookies, vary []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		k, v, _ := strings.Cut(line, ":")
+		switch strings.ToLower(k) {
+		case "set-cookie":
+			cookies = append(cookies, strings.TrimSpace(v))
+		case "vary":
+			vary = append(vary, strings.TrimSpace(v))
+		}
+	}
+	want := []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"}
+	if strings.Join(cookies, "|") != strings.Join(want, "|") {
+		t.Errorf("Set-Cookie lines %q, want %q", cookies, want)
+	}
+	if len(vary) != 1 || vary[0] != "Accept, Origin" {
+		t.Errorf("Vary lines %q, want one joined field", vary)
+	}
+}
+
+func TestProxyFraming(t *testing.T) {
+	got := make(chan upstreamRequest, 1)
+	backend := fakeUpstream(t, func(conn net.Conn, req upstreamRequest) bool {
+		got <- req
+		switch req.line[strings.IndexByte(req.line, ' ')+1 : strings.LastIndexByte(req.line, ' ')] {
+		case "/legacy/chunked":
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\n"+
+				"Transfer-Encoding: chunked\r\n"+
+				"Trailer: X-Checksum\r\n\r\n"+
+				"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n")
+		default:
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 11\r\n\r\nhello world")
+		}
+		return true
+	})
+	addr := serveProxy(t, newProxy(t, backend))
+	c := &client.Client{}
+	defer c.CloseIdleConnections()
+
+	t.Run("chunked with trailers", func(t *testing.T) {
+		res, err := c.Get(context.Background(), "http://"+addr+"/legacy/chunked")
+		if err != nil {
+			t.Fatal(err)
+		}
+		received(t, got)
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || string(body) != "hello world" {
+			t.Fatalf("body %q: %v", body, err)
+		}
+		if !res.Chunked {
+			t.Error("response not chunked")
+		}
+		if res.Trailers["x-checksum"] != "abc" {
+			t.Errorf("trailers %v", res.Trailers)
+		}
+	})
+
+	t.Run("chunked to an HTTP/1.0 client", func(t *testing.T) {
+		_, br, status, headers := roundTrip(t, addr, "GET /legacy/chunked HTTP/1.0\r\nHost: x\r\n\r\n")
+		received(t, got)
+		if !strings.HasSuffix(status, " 200 OK") {
+			t.Errorf("status %q", status)
+		}
+		if te, ok := headers["transfer-encoding"]; ok {
+			t.Errorf("Transfer-Encoding %q sent to HTTP/1.0", te)
+		}
+		body, _ := io.ReadAll(br)
+		if string(body) != "hello world" {
+			t.Errorf("body %q", body)
+		}
+	})
+
+	t.Run("content-length", func(t *testing.T) {
+		res, err := c.Get(context.Background(), "http://"+addr+"/legacy/fixed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		received(t, got)
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.Chunked || res.ContentLength != 11 || string(body) != "hello world" {
+			t.Errorf("chunked %v length %d body %q", res.Chunked, res.ContentLength, body)
+		}
+	})
+
+	t.Run("chunked request body", func(t *testing.T) {
+		_, _, status, _ := roundTrip(t, addr, "POST /legacy/fixed HTTP/1.1\r\n"+
+			"Host: x\r\nTransfer-Encoding: chunked\r\n\r\n"+
+			"3\r\nabc\r\n2\r\nde\r\n0\r\n\r\n")
+		req := received(t, got)
+		if status != "HTTP/1.1 200 OK" {
+			t.Errorf("status %q", status)
+		}
+		if req.body != "abcde" || req.headers["content-length"] != "5" {
+			t.Errorf("upstream body %q with Content-Length %q", req.body, req.headers["content-length"])
+		}
+		if te, ok := req.headers["transfer-encoding"]; ok {
+			t.Errorf("upstream got Transfer-Encoding %q", te)
+		}
+	})
+}
+
+func TestProxyUpgradeTunnel(t *testing.T) {
+	backend := fakeUpstream(t, func(conn net.Conn, req upstreamRequest) bool {
+		if req.headers["upgrade"] != "echo" || req.headers["connection"] != "Upgrade" {
+			io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+			return false
+		}
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Connection: Upgrade\r\nUpgrade: echo\r\nX-Echo: on\r\n\r\n")
+		io.Copy(conn, conn)
+		return false
+	})
+	addr := serveProxy(t, newProxy(t, backend))
+
+	// the first bytes of the new protocol follow the handshake at once
+	conn, br, status, headers := roundTrip(t, addr, "GET /legacy/ws HTTP/1.1\r\n"+
+		"Host: x\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nping")
+	if status != "HTTP/1.1 101 Switching Protocols" {
+		t.Fatalf("status %q", status)
+	}
+	if headers["upgrade"] != "echo" || headers["x-echo"] != "on" {
+		t.Errorf("headers %v", headers)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo %q: %v", buf, err)
+	}
+	io.WriteString(conn, "pong")
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("echo %q: %v", buf, err)
+	}
+
+	// closing the client side tears the tunnel down
+	conn.(*net.TCPConn).CloseWrite()
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("tunnel still open: %v", err)
+	}
+}
+
+func TestProxyUpgradeRefused(t *testing.T) {
+	backend := fakeUpstream(t, func(conn net.Conn, req upstreamRequest) bool {
+		io.WriteString(conn, "HTTP/1.1 403 Forbidden\r\nContent-Length: 4\r\n\r\nnope")
+		return true
+	})
+	addr := serveProxy(t, newProxy(t, backend))
+
+	_, br, status, _ := roundTrip(t, addr, "GET /legacy/ws HTTP/1.1\r\n"+
+		"Host: x\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	if status != "HTTP/1.1 403 Forbidden" {
+		t.Fatalf("status %q", status)
+	}
+	body := make([]byte, 4)
+	if _, err := io.ReadFull(br, body); err != nil || string(body) != "nope" {
+		t.Errorf("body %q: %v", body, err)
+	}
+}
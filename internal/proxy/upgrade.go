@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/brutally-Honest/http-server/internal/client"
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+)
+
+// isUpgrade reports whether req asks to switch protocols, as a WebSocket
+// handshake does. Only HTTP/1.1 requests can.
+func isUpgrade(req *request.Request) bool {
+	return req.Version == "HTTP/1.1" && req.Headers["upgrade"] != "" &&
+		hasToken(req.Headers["connection"], "upgrade")
+}
+
+// serveUpgrade forwards the handshake. If the backend switches protocols,
+// the client connection is hijacked and bytes are relayed both ways until
+// either side closes; any other answer is relayed as a normal response.
+// Like forward, it fails only while nothing was sent to the client.
+func (p *ReverseProxy) serveUpgrade(req *request.Request, res *response.Response, b *Backend) error {
+	up, err := p.upstreamClient().Do(p.outRequest(req, b))
+	if err != nil {
+		return err
+	}
+	if up.StatusCode != 101 {
+		p.copyResponse(req, res, up)
+		return nil
+	}
+	upstream := up.Body.(io.ReadWriteCloser)
+
+	for k, v := range up.Headers {
+		if hopByHop(k, up.Headers["connection"]) {
+			continue
+		}
+		res.SetHeader(client.CanonicalKey(k), v)
+	}
+	res.SetHeader("Connection", "Upgrade")
+	res.SetHeader("Upgrade", up.Headers["upgrade"])
+	res.WriteHeader(101)
+	if err := res.Finish(); err != nil {
+		upstream.Close()
+		req.Logger.Debug("proxy upgrade failed", "error", err)
+		return nil
+	}
+
+	conn, buffered, err := res.Hijack()
+	if err != nil {
+		upstream.Close()
+		req.Logger.Debug("proxy upgrade failed", "error", err)
+		return nil
+	}
+	req.Logger.Debug("proxy tunnel open", "protocol", up.Headers["upgrade"])
+	tunnel(conn, buffered, upstream)
+	req.Logger.Debug("proxy tunnel closed")
+	return nil
+}
+
+// tunnel copies between the client and the upstream until one side stops,
+// then closes both. buffered holds client bytes the server read already;
+// the upstream side likewise starts with any bytes that followed its 101.
+func tunnel(conn net.Conn, buffered []byte, upstream io.ReadWriteCloser) {
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			conn.Close()
+			upstream.Close()
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer closeBoth()
+		if len(buffered) > 0 {
+			if _, err := upstream.Write(buffered); err != nil {
+				return
+			}
+		}
+		io.Copy(upstream, conn)
+	}()
+
+	io.Copy(conn, upstream)
+	closeBoth()
+	<-done
+}
+
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+// Package proxyproto reads the PROXY protocol header (versions 1 and 2)
+// that TCP load balancers such as HAProxy put in front of a connection to
+// pass on the addresses of the client they accepted it from.
+//
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNoHeader means the connection did not start with a PROXY header.
+	ErrNoHeader = errors.New("proxyproto: missing PROXY protocol header")
+	// ErrInvalidHeader means the header could not be parsed.
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY protocol header")
+)
+
+// v2Signature opens every version 2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Header is the longest version 1 line, CRLF included.
+const maxV1Header = 107
+
+// Header is what a PROXY protocol header said about a connection.
+type Header struct {
+	Version int // 1 or 2
+	// Source is the client the balancer accepted the connection from and
+	// Destination the address the client connected to. Both are nil when
+	// the header carries no addresses: a version 2 LOCAL command, as
+	// balancers send for their own health checks, or an UNKNOWN family.
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// Read reads a version 1 or version 2 header off br.
+func Read(br *bufio.Reader) (*Header, error) {
+	// both the shortest header and the shortest request line are longer
+	// than the signature, so this never waits on a peer that is done
+	start, err := br.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(start, v2Signature):
+		return readV2(br)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readV1(br)
+	}
+	return nil, ErrNoHeader
+}
+
+// readV1 parses the text form:
+//
+//	PROXY TCP4 192.0.2.1 198.51.100.7 56324 443\r\n
+func readV1(br *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < maxV1Header {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: line too long or not CRLF-terminated", ErrInvalidHeader)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	h := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil // the rest of the line is to be ignored
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, line)
+	}
+
+	src, err := v1Addr(fields[2], fields[4], fields[1] == "TCP6")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := v1Addr(fields[3], fields[5], fields[1] == "TCP6")
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func v1Addr(ip, port string, v6 bool) (net.Addr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || (addr.To4() == nil) != v6 {
+		return nil, fmt.Errorf("%w: bad address %q", ErrInvalidHeader, ip)
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || port[0] == '0' && len(port) > 1 {
+		return nil, fmt.Errorf("%w: bad port %q", ErrInvalidHeader, port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(n)}, nil
+}
+
+// readV2 parses the binary form: the signature, a version and command
+// byte, an address family and protocol byte, the length of the rest, then
+// the addresses and any TLVs, which are skipped.
+func readV2(br *bufio.Reader) (*Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(br, fixed[:]); err != nil {
+		return nil, eofUnexpected(err)
+	}
+	verCmd, family := fixed[12], fixed[13]
+	rest := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(br, rest); err != nil {
+		return nil, eofUnexpected(err)
+	}
+
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidHeader, verCmd>>4)
+	}
+	h := &Header{Version: 2}
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL: the balancer speaking for itself
+		return h, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: command %#x", ErrInvalidHeader, verCmd&0x0f)
+	}
+
+	// the high nibble is the address family, the low one the protocol
+	switch family >> 4 {
+	case 0x1: // IPv4
+		if len(rest) < 12 {
+			return nil, fmt.Errorf("%w: short IPv4 addresses", ErrInvalidHeader)
+		}
+		h.Source = v2Addr(family, rest[0:4], rest[8:10])
+		h.Destination = v2Addr(family, rest[4:8], rest[10:12])
+	case 0x2: // IPv6
+		if len(rest) < 36 {
+			return nil, fmt.Errorf("%w: short IPv6 addresses", ErrInvalidHeader)
+		}
+		h.Source = v2Addr(family, rest[0:16], rest[32:34])
+		h.Destination = v2Addr(family, rest[16:32], rest[34:36])
+	case 0x3: // unix sockets
+		if len(rest) < 216 {
+			return nil, fmt.Errorf("%w: short unix addresses", ErrInvalidHeader)
+		}
+		h.Source = &net.UnixAddr{Name: cString(rest[0:108]), Net: "unix"}
+		h.Destination = &net.UnixAddr{Name: cString(rest[108:216]), Net: "unix"}
+	case 0x0: // UNSPEC: addresses unknown
+	default:
+		return nil, fmt.Errorf("%w: address family %#x", ErrInvalidHeader, family>>4)
+	}
+	return h, nil
+}
+
+func v2Addr(family byte, ip, port []byte) net.Addr {
+	addr := net.IP(bytes.Clone(ip))
+	p := int(binary.BigEndian.Uint16(port))
+	if family&0x0f == 0x2 {
+		return &net.UDPAddr{IP: addr, Port: p}
+	}
+	return &net.TCPAddr{IP: addr, Port: p}
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func eofUnexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
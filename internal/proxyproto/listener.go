@@ -0,0 +1,149 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout applies when Listener.HeaderTimeout is unset.
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Listener wraps the connections of a TCP listener that sits behind a
+// load balancer. Connections from Trusted sources must start with a PROXY
+// header, and report the addresses it carries; an empty Trusted trusts
+// every source. Connections from anywhere else are handed out as they are,
+// so a PROXY header they send is never believed.
+type Listener struct {
+	net.Listener
+	Trusted []*net.IPNet
+	// HeaderTimeout bounds the wait for the header.
+	HeaderTimeout time.Duration
+}
+
+// Accept waits for the next connection. The header is read on the first
+// call to Header or Read of the returned *Conn, in the caller's goroutine,
+// so a slow peer never holds up the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	timeout := l.HeaderTimeout
+	if timeout <= 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &Conn{Conn: conn, br: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	if len(l.Trusted) == 0 {
+		return true
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.Trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted source. Once its header is read,
+// RemoteAddr and LocalAddr report the addresses the header gave.
+type Conn struct {
+	net.Conn
+	br      *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+
+	mu           sync.Mutex
+	readDeadline time.Time // set by the user, put back after the header
+	headerDone   bool
+}
+
+// Header reads the PROXY header if that has not happened yet and returns
+// it. A connection whose header is missing or malformed fails every Read
+// with the same error and should be closed.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.header, c.err = Read(c.br)
+
+		c.mu.Lock()
+		c.headerDone = true
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.mu.Unlock()
+	})
+	return c.header, c.err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	// the reader holds the bytes that followed the header, if any
+	if c.br.Buffered() > 0 {
+		return c.br.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr reports the client the header named, or the peer of the
+// connection until the header is read or when it names none.
+func (c *Conn) RemoteAddr() net.Addr {
+	if h := c.readHeader(); h != nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr reports the address the client connected to, as for
+// RemoteAddr.
+func (c *Conn) LocalAddr() net.Addr {
+	if h := c.readHeader(); h != nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	if !c.headerDone {
+		return c.Conn.SetWriteDeadline(t)
+	}
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	if !c.headerDone {
+		return nil
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+// readHeader returns the header if it was read already, without waiting
+// for it.
+func (c *Conn) readHeader() *Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.headerDone {
+		return nil
+	}
+	return c.header
+}
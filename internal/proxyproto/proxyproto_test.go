@@ -0,0 +1,305 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// v2 builds a version 2 header around rest, the addresses and TLVs.
+func v2(verCmd, family byte, rest []byte) []byte {
+	b := append([]byte(nil), v2Signature...)
+	b = append(b, verCmd, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rest)))
+	return append(b, rest...)
+}
+
+func ipv4Addrs() []byte {
+	b := []byte{192, 0, 2, 1, 198, 51, 100, 7}
+	b = binary.BigEndian.AppendUint16(b, 56324)
+	return binary.BigEndian.AppendUint16(b, 443)
+}
+
+func ipv6Addrs() []byte {
+	b := append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...)
+	b = binary.BigEndian.AppendUint16(b, 56324)
+	return binary.BigEndian.AppendUint16(b, 443)
+}
+
+func unixAddrs() []byte {
+	b := make([]byte, 216)
+	copy(b, "/run/client.sock")
+	copy(b[108:], "/run/server.sock")
+	return b
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       []byte
+		version  int
+		src, dst string // empty when the header carries no address
+		wantErr  error
+	}{
+		{
+			name:    "v1 TCP4",
+			in:      []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"),
+			version: 1, src: "192.168.0.1:56324", dst: "192.168.0.11:443",
+		},
+		{
+			name:    "v1 TCP4 longest",
+			in:      []byte("PROXY TCP4 255.255.255.255 255.255.255.255 65535 65535\r\n"),
+			version: 1, src: "255.255.255.255:65535", dst: "255.255.255.255:65535",
+		},
+		{
+			name: "v1 TCP6 longest",
+			in: []byte("PROXY TCP6 ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff " +
+				"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n"),
+			version: 1,
+			src:     "[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535",
+			dst:     "[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535",
+		},
+		{
+			name:    "v1 TCP6",
+			in:      []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			version: 1, src: "[2001:db8::1]:56324", dst: "[2001:db8::2]:443",
+		},
+		{name: "v1 UNKNOWN", in: []byte("PROXY UNKNOWN\r\n"), version: 1},
+		{
+			name:    "v1 UNKNOWN ignores the rest",
+			in:      []byte("PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\n"),
+			version: 1,
+		},
+		{name: "v1 port 0", in: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 0 443\r\n"), version: 1, src: "192.0.2.1:0", dst: "192.0.2.2:443"},
+		{name: "v1 bare LF", in: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 1 2\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 too long", in: []byte("PROXY UNKNOWN " + strings.Repeat("x", 100) + "\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 unknown protocol", in: []byte("PROXY TCP5 192.0.2.1 192.0.2.2 1 2\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 missing port", in: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 1\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 double space", in: []byte("PROXY TCP4  192.0.2.1 192.0.2.2 1 2\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 IPv6 under TCP4", in: []byte("PROXY TCP4 2001:db8::1 192.0.2.2 1 2\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 IPv4 under TCP6", in: []byte("PROXY TCP6 192.0.2.1 2001:db8::2 1 2\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 port too large", in: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 65536 2\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 port with leading zero", in: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 080 2\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 signed port", in: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 +80 2\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 truncated", in: []byte("PROXY TCP4 192.0.2.1 192.0"), wantErr: io.EOF},
+
+		{name: "v2 LOCAL", in: v2(0x20, 0x00, nil), version: 2},
+		{name: "v2 LOCAL with addresses", in: v2(0x20, 0x11, ipv4Addrs()), version: 2},
+		{name: "v2 TCP4", in: v2(0x21, 0x11, ipv4Addrs()), version: 2, src: "192.0.2.1:56324", dst: "198.51.100.7:443"},
+		{name: "v2 TCP6", in: v2(0x21, 0x21, ipv6Addrs()), version: 2, src: "[2001:db8::1]:56324", dst: "[2001:db8::2]:443"},
+		{name: "v2 UDP4", in: v2(0x21, 0x12, ipv4Addrs()), version: 2, src: "192.0.2.1:56324", dst: "198.51.100.7:443"},
+		{name: "v2 unix", in: v2(0x21, 0x31, unixAddrs()), version: 2, src: "/run/client.sock", dst: "/run/server.sock"},
+		{name: "v2 UNSPEC", in: v2(0x21, 0x00, nil), version: 2},
+		{
+			name:    "v2 TLVs are skipped",
+			in:      v2(0x21, 0x11, append(ipv4Addrs(), 0x04, 0x00, 0x03, 'a', 'b', 'c')),
+			version: 2, src: "192.0.2.1:56324", dst: "198.51.100.7:443",
+		},
+		{name: "v2 version 1", in: v2(0x11, 0x11, ipv4Addrs()), wantErr: ErrInvalidHeader},
+		{name: "v2 unknown command", in: v2(0x22, 0x11, ipv4Addrs()), wantErr: ErrInvalidHeader},
+		{name: "v2 unknown family", in: v2(0x21, 0x41, ipv4Addrs()), wantErr: ErrInvalidHeader},
+		{name: "v2 short IPv4", in: v2(0x21, 0x11, ipv4Addrs()[:11]), wantErr: ErrInvalidHeader},
+		{name: "v2 short IPv6", in: v2(0x21, 0x21, ipv4Addrs()), wantErr: ErrInvalidHeader},
+		{name: "v2 short unix", in: v2(0x21, 0x31, unixAddrs()[:215]), wantErr: ErrInvalidHeader},
+		{name: "v2 truncated fixed part", in: v2(0x21, 0x11, nil)[:14], wantErr: io.ErrUnexpectedEOF},
+		{name: "v2 truncated addresses", in: v2(0x21, 0x11, ipv4Addrs())[:20], wantErr: io.ErrUnexpectedEOF},
+
+		{name: "no header", in: []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"), wantErr: ErrNoHeader},
+		{name: "lower-case v1", in: []byte("proxy TCP4 192.0.2.1 192.0.2.2 1 2\r\n"), wantErr: ErrNoHeader},
+		{name: "shorter than a signature", in: []byte("PROXY"), wantErr: io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// what follows the header must be left on the reader
+			br := bufio.NewReader(bytes.NewReader(append(tt.in, "next"...)))
+			if tt.wantErr == io.EOF || tt.wantErr == io.ErrUnexpectedEOF {
+				br = bufio.NewReader(bytes.NewReader(tt.in))
+			}
+			h, err := Read(br)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Version != tt.version {
+				t.Errorf("version %d, want %d", h.Version, tt.version)
+			}
+			if got := addrString(h.Source); got != tt.src {
+				t.Errorf("source %q, want %q", got, tt.src)
+			}
+			if got := addrString(h.Destination); got != tt.dst {
+				t.Errorf("destination %q, want %q", got, tt.dst)
+			}
+			if rest, _ := io.ReadAll(br); string(rest) != "next" {
+				t.Errorf("left %q after the header", rest)
+			}
+		})
+	}
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
+
+// pipeConn wraps one end of net.Pipe as a connection from a trusted
+// balancer.
+func pipeConn(timeout time.Duration) (*Conn, net.Conn) {
+	client, server := net.Pipe()
+	return &Conn{Conn: server, br: bufio.NewReader(server), timeout: timeout}, client
+}
+
+func TestConn(t *testing.T) {
+	c, client := pipeConn(time.Second)
+	defer c.Close()
+	go func() {
+		io.WriteString(client, "PROXY TCP4 192.0.2.1 198.51.100.7 56324 443\r\nGET / HTTP/1.1\r\n")
+		client.Close()
+	}()
+
+	// nothing is read until the first Read or Header
+	if _, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		t.Error("RemoteAddr came from the header before it was read")
+	}
+	body, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "GET / HTTP/1.1\r\n" {
+		t.Errorf("read %q", body)
+	}
+	if got := c.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("RemoteAddr %s", got)
+	}
+	if got := c.LocalAddr().String(); got != "198.51.100.7:443" {
+		t.Errorf("LocalAddr %s", got)
+	}
+}
+
+func TestConnLocal(t *testing.T) {
+	c, client := pipeConn(time.Second)
+	defer c.Close()
+	go client.Write(v2(0x20, 0x00, nil))
+
+	h, err := c.Header()
+	if err != nil || h.Version != 2 || h.Source != nil {
+		t.Fatalf("header %+v, %v", h, err)
+	}
+	// a LOCAL header leaves the connection's own addresses
+	if c.RemoteAddr() != c.Conn.RemoteAddr() {
+		t.Errorf("RemoteAddr %v", c.RemoteAddr())
+	}
+}
+
+func TestConnSlowHeader(t *testing.T) {
+	c, client := pipeConn(50 * time.Millisecond)
+	defer c.Close()
+	defer client.Close()
+	go io.WriteString(client, "PROXY TCP4 192.0.2.1") // and then nothing
+
+	start := time.Now()
+	_, err := c.Read(make([]byte, 16))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("error %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %v", elapsed)
+	}
+	if _, again := c.Read(make([]byte, 16)); again != err {
+		t.Errorf("second Read returned %v, want %v", again, err)
+	}
+}
+
+func TestConnDeadlineKept(t *testing.T) {
+	c, client := pipeConn(time.Second)
+	defer c.Close()
+	defer client.Close()
+
+	// a deadline set before the header applies to what follows it
+	c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	go io.WriteString(client, "PROXY UNKNOWN\r\n")
+	if _, err := c.Header(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Read(make([]byte, 1))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("error %v, want a timeout", err)
+	}
+}
+
+func TestConnNoHeader(t *testing.T) {
+	c, client := pipeConn(time.Second)
+	defer c.Close()
+	defer client.Close()
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	if _, err := c.Read(make([]byte, 64)); !errors.Is(err, ErrNoHeader) {
+		t.Errorf("error %v, want ErrNoHeader", err)
+	}
+}
+
+func TestListener(t *testing.T) {
+	const header = "PROXY TCP4 192.0.2.1 198.51.100.7 56324 443\r\n"
+	loopback := &net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}
+	elsewhere := &net.IPNet{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}
+	tests := []struct {
+		name     string
+		trusted  []*net.IPNet
+		wantRead string
+		wantAddr string
+	}{
+		{"trusted source", []*net.IPNet{loopback}, "hello", "192.0.2.1:56324"},
+		{"everyone trusted", nil, "hello", "192.0.2.1:56324"},
+		{"untrusted source passes through", []*net.IPNet{elsewhere}, header + "hello", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln := &Listener{Listener: inner, Trusted: tt.trusted}
+			defer ln.Close()
+
+			go func() {
+				client, err := net.Dial("tcp", inner.Addr().String())
+				if err != nil {
+					return
+				}
+				io.WriteString(client, header+"hello")
+				client.Close()
+			}()
+
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.wantRead {
+				t.Errorf("read %q, want %q", got, tt.wantRead)
+			}
+			if addr := conn.RemoteAddr().String(); !strings.HasPrefix(addr, tt.wantAddr) {
+				t.Errorf("RemoteAddr %s, want %s", addr, tt.wantAddr)
+			}
+		})
+	}
+}
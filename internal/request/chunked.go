@@ -0,0 +1,125 @@
+package request
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// ChunkedReader decodes a chunked message body (RFC 9112 section 7.1).
+// Once Read has returned io.EOF, Trailers holds the trailer fields and the
+// reader stands right after the message.
+type ChunkedReader struct {
+	Trailers map[string]string
+
+	br      *bufio.Reader
+	limit   int   // bounds each chunk size line and the trailer section
+	left    int64 // bytes left in the current chunk
+	started bool
+	err     error
+}
+
+// NewChunkedReader decodes the body that br is positioned at. headLimit
+// bounds each chunk size line and the trailer section.
+func NewChunkedReader(br *bufio.Reader, headLimit int) *ChunkedReader {
+	return &ChunkedReader{br: br, limit: headLimit}
+}
+
+func (c *ChunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.left == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.br.Read(p)
+	c.left -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+// nextChunk reads past the end of the previous chunk and the next size
+// line; the last chunk is followed by the trailers.
+func (c *ChunkedReader) nextChunk() error {
+	lr := &LineReader{R: c.br, Limit: c.limit}
+	if c.started {
+		line, err := lr.ReadLine()
+		if err != nil {
+			return eofUnexpected(err)
+		}
+		if line != "" {
+			return errors.New("malformed chunk: missing CRLF after data")
+		}
+	}
+	c.started = true
+
+	line, err := lr.ReadLine()
+	if err != nil {
+		return eofUnexpected(err)
+	}
+	n, err := parseChunkSize(line)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		c.left = n
+		return nil
+	}
+
+	trailers, _, err := lr.ReadFields()
+	if err != nil {
+		return err
+	}
+	c.Trailers = trailers
+	return io.EOF
+}
+
+// parseChunkSize reads the chunk-size of a size line, 1*HEXDIG, which may
+// be followed by chunk extensions; they carry nothing we use. Signs, inner
+// whitespace and sizes past int64 are refused.
+func parseChunkSize(line string) (int64, error) {
+	var n int64
+	i := 0
+	for ; i < len(line); i++ {
+		d := unhex(line[i])
+		if d < 0 {
+			break
+		}
+		if n > math.MaxInt64>>4 {
+			return 0, fmt.Errorf("chunk size %q too large", line)
+		}
+		n = n<<4 | int64(d)
+	}
+	if i == 0 {
+		return 0, fmt.Errorf("malformed chunk size %q", line)
+	}
+	// only an extension may follow, after optional whitespace
+	if rest := line[i:]; rest != "" && !strings.HasPrefix(strings.TrimLeft(rest, " \t"), ";") {
+		return 0, fmt.Errorf("malformed chunk size %q", line)
+	}
+	return n, nil
+}
+
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
@@ -6,4 +6,7 @@ var (
 	ErrHeaderLimitExceeded = errors.New("header size limit exceeded")
 	ErrBodyLimitExceeded   = errors.New("body size limit exceeded")
 	ErrConnectionClosed    = errors.New("connection closed by client")
+	// ErrHTTP2Preface means the client opened with the HTTP/2 connection
+	// preface; the bytes are left for Buffered.
+	ErrHTTP2Preface = errors.New("HTTP/2 connection preface")
 )
@@ -0,0 +1,135 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrLineTooLong means a message head grew past the limit of its
+// LineReader.
+var ErrLineTooLong = errors.New("message head too large")
+
+// LineReader reads the CRLF-terminated lines of a message head: a request
+// or status line, header fields, chunk sizes and trailers. It fails once
+// the lines read add up to more than Limit bytes.
+type LineReader struct {
+	R     *bufio.Reader
+	Limit int
+
+	n int
+}
+
+// Size reports the bytes read so far, line endings included.
+func (lr *LineReader) Size() int {
+	return lr.n
+}
+
+// ReadLine reads one line without its CRLF. A bare LF is refused rather
+// than taken for a line ending, as readers that disagree on where a line
+// ends can be played against each other. A line cut short by the end of
+// the input fails with io.ErrUnexpectedEOF, no input at all with io.EOF.
+func (lr *LineReader) ReadLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := lr.R.ReadSlice('\n')
+		lr.n += len(chunk)
+		if lr.n > lr.Limit {
+			return "", ErrLineTooLong
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if !bytes.HasSuffix(line, []byte("\r\n")) {
+			return "", errors.New("line not terminated by CRLF")
+		}
+		return string(line[:len(line)-2]), nil
+	}
+}
+
+// ReadFields reads header or trailer fields up to the blank line; see
+// addField for repeated fields. Set-Cookie is the exception RFC 9110
+// section 5.3 names: its values may hold commas themselves (RFC 6265
+// section 3), so its lines are also returned one by one in cookies.
+func (lr *LineReader) ReadFields() (fields map[string]string, cookies []string, err error) {
+	fields = make(map[string]string)
+	for {
+		line, err := lr.ReadLine()
+		if err != nil {
+			return nil, nil, eofUnexpected(err)
+		}
+		if line == "" {
+			return fields, cookies, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, nil, errors.New("obsolete line folding")
+		}
+
+		k, v, ok := parseFieldLine(line)
+		if !ok {
+			return nil, nil, fmt.Errorf("malformed header field %q", line)
+		}
+		if err := addField(fields, k, v); err != nil {
+			return nil, nil, err
+		}
+		if k == "set-cookie" {
+			cookies = append(cookies, v)
+		}
+	}
+}
+
+// singleFields may appear only once in a message head. A second Host or
+// framing field would leave it to each reader to pick one, and readers
+// that pick differently can be played against each other.
+var singleFields = map[string]bool{
+	"host":              true,
+	"content-length":    true,
+	"transfer-encoding": true,
+}
+
+// addField records a field line under its lower-case name. A repeated
+// field is joined to the earlier value with a comma, as RFC 9110 section
+// 5.3 allows, unless it is one of singleFields.
+func addField(fields map[string]string, k, v string) error {
+	prev, ok := fields[k]
+	if !ok {
+		fields[k] = v
+		return nil
+	}
+	if singleFields[k] {
+		return errors.New("duplicate header: " + k)
+	}
+	fields[k] = prev + ", " + v
+	return nil
+}
+
+// parseFieldLine splits name: value, lower-casing the name and trimming
+// the whitespace around both.
+func parseFieldLine(line string) (key, value string, ok bool) {
+	colon := strings.IndexByte(line, ':')
+	if colon <= 0 {
+		return "", "", false
+	}
+	key = strings.ToLower(strings.TrimSpace(line[:colon]))
+	if key == "" {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(line[colon+1:]), true
+}
+
+func eofUnexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
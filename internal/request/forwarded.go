@@ -0,0 +1,191 @@
+package request
+
+import (
+	"net"
+	"strings"
+)
+
+// RealIP is the address of the client that made the request. Behind
+// TrustedProxies it comes from X-Forwarded-For, or from the Forwarded
+// header with TrustForwarded: the list is walked from the nearest hop
+// back, skipping trusted proxies, so entries a client wrote itself are
+// never believed. Otherwise it is the peer of the connection.
+func (r *Request) RealIP() string {
+	ip, _, _ := r.resolve()
+	return ip
+}
+
+// Scheme is http or https as the client used it, from the X-Forwarded-Proto
+// or Forwarded proto set by a trusted proxy, or from the connection.
+func (r *Request) Scheme() string {
+	_, scheme, _ := r.resolve()
+	return scheme
+}
+
+// Host is the host the client asked for, from the X-Forwarded-Host or
+// Forwarded host set by a trusted proxy, or the Host header.
+func (r *Request) Host() string {
+	_, _, host := r.resolve()
+	return host
+}
+
+// resolve works out the client address, scheme and host. Forwarding
+// headers only count when the peer is a trusted proxy, and only the kind
+// TrustForwarded names: the other one may have come from the client.
+func (r *Request) resolve() (ip, scheme, host string) {
+	ip = peerIP(r.RemoteAddr)
+	scheme = "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host = r.Headers["host"]
+	if !r.trusted(ip) {
+		return ip, scheme, host
+	}
+
+	if r.TrustForwarded {
+		elems := parseForwarded(r.Headers["forwarded"])
+		// the element added by the proxy the client connected to
+		i := len(elems) - 1
+		for ; i >= 0; i-- {
+			addr := forwardedIP(elems[i]["for"])
+			if addr == "" {
+				break // obfuscated or unknown: go no further back
+			}
+			ip = addr
+			if !r.trusted(addr) {
+				break
+			}
+		}
+		if i < 0 {
+			i = 0
+		}
+		if i < len(elems) {
+			if p := strings.ToLower(elems[i]["proto"]); p == "http" || p == "https" {
+				scheme = p
+			}
+			if h := elems[i]["host"]; h != "" {
+				host = h
+			}
+		}
+		return ip, scheme, host
+	}
+
+	hops := splitList(r.Headers["x-forwarded-for"])
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr := forwardedIP(hops[i])
+		if addr == "" {
+			break
+		}
+		ip = addr
+		if !r.trusted(addr) {
+			break
+		}
+	}
+	// each proxy overwrites these; if one appended instead, the last
+	// value is the nearest proxy's
+	if p := lastItem(r.Headers["x-forwarded-proto"]); p == "http" || p == "https" {
+		scheme = p
+	}
+	if h := lastItem(r.Headers["x-forwarded-host"]); h != "" {
+		host = h
+	}
+	return ip, scheme, host
+}
+
+func (r *Request) trusted(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range r.TrustedProxies {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded splits an RFC 7239 Forwarded header into its elements,
+// each a map of lower-case parameter names to unquoted values.
+func parseForwarded(v string) []map[string]string {
+	var elems []map[string]string
+	for _, elem := range splitQuoted(v, ',') {
+		pairs := make(map[string]string)
+		for _, pair := range splitQuoted(elem, ';') {
+			k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+			val = strings.TrimSpace(val)
+			if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+				val = strings.ReplaceAll(val[1:len(val)-1], `\`, "")
+			}
+			pairs[strings.ToLower(strings.TrimSpace(k))] = val
+		}
+		elems = append(elems, pairs)
+	}
+	return elems
+}
+
+// splitQuoted splits s on sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// forwardedIP reads the address out of a for= value or X-Forwarded-For
+// entry, which may carry a port and, for IPv6, brackets. Obfuscated
+// identifiers and "unknown" give "".
+func forwardedIP(v string) string {
+	v = strings.TrimSpace(v)
+	if ip := net.ParseIP(v); ip != nil {
+		return ip.String()
+	}
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			return ip.String()
+		}
+	}
+	if strings.HasPrefix(v, "[") && strings.HasSuffix(v, "]") {
+		if ip := net.ParseIP(v[1 : len(v)-1]); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+func peerIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+func lastItem(v string) string {
+	items := splitList(v)
+	if len(items) == 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(items[len(items)-1]))
+}
@@ -0,0 +1,223 @@
+package request
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	_, v6proxies, _ := net.ParseCIDR("2001:db8:1::/48")
+	trusted := []*net.IPNet{private, v6proxies}
+
+	tests := []struct {
+		name           string
+		peer           string
+		tls            bool
+		trustForwarded bool
+		headers        map[string]string
+		ip, scheme     string
+		host           string
+	}{
+		{
+			name:    "untrusted peer",
+			peer:    "203.0.113.7:5000",
+			headers: map[string]string{"host": "example.com", "x-forwarded-for": "6.6.6.6", "x-forwarded-host": "evil"},
+			ip:      "203.0.113.7", scheme: "http", host: "example.com",
+		},
+		{
+			name:           "untrusted peer with Forwarded trusted",
+			peer:           "203.0.113.7:5000",
+			trustForwarded: true,
+			headers:        map[string]string{"host": "example.com", "forwarded": "for=6.6.6.6;proto=https"},
+			ip:             "203.0.113.7", scheme: "http", host: "example.com",
+		},
+		{
+			name:    "untrusted peer over TLS",
+			peer:    "203.0.113.7:5000",
+			tls:     true,
+			headers: map[string]string{"host": "example.com", "x-forwarded-proto": "http"},
+			ip:      "203.0.113.7", scheme: "https", host: "example.com",
+		},
+		{
+			name: "trusted proxy",
+			peer: "10.0.0.1:5000",
+			headers: map[string]string{
+				"host":              "backend",
+				"x-forwarded-for":   "203.0.113.7",
+				"x-forwarded-proto": "https",
+				"x-forwarded-host":  "example.com",
+			},
+			ip: "203.0.113.7", scheme: "https", host: "example.com",
+		},
+		{
+			name:    "spoofed X-Forwarded-For entries",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"x-forwarded-for": "10.9.9.9, 6.6.6.6, 203.0.113.7"},
+			ip:      "203.0.113.7", scheme: "http",
+		},
+		{
+			name:    "chain of trusted proxies",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"x-forwarded-for": "6.6.6.6, 203.0.113.7, 10.0.0.3, 10.0.0.2"},
+			ip:      "203.0.113.7", scheme: "http",
+		},
+		{
+			name:    "every hop trusted",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"x-forwarded-for": "10.0.0.3, 10.0.0.2"},
+			ip:      "10.0.0.3", scheme: "http",
+		},
+		{
+			name:    "garbage stops the walk",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"x-forwarded-for": "6.6.6.6, not-an-ip, 10.0.0.2"},
+			ip:      "10.0.0.2", scheme: "http",
+		},
+		{
+			name:    "last X-Forwarded-Proto and Host count",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"x-forwarded-proto": "http, HTTPS", "x-forwarded-host": "evil, Example.com"},
+			ip:      "10.0.0.1", scheme: "https", host: "example.com",
+		},
+		{
+			name:    "unknown X-Forwarded-Proto ignored",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"x-forwarded-proto": "ftp"},
+			ip:      "10.0.0.1", scheme: "http",
+		},
+		{
+			name: "Forwarded ignored by default",
+			peer: "10.0.0.1:5000",
+			headers: map[string]string{
+				"host":            "example.com",
+				"forwarded":       "for=6.6.6.6;proto=https;host=evil",
+				"x-forwarded-for": "203.0.113.7",
+			},
+			ip: "203.0.113.7", scheme: "http", host: "example.com",
+		},
+		{
+			name:    "Forwarded alone ignored by default",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"forwarded": "for=6.6.6.6"},
+			ip:      "10.0.0.1", scheme: "http",
+		},
+		{
+			name:           "Forwarded trusted",
+			peer:           "10.0.0.1:5000",
+			trustForwarded: true,
+			headers: map[string]string{
+				"host":      "backend",
+				"forwarded": "for=6.6.6.6;host=evil, for=203.0.113.7;proto=https;host=example.com, for=10.0.0.2",
+			},
+			ip: "203.0.113.7", scheme: "https", host: "example.com",
+		},
+		{
+			name:           "X-Forwarded-* ignored when Forwarded is trusted",
+			peer:           "10.0.0.1:5000",
+			trustForwarded: true,
+			headers: map[string]string{
+				"host":              "example.com",
+				"x-forwarded-for":   "6.6.6.6",
+				"x-forwarded-proto": "https",
+				"x-forwarded-host":  "evil",
+			},
+			ip: "10.0.0.1", scheme: "http", host: "example.com",
+		},
+		{
+			name:           "Forwarded parameters are case-insensitive",
+			peer:           "10.0.0.1:5000",
+			trustForwarded: true,
+			headers:        map[string]string{"forwarded": "For=203.0.113.7;Proto=HTTPS"},
+			ip:             "203.0.113.7", scheme: "https",
+		},
+		{
+			name:           "Forwarded with an obfuscated hop",
+			peer:           "10.0.0.1:5000",
+			trustForwarded: true,
+			headers:        map[string]string{"forwarded": "for=203.0.113.7, for=_hidden, for=10.0.0.2"},
+			ip:             "10.0.0.2", scheme: "http",
+		},
+		{
+			name:           "Forwarded for=unknown",
+			peer:           "10.0.0.1:5000",
+			trustForwarded: true,
+			headers:        map[string]string{"forwarded": "for=unknown"},
+			ip:             "10.0.0.1", scheme: "http",
+		},
+		{
+			name:           "Forwarded quoted values",
+			peer:           "10.0.0.1:5000",
+			trustForwarded: true,
+			headers:        map[string]string{"forwarded": `for="203.0.113.7:4711";host="example.com:8443;x=\"y\""`},
+			ip:             "203.0.113.7", scheme: "http", host: `example.com:8443;x="y"`,
+		},
+		{
+			name:           "IPv6 for= with a port",
+			peer:           "10.0.0.1:5000",
+			trustForwarded: true,
+			headers:        map[string]string{"forwarded": `for="[2001:db8:2::7]:4711"`},
+			ip:             "2001:db8:2::7", scheme: "http",
+		},
+		{
+			name:           "IPv6 for= without a port",
+			peer:           "10.0.0.1:5000",
+			trustForwarded: true,
+			headers:        map[string]string{"forwarded": `for="[2001:DB8:2::7]"`},
+			ip:             "2001:db8:2::7", scheme: "http",
+		},
+		{
+			name:           "IPv6 proxies",
+			peer:           "[2001:db8:1::1]:5000",
+			trustForwarded: true,
+			headers:        map[string]string{"forwarded": `for="[2001:db8:2::6]", for="[2001:db8:2::7]", for="[2001:db8:1::2]"`},
+			ip:             "2001:db8:2::7", scheme: "http",
+		},
+		{
+			name:    "IPv6 in X-Forwarded-For",
+			peer:    "[2001:db8:1::1]:5000",
+			headers: map[string]string{"x-forwarded-for": "2001:db8:2::6, [2001:db8:2::7]:443"},
+			ip:      "2001:db8:2::7", scheme: "http",
+		},
+		{
+			name:    "IPv6 peer untrusted",
+			peer:    "[2001:db8:2::9]:5000",
+			headers: map[string]string{"x-forwarded-for": "203.0.113.7"},
+			ip:      "2001:db8:2::9", scheme: "http",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{
+				Headers:        tt.headers,
+				RemoteAddr:     tt.peer,
+				TrustedProxies: trusted,
+				TrustForwarded: tt.trustForwarded,
+			}
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if got := req.RealIP(); got != tt.ip {
+				t.Errorf("RealIP %q, want %q", got, tt.ip)
+			}
+			if got := req.Scheme(); got != tt.scheme {
+				t.Errorf("Scheme %q, want %q", got, tt.scheme)
+			}
+			if got := req.Host(); got != tt.host {
+				t.Errorf("Host %q, want %q", got, tt.host)
+			}
+		})
+	}
+}
+
+func TestResolveWithoutTrustedProxies(t *testing.T) {
+	req := &Request{
+		Headers:        map[string]string{"x-forwarded-for": "203.0.113.7", "forwarded": "for=203.0.113.7"},
+		RemoteAddr:     "10.0.0.1:5000",
+		TrustForwarded: true,
+	}
+	if got := req.RealIP(); got != "10.0.0.1" {
+		t.Errorf("RealIP %q with no trusted proxies", got)
+	}
+}
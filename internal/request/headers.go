@@ -2,10 +2,8 @@ package request
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"strconv"
-	"strings"
 )
 
 func parseHeaders(headers []byte) (map[string]string, error) {
@@ -25,20 +23,13 @@ func parseHeaders(headers []byte) (map[string]string, error) {
 			continue
 		}
 
-		// Split on first colon
-		colonIdx := bytes.IndexByte(line, ':')
-		if colonIdx == -1 {
+		key, value, ok := parseFieldLine(string(line))
+		if !ok {
 			continue // Skip malformed headers
 		}
-
-		key := strings.ToLower(string(bytes.TrimSpace(line[:colonIdx])))
-		if _, exists := headerMap[key]; exists {
-			return nil, errors.New("duplicate header: " + key)
+		if err := addField(headerMap, key, value); err != nil {
+			return nil, err
 		}
-
-		value := string(bytes.TrimSpace(line[colonIdx+1:]))
-		fmt.Printf("%s:%s\n", key, value)
-		headerMap[key] = value
 	}
 
 	return headerMap, nil
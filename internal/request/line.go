@@ -3,7 +3,6 @@ package request
 import (
 	"bytes"
 	"errors"
-	"log"
 )
 
 func parseRequestLine(headers []byte) (method, path, version string, err error) {
@@ -36,6 +35,5 @@ func parseRequestLine(headers []byte) (method, path, version string, err error)
 		return "", "", "", err
 	}
 
-	log.Printf("Method:%s | Path :%s | Version:%s", method, path, version)
 	return method, path, version, nil
 }
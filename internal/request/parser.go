@@ -1,34 +1,93 @@
 package request
 
 import (
+	"bytes"
 	"errors"
-	"log"
+	"log/slog"
 	"net"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/logger"
 )
 
-func ParseRequest(conn net.Conn, cfg *config.Config) (*Request, error) {
+type Phase uint8
 
-	conn.SetReadDeadline(time.Now().Add(cfg.ReadTimeout))
-	buffer := make([]byte, cfg.BufferLimit)
+const (
+	// PhaseHeaders starts when the first byte of a request arrives.
+	PhaseHeaders Phase = iota
+	// PhaseBody starts when the headers are parsed and more body is expected.
+	PhaseBody
+)
+
+// Parser reads successive requests off a single connection.
+type Parser struct {
+	conn   net.Conn
+	cfg    *config.Config
+	log    logger.Logger
+	buffer []byte
+
+	// pending holds bytes read past the end of the previous request, either
+	// pipelined by the client or picked up by the watcher.
+	pending []byte
+	// readErr is a read failure seen by the watcher, reported once pending
+	// runs out.
+	readErr error
+
+	watchDone chan struct{}
+	stopping  atomic.Bool
+
+	// OnPhase, when set, is called as a request moves through the phases
+	// above, letting the server report what a connection is doing.
+	OnPhase func(Phase)
+}
+
+var http2PrefaceLine = []byte("PRI * HTTP/2.0")
+
+func NewParser(conn net.Conn, cfg *config.Config, log logger.Logger) *Parser {
+	return &Parser{
+		conn:   conn,
+		cfg:    cfg,
+		log:    log,
+		buffer: make([]byte, cfg.BufferLimit),
+	}
+}
+
+func (p *Parser) enter(phase Phase) {
+	if p.OnPhase != nil {
+		p.OnPhase(phase)
+	}
+}
+
+func (p *Parser) Parse() (*Request, error) {
+	cfg, log := p.cfg, p.log
+
+	p.conn.SetReadDeadline(time.Now().Add(cfg.ReadTimeout))
 	var contentLength int
 
-	headersRaw, leftover, err := readHeaders(conn, cfg, buffer)
+	headersRaw, leftover, err := p.readHeaders()
 	if err != nil {
 		return nil, err
 	}
 
+	// the HTTP/2 preface starts out like a request line; hand it back whole
+	if bytes.Equal(headersRaw, http2PrefaceLine) {
+		preface := append(append([]byte(nil), headersRaw...), "\r\n\r\n"...)
+		p.pending = append(preface, leftover...)
+		return nil, ErrHTTP2Preface
+	}
+
 	method, path, version, err := parseRequestLine(headersRaw)
 	if err != nil {
-		log.Printf("Invalid request line: %v", err)
+		log.Debug("invalid request line", "error", err)
 		return nil, err
 	}
 
 	headerMap, err := parseHeaders(headersRaw)
 	if err != nil {
-		log.Printf("Invalid headers: %v", err)
+		log.Debug("invalid headers", "error", err)
 		return nil, err
 	}
 
@@ -46,14 +105,41 @@ func ParseRequest(conn net.Conn, cfg *config.Config) (*Request, error) {
 		return nil, errors.New("both Content-Length and Transfer-Encoding present")
 	}
 
-	//TODO: implement Chunked encoding
 	if hasTE {
-		return nil, errors.New("Transfer-Encoding: chunked not implemented yet")
+		// chunked is the only transfer coding we decode
+		if !strings.EqualFold(headerMap["transfer-encoding"], "chunked") {
+			return nil, errors.New("unsupported Transfer-Encoding: " + headerMap["transfer-encoding"])
+		}
+		body, trailers, n, err := p.readChunkedBody(leftover)
+		if err != nil {
+			log.Debug("invalid chunked body", "error", err)
+			return nil, err
+		}
+		if log.Enabled(slog.LevelDebug) {
+			log.Debug("request parsed",
+				"method", method,
+				"path", path,
+				"version", version,
+				logger.Headers("headers", headerMap),
+				"header_bytes", len(headersRaw)+4,
+				"body_bytes", len(body),
+				"chunked", true,
+			)
+		}
+		return &Request{
+			Method:    method,
+			Version:   version,
+			Path:      path,
+			Headers:   headerMap,
+			Body:      body,
+			Trailers:  trailers,
+			BytesRead: len(headersRaw) + 4 + n,
+		}, nil
 	}
 
 	contentLength, err = getContentLength(headerMap)
 	if err != nil {
-		log.Printf("parseContentLength error: %v", err)
+		log.Debug("invalid content length", "error", err)
 		return nil, err
 	}
 
@@ -61,27 +147,40 @@ func ParseRequest(conn net.Conn, cfg *config.Config) (*Request, error) {
 		return nil, ErrBodyLimitExceeded
 	}
 
+	// anything past this body belongs to the next pipelined request
+	if len(leftover) > contentLength {
+		p.pending = append(p.pending, leftover[contentLength:]...)
+		leftover = leftover[:contentLength]
+	}
+
 	body := make([]byte, len(leftover))
 	copy(body, leftover)
 
 	if need := contentLength - len(body); need > 0 {
-		more, err := readBody(conn, cfg, need, buffer)
+		more, err := p.readBody(need)
 		if err != nil {
 			return nil, err
 		}
 		body = append(body, more...)
 	}
 
-	log.Printf("Headers: %d bytes", len(headersRaw)+4)
-	log.Printf("Content Length: %d", contentLength)
-	log.Printf("Body: %d bytes", len(body))
-	log.Printf("Total Request: %d bytes", len(headersRaw)+4+len(body))
+	if log.Enabled(slog.LevelDebug) {
+		log.Debug("request parsed",
+			"method", method,
+			"path", path,
+			"version", version,
+			logger.Headers("headers", headerMap),
+			"header_bytes", len(headersRaw)+4,
+			"body_bytes", len(body),
+		)
+	}
 
 	return &Request{
-		Method:  method,
-		Version: version,
-		Path:    path,
-		Headers: headerMap,
-		Body:    body,
+		Method:    method,
+		Version:   version,
+		Path:      path,
+		Headers:   headerMap,
+		Body:      body,
+		BytesRead: len(headersRaw) + 4 + len(body),
 	}, nil
 }
@@ -0,0 +1,409 @@
+package request
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/logger"
+)
+
+func testConfig() *config.Config {
+	return config.Load(64, 32, 256, 2*time.Second, 2*time.Second)
+}
+
+// parseAll feeds raw to a parser over net.Pipe and parses requests until
+// the first error; the end of raw gives ErrConnectionClosed.
+func parseAll(t *testing.T, cfg *config.Config, raw string) ([]*Request, error) {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		io.WriteString(client, raw)
+		client.Close()
+	}()
+
+	p := NewParser(server, cfg, logger.Discard())
+	var reqs []*Request
+	for {
+		req, err := p.Parse()
+		if err != nil {
+			return reqs, err
+		}
+		reqs = append(reqs, req)
+	}
+}
+
+func TestChunkedReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		want     string
+		trailers map[string]string
+		wantErr  bool
+	}{
+		{name: "chunks", body: "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", want: "hello world"},
+		{name: "upper-case hex", body: "A\r\n0123456789\r\n0\r\n\r\n", want: "0123456789"},
+		{name: "leading zeros", body: "0005\r\nhello\r\n000\r\n\r\n", want: "hello"},
+		{name: "extensions", body: "5;name=value\r\nhello\r\n0;last\r\n\r\n", want: "hello"},
+		{name: "whitespace before an extension", body: "5 \t;x\r\nhello\r\n0\r\n\r\n", want: "hello"},
+		{name: "empty body", body: "0\r\n\r\n", want: ""},
+		{
+			name:     "trailers",
+			body:     "5\r\nhello\r\n0\r\nX-Checksum: abc\r\nX-Note: a\r\nx-note: b\r\n\r\n",
+			want:     "hello",
+			trailers: map[string]string{"x-checksum": "abc", "x-note": "a, b"},
+		},
+		{name: "plus sign", body: "+5\r\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "negative zero", body: "5\r\nhello\r\n-0\r\n\r\n", wantErr: true},
+		{name: "leading space", body: " 5\r\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "trailing space", body: "5 \r\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "hex prefix", body: "0x5\r\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "underscore", body: "0_5\r\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "empty size", body: "\r\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "size overflows int64", body: "10000000000000000\r\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "bare LF after the size", body: "5\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "bare LF after the data", body: "5\r\nhello\n0\r\n\r\n", wantErr: true},
+		{name: "bare LF ends the trailers", body: "5\r\nhello\r\n0\r\n\n", wantErr: true},
+		{name: "data longer than its size", body: "3\r\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "folded trailer", body: "0\r\nX-A: 1\r\n  2\r\n\r\n", wantErr: true},
+		{name: "malformed trailer", body: "0\r\nno colon\r\n\r\n", wantErr: true},
+		{name: "repeated framing trailer", body: "0\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n", wantErr: true},
+		{name: "cut short", body: "5\r\nhel", wantErr: true},
+		{name: "no last chunk", body: "5\r\nhello\r\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// what follows the body must be left for the next message
+			const next = "GET / HTTP/1.1\r\n"
+			br := bufio.NewReader(strings.NewReader(tt.body + next))
+			cr := NewChunkedReader(br, 256)
+			body, err := io.ReadAll(cr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("accepted %q", tt.body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("body %q, want %q", body, tt.want)
+			}
+			if len(cr.Trailers) != len(tt.trailers) {
+				t.Errorf("trailers %v, want %v", cr.Trailers, tt.trailers)
+			}
+			for k, v := range tt.trailers {
+				if cr.Trailers[k] != v {
+					t.Errorf("trailer %s: %q, want %q", k, cr.Trailers[k], v)
+				}
+			}
+			if rest, _ := io.ReadAll(br); string(rest) != next {
+				t.Errorf("left %q after the body", rest)
+			}
+		})
+	}
+}
+
+func TestChunkedReaderLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "chunk extension over the limit", body: "1;" + strings.Repeat("e", 300) + "\r\na\r\n0\r\n\r\n"},
+		{name: "trailers over the limit", body: "0\r\nX-Big: " + strings.Repeat("a", 300) + "\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := NewChunkedReader(bufio.NewReader(strings.NewReader(tt.body)), 256)
+			if _, err := io.ReadAll(cr); !errors.Is(err, ErrLineTooLong) {
+				t.Errorf("got %v, want ErrLineTooLong", err)
+			}
+		})
+	}
+}
+
+func TestParseChunked(t *testing.T) {
+	const head = "POST /upload HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n"
+	tests := []struct {
+		name     string
+		body     string
+		want     string
+		trailers map[string]string
+	}{
+		{name: "chunks", body: "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", want: "hello world"},
+		{name: "empty body", body: "0\r\n\r\n", want: ""},
+		{
+			name:     "trailers",
+			body:     "5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n",
+			want:     "hello",
+			trailers: map[string]string{"x-checksum": "abc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, err := parseAll(t, testConfig(), head+tt.body)
+			if len(reqs) != 1 {
+				t.Fatalf("parsed %d requests: %v", len(reqs), err)
+			}
+			req := reqs[0]
+			if string(req.Body) != tt.want {
+				t.Errorf("body %q, want %q", req.Body, tt.want)
+			}
+			if len(req.Trailers) != len(tt.trailers) {
+				t.Errorf("trailers %v, want %v", req.Trailers, tt.trailers)
+			}
+			for k, v := range tt.trailers {
+				if req.Trailers[k] != v {
+					t.Errorf("trailer %s: %q, want %q", k, req.Trailers[k], v)
+				}
+			}
+			if req.BytesRead != len(head)+len(tt.body) {
+				t.Errorf("BytesRead %d, want %d", req.BytesRead, len(head)+len(tt.body))
+			}
+		})
+	}
+}
+
+func TestParsePipelined(t *testing.T) {
+	raw := "POST /a HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"3\r\nabc\r\n0\r\nX-T: 1\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\n\r\nde" +
+		"GET /c HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"POST /d HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"1\r\nf\r\n0\r\n\r\n" +
+		"POST /e HTTP/1.1\r\nHost: x\r\nContent-Length: 1\r\n\r\ng"
+	reqs, err := parseAll(t, testConfig(), raw)
+	if !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("ended with %v, want ErrConnectionClosed", err)
+	}
+	want := []struct{ path, body string }{{"/a", "abc"}, {"/b", "de"}, {"/c", ""}, {"/d", "f"}, {"/e", "g"}}
+	if len(reqs) != len(want) {
+		t.Fatalf("parsed %d requests, want %d", len(reqs), len(want))
+	}
+	for i, w := range want {
+		if reqs[i].Path != w.path || string(reqs[i].Body) != w.body {
+			t.Errorf("request %d: %s %q, want %s %q", i, reqs[i].Path, reqs[i].Body, w.path, w.body)
+		}
+	}
+	if reqs[0].Trailers["x-t"] != "1" {
+		t.Errorf("trailers %v", reqs[0].Trailers)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	// testConfig allows 32 body bytes and 256 header bytes
+	const chunked = "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n"
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr error
+	}{
+		{
+			name: "body at the limit",
+			raw:  "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 32\r\n\r\n" + strings.Repeat("a", 32),
+		},
+		{
+			name:    "Content-Length over the limit",
+			raw:     "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 33\r\n\r\n" + strings.Repeat("a", 33),
+			wantErr: ErrBodyLimitExceeded,
+		},
+		{
+			name: "chunked body at the limit",
+			raw:  chunked + "10\r\n" + strings.Repeat("a", 16) + "\r\n10\r\n" + strings.Repeat("b", 16) + "\r\n0\r\n\r\n",
+		},
+		{
+			name:    "chunked body over the limit",
+			raw:     chunked + "10\r\n" + strings.Repeat("a", 16) + "\r\n11\r\n" + strings.Repeat("b", 17) + "\r\n0\r\n\r\n",
+			wantErr: ErrBodyLimitExceeded,
+		},
+		{
+			name:    "huge chunk size",
+			raw:     chunked + "7fffffffffffffff\r\n" + strings.Repeat("a", 40),
+			wantErr: ErrBodyLimitExceeded,
+		},
+		{
+			name:    "chunk extension over the limit",
+			raw:     chunked + "1;" + strings.Repeat("e", 300) + "\r\na\r\n0\r\n\r\n",
+			wantErr: ErrHeaderLimitExceeded,
+		},
+		{
+			name:    "trailers over the limit",
+			raw:     chunked + "0\r\nX-Big: " + strings.Repeat("a", 300) + "\r\n\r\n",
+			wantErr: ErrHeaderLimitExceeded,
+		},
+		{
+			name:    "headers over the limit",
+			raw:     "GET / HTTP/1.1\r\nHost: x\r\nX-Big: " + strings.Repeat("a", 256) + "\r\n\r\n",
+			wantErr: ErrHeaderLimitExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, err := parseAll(t, testConfig(), tt.raw)
+			if tt.wantErr == nil {
+				if len(reqs) != 1 {
+					t.Errorf("parsed %d requests: %v", len(reqs), err)
+				}
+				return
+			}
+			if len(reqs) != 0 || !errors.Is(err, tt.wantErr) {
+				t.Errorf("parsed %d requests, error %v, want %v", len(reqs), err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTransferEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{name: "other coding", raw: "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: gzip\r\n\r\nabc"},
+		{name: "chunked not last", raw: "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked, gzip\r\n\r\n0\r\n\r\n"},
+		{name: "with Content-Length", raw: "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+		{name: "malformed chunk", raw: "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\nz\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reqs, err := parseAll(t, testConfig(), tt.raw); len(reqs) != 0 || err == nil || errors.Is(err, ErrConnectionClosed) {
+				t.Errorf("parsed %d requests, error %v, want the body refused", len(reqs), err)
+			}
+		})
+	}
+}
+
+func TestRepeatedFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  string
+		want    map[string]string
+		cookies []string
+		wantErr bool
+	}{
+		{
+			name:   "list fields joined",
+			fields: "Accept: text/html\r\nX-A: 1\r\naccept: */*\r\n",
+			want:   map[string]string{"accept": "text/html, */*", "x-a": "1"},
+		},
+		{
+			name:    "Set-Cookie lines kept apart",
+			fields:  "Set-Cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\nSet-Cookie: b=2\r\n",
+			want:    map[string]string{"set-cookie": "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT, b=2"},
+			cookies: []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"},
+		},
+		{name: "Host twice", fields: "Host: a\r\nHost: b\r\n", wantErr: true},
+		{name: "Content-Length twice", fields: "Content-Length: 1\r\ncontent-length: 1\r\n", wantErr: true},
+		{name: "Transfer-Encoding twice", fields: "Transfer-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the request parser and the shared field reader must agree
+			headers, err := parseHeaders([]byte("GET / HTTP/1.1\r\n" + strings.TrimSuffix(tt.fields, "\r\n")))
+			lr := &LineReader{R: bufio.NewReader(strings.NewReader(tt.fields + "\r\n")), Limit: 1024}
+			fields, cookies, ferr := lr.ReadFields()
+			if tt.wantErr {
+				if err == nil || ferr == nil {
+					t.Errorf("parseHeaders: %v, ReadFields: %v, want both to fail", err, ferr)
+				}
+				return
+			}
+			if err != nil || ferr != nil {
+				t.Fatalf("parseHeaders: %v, ReadFields: %v", err, ferr)
+			}
+			for name, got := range map[string]map[string]string{"parseHeaders": headers, "ReadFields": fields} {
+				if len(got) != len(tt.want) {
+					t.Errorf("%s: %v, want %v", name, got, tt.want)
+				}
+				for k, v := range tt.want {
+					if got[k] != v {
+						t.Errorf("%s: %s %q, want %q", name, k, got[k], v)
+					}
+				}
+			}
+			if strings.Join(cookies, "|") != strings.Join(tt.cookies, "|") {
+				t.Errorf("cookies %q, want %q", cookies, tt.cookies)
+			}
+		})
+	}
+}
+
+func TestParseChunkSize(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    int64
+		wantErr bool
+	}{
+		{line: "0", want: 0},
+		{line: "1a", want: 26},
+		{line: "FF", want: 255},
+		{line: "7fffffffffffffff", want: 1<<63 - 1},
+		{line: "00000000000000000001", want: 1},
+		{line: "4;a=b;c", want: 4},
+		{line: "4\t; a = \"b\"", want: 4},
+		{line: "8000000000000000", wantErr: true},
+		{line: "ffffffffffffffffff", wantErr: true},
+		{line: "", wantErr: true},
+		{line: ";x", wantErr: true},
+		{line: "+1", wantErr: true},
+		{line: "-1", wantErr: true},
+		{line: " 1", wantErr: true},
+		{line: "1 ", wantErr: true},
+		{line: "1 2", wantErr: true},
+		{line: "g", wantErr: true},
+		{line: "1\r", wantErr: true},
+	}
+	for _, tt := range tests {
+		n, err := parseChunkSize(tt.line)
+		if (err != nil) != tt.wantErr || !tt.wantErr && n != tt.want {
+			t.Errorf("parseChunkSize(%q) = %d, %v", tt.line, n, err)
+		}
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		limit   int
+		want    []string
+		wantErr error
+	}{
+		{name: "CRLF lines", in: "a\r\nbc\r\n\r\n", limit: 100, want: []string{"a", "bc", ""}, wantErr: io.EOF},
+		{name: "bare LF", in: "a\r\nb\n", limit: 100, want: []string{"a"}},
+		{name: "CR alone is no line ending", in: "a\rb\r\n", limit: 100, want: []string{"a\rb"}, wantErr: io.EOF},
+		{name: "cut short", in: "a\r\nb", limit: 100, want: []string{"a"}, wantErr: io.ErrUnexpectedEOF},
+		{name: "over the limit", in: "abc\r\ndefg\r\n", limit: 8, want: []string{"abc"}, wantErr: ErrLineTooLong},
+		{name: "longer than the buffer", in: strings.Repeat("x", 40) + "\r\n", limit: 100, want: []string{strings.Repeat("x", 40)}, wantErr: io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lr := &LineReader{R: bufio.NewReaderSize(strings.NewReader(tt.in), 16), Limit: tt.limit}
+			var got []string
+			var err error
+			for {
+				var line string
+				if line, err = lr.ReadLine(); err != nil {
+					break
+				}
+				got = append(got, line)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("lines %q, want %q", got, tt.want)
+			}
+			if tt.wantErr == nil {
+				if err == nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Errorf("error %v, want a malformed line", err)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
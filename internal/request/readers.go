@@ -1,81 +1,142 @@
 package request
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"io"
-	"log"
 	"net"
-
-	"github.com/brutally-Honest/http-server/internal/config"
+	"syscall"
 )
 
 func safeRead(conn net.Conn, buffer []byte) (int, error) {
 	n, err := conn.Read(buffer)
 	if err != nil {
-		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
-			log.Printf("safe read: connection closed by client")
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNRESET) {
 			return n, ErrConnectionClosed
 		}
 
-		log.Printf("read error: %v", err)
 		return n, err
 	}
 	return n, nil
 }
 
-// read until \r\n\r\n is found
-func readHeaders(conn net.Conn, cfg *config.Config, buffer []byte) ([]byte, []byte, error) {
-	headers := make([]byte, 0, cfg.HeaderLimit)
+// read until \r\n\r\n is found, starting with any pending bytes
+func (p *Parser) readHeaders() ([]byte, []byte, error) {
+	headers := make([]byte, 0, p.cfg.HeaderLimit)
+	headers = append(headers, p.pending...)
+	p.pending = nil
+
+	if len(headers) > 0 {
+		p.enter(PhaseHeaders)
+	}
+
 	for {
-		streamLength, err := safeRead(conn, buffer)
-		if err != nil {
-			return nil, nil, err
+		if idx := bytes.Index(headers, []byte("\r\n\r\n")); idx != -1 {
+			if idx+4 > p.cfg.HeaderLimit {
+				return nil, nil, ErrHeaderLimitExceeded
+			}
+			headerEnd := idx + 4
+			return headers[:idx], headers[headerEnd:], nil
 		}
 
-		if len(headers)+streamLength > cfg.HeaderLimit {
-			log.Printf("header limit exceeded")
+		if len(headers) > p.cfg.HeaderLimit {
 			return nil, nil, ErrHeaderLimitExceeded
 		}
 
-		headers = append(headers, buffer[:streamLength]...)
+		if p.readErr != nil {
+			err := p.readErr
+			p.readErr = nil
+			return nil, nil, err
+		}
 
-		if idx := bytes.Index(headers, []byte("\r\n\r\n")); idx != -1 {
-			headerEnd := idx + 4
-			return headers[:idx], headers[headerEnd:], nil
+		streamLength, err := safeRead(p.conn, p.buffer)
+		if err != nil {
+			return nil, nil, err
 		}
+
+		if len(headers) == 0 && streamLength > 0 {
+			p.enter(PhaseHeaders)
+		}
+
+		headers = append(headers, p.buffer[:streamLength]...)
 	}
 }
 
 // read based on Content-Length
-func readBody(conn net.Conn, cfg *config.Config, contentLength int, buffer []byte) ([]byte, error) {
+func (p *Parser) readBody(contentLength int) ([]byte, error) {
 	if contentLength == 0 {
 		return nil, nil
 	}
 
-	if contentLength > cfg.BodyLimit {
+	if contentLength > p.cfg.BodyLimit {
 		return nil, ErrBodyLimitExceeded
 	}
 
+	p.enter(PhaseBody)
 	body := make([]byte, 0, contentLength)
 
 	for len(body) < contentLength {
-		n, err := safeRead(conn, buffer)
+		n, err := safeRead(p.conn, p.buffer)
 		if err != nil {
 			return nil, err
 		}
 
-		if len(body)+n > cfg.BodyLimit {
-			return nil, ErrBodyLimitExceeded
-		}
-
+		// bytes past the body are not counted against BodyLimit: they
+		// belong to the next pipelined request
 		remaining := contentLength - len(body)
 		if n > remaining {
+			p.pending = append(p.pending, p.buffer[remaining:n]...)
 			n = remaining
 		}
 
-		body = append(body, buffer[:n]...)
+		body = append(body, p.buffer[:n]...)
 	}
 
 	return body, nil
 }
+
+// readChunkedBody decodes a chunked body that starts with leftover and
+// continues on the connection. Bytes past its end are kept for the next
+// Parse; n counts the bytes the body took on the wire.
+func (p *Parser) readChunkedBody(leftover []byte) (body []byte, trailers map[string]string, n int, err error) {
+	p.enter(PhaseBody)
+
+	src := &connReader{p: p}
+	br := bufio.NewReaderSize(io.MultiReader(bytes.NewReader(leftover), src), len(p.buffer))
+	cr := NewChunkedReader(br, p.cfg.HeaderLimit)
+
+	// one byte over the limit tells a body that is too big from one that
+	// fits exactly
+	body, err = io.ReadAll(io.LimitReader(cr, int64(p.cfg.BodyLimit)+1))
+	if errors.Is(err, ErrLineTooLong) {
+		return nil, nil, 0, ErrHeaderLimitExceeded
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if len(body) > p.cfg.BodyLimit {
+		return nil, nil, 0, ErrBodyLimitExceeded
+	}
+
+	rest, _ := br.Peek(br.Buffered())
+	p.pending = append(p.pending, rest...)
+	return body, cr.Trailers, len(leftover) + src.n - len(rest), nil
+}
+
+// connReader reads the connection through safeRead, reporting a failure
+// the watcher saw first.
+type connReader struct {
+	p *Parser
+	n int
+}
+
+func (c *connReader) Read(b []byte) (int, error) {
+	if err := c.p.readErr; err != nil {
+		c.p.readErr = nil
+		return 0, err
+	}
+	n, err := safeRead(c.p.conn, b)
+	c.n += n
+	return n, err
+}
@@ -1,6 +1,13 @@
 package request
 
-import "context"
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/proxyproto"
+)
 
 type Request struct {
 	Method  string
@@ -10,4 +17,27 @@ type Request struct {
 	Body    []byte
 	Params  map[string]string
 	Context context.Context
+	Logger  logger.Logger
+
+	// Route is the registered pattern that matched, e.g. /api/param/:id.
+	Route string
+	// BytesRead counts the request line, headers and body read off the wire.
+	BytesRead int
+	// Trailers holds the trailer fields of a chunked body, nil otherwise.
+	Trailers map[string]string
+	// TLS is the handshake state of the connection, nil in cleartext.
+	TLS *tls.ConnectionState
+	// RemoteAddr is the ip:port of the peer: the client, or the nearest
+	// proxy in front of the server. RealIP looks past trusted proxies.
+	RemoteAddr string
+	// TrustedProxies are the networks whose forwarding headers RealIP,
+	// Scheme and Host believe, and TrustForwarded picks Forwarded over
+	// X-Forwarded-* as the header to read; the server sets both from its
+	// config.
+	TrustedProxies []*net.IPNet
+	TrustForwarded bool
+	// ProxyHeader holds the client and destination addresses a load
+	// balancer sent ahead of the connection with the PROXY protocol, nil
+	// without one.
+	ProxyHeader *proxyproto.Header
 }
@@ -0,0 +1,69 @@
+package request
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// aLongTimeAgo is a read deadline that makes a blocked Read return at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Watch reads from the connection in the background while a handler runs,
+// so a client that disconnects mid-request is noticed: onClose is called on
+// EOF or reset. Bytes that arrive instead (a pipelined request) are kept
+// for the next Parse. Every Watch must be paired with StopWatch before the
+// connection is parsed or handed over again.
+func (p *Parser) Watch(onClose func()) {
+	if p.readErr != nil {
+		onClose() // already gone
+		return
+	}
+
+	done := make(chan struct{})
+	p.watchDone = done
+	p.conn.SetReadDeadline(time.Time{})
+
+	go func() {
+		defer close(done)
+
+		n, err := safeRead(p.conn, p.buffer)
+		if n > 0 {
+			// the client is alive; leave further bytes to the next Parse
+			p.pending = append(p.pending, p.buffer[:n]...)
+		}
+		if err == nil {
+			return
+		}
+
+		var ne net.Error
+		if p.stopping.Load() && errors.As(err, &ne) && ne.Timeout() {
+			return // unblocked by StopWatch
+		}
+		p.readErr = err
+		onClose()
+	}()
+}
+
+// StopWatch stops the background read and waits for it to finish.
+func (p *Parser) StopWatch() {
+	if p.watchDone == nil {
+		return
+	}
+	p.stopping.Store(true)
+	p.conn.SetReadDeadline(aLongTimeAgo)
+	<-p.watchDone
+
+	p.watchDone = nil
+	p.stopping.Store(false)
+	p.conn.SetReadDeadline(time.Time{})
+}
+
+// Buffered returns the bytes read past the end of the last request and
+// forgets them; a caller taking over the connection must consume them
+// before reading from it.
+func (p *Parser) Buffered() []byte {
+	b := p.pending
+	p.pending = nil
+	return b
+}
@@ -0,0 +1,105 @@
+package request
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/logger"
+)
+
+// watchedParser returns a parser over a pipe that has parsed one request,
+// and the client end of the pipe.
+func watchedParser(t *testing.T) (*Parser, net.Conn) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	go io.WriteString(client, "GET /first HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	p := NewParser(server, testConfig(), logger.Discard())
+	if _, err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	return p, client
+}
+
+func waitClosed(closed <-chan struct{}) bool {
+	select {
+	case <-closed:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+func TestWatchDisconnect(t *testing.T) {
+	p, client := watchedParser(t)
+	closed := make(chan struct{})
+	p.Watch(func() { close(closed) })
+
+	client.Close()
+	if !waitClosed(closed) {
+		t.Fatal("disconnect not noticed")
+	}
+	p.StopWatch()
+	if _, err := p.Parse(); err == nil {
+		t.Error("Parse succeeded on a closed connection")
+	}
+
+	// a later Watch reports the disconnect at once
+	again := make(chan struct{})
+	p.Watch(func() { close(again) })
+	if !waitClosed(again) {
+		t.Error("second Watch did not report the disconnect")
+	}
+}
+
+func TestWatchPipelined(t *testing.T) {
+	p, client := watchedParser(t)
+	closed := make(chan struct{})
+	p.Watch(func() { close(closed) })
+
+	// the pipe hands the bytes straight to the watcher
+	io.WriteString(client, "GET /second HTTP/1.1\r\nHost: x\r\n\r\n")
+	p.StopWatch()
+
+	req, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Path != "/second" {
+		t.Errorf("parsed %q", req.Path)
+	}
+	select {
+	case <-closed:
+		t.Error("onClose called for a live client")
+	default:
+	}
+}
+
+func TestStopWatch(t *testing.T) {
+	p, client := watchedParser(t)
+	closed := make(chan struct{})
+	p.Watch(func() { close(closed) })
+	p.StopWatch()
+	p.StopWatch() // without a Watch it does nothing
+
+	select {
+	case <-closed:
+		t.Fatal("onClose called by StopWatch")
+	default:
+	}
+
+	// the read deadline is cleared for the next request
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(client, "GET /next HTTP/1.1\r\nHost: x\r\n\r\n")
+	}()
+	if req, err := p.Parse(); err != nil || req.Path != "/next" {
+		t.Errorf("Parse after StopWatch: %v", err)
+	}
+}
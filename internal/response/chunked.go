@@ -6,7 +6,13 @@ import (
 	"time"
 )
 
-func (r *Response) WriteChunk(data []byte) (err error) {
+func (r *Response) WriteChunk(data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writeChunk(data)
+}
+
+func (r *Response) writeChunk(data []byte) (err error) {
 	defer func() {
 		if err != nil {
 			r.writeErr = err
@@ -17,16 +23,45 @@ func (r *Response) WriteChunk(data []byte) (err error) {
 		return errors.New("chunked encoding is not enabled")
 	}
 
+	if err = r.released(); err != nil {
+		return err
+	}
+
+	if r.finished {
+		return ErrResponseFinished
+	}
+
 	if err = r.checkCancel(); err != nil {
 		return err
 	}
 
-	if !r.headerWritten {
+	if !r.headersSent {
 		// write headers before first chunk
-		if err = r.writeHeaders(); err != nil {
+		if err = r.startStream(); err != nil {
 			return err
 		}
-		r.headerWritten = true
+	}
+
+	if r.encoder != nil {
+		if data, err = r.encodeChunk(data, false); err != nil {
+			return err
+		}
+	}
+
+	// a zero-size chunk would terminate the stream
+	if len(data) == 0 || r.isHead() {
+		return nil
+	}
+
+	return r.writeChunkFrame(data)
+}
+
+func (r *Response) writeChunkFrame(data []byte) error {
+	if r.stream != nil {
+		// the stream frames the data itself
+		n, err := r.writeBody(data)
+		r.bytesWritten += int64(n)
+		return err
 	}
 
 	// write deadline before writing
@@ -35,38 +70,66 @@ func (r *Response) WriteChunk(data []byte) (err error) {
 	// chunk size in hex
 	size := fmt.Sprintf("%x\r\n", len(data))
 
-	if err = safeWriteString(r.Conn, size); err != nil {
+	if err := safeWriteString(r.Conn, size); err != nil {
 		return err
 	}
 
-	if _, err = safeWrite(r.Conn, data); err != nil {
+	n, err := safeWrite(r.Conn, data)
+	r.bytesWritten += int64(n)
+	if err != nil {
 		return err
 	}
 
-	if err = safeWriteString(r.Conn, "\r\n"); err != nil {
+	if err := safeWriteString(r.Conn, "\r\n"); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (r *Response) EndChunked() (err error) {
-	defer func() {
-		if err != nil {
-			r.writeErr = err
-		}
-	}()
-
+// EndChunked terminates a chunked response; it is equivalent to Finish.
+func (r *Response) EndChunked() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if !r.chunked {
 		return errors.New("not chunked response")
 	}
+	return r.finish()
+}
 
+func (r *Response) endChunked() (err error) {
 	if err = r.checkCancel(); err != nil {
 		return err
 	}
 
+	if !r.headersSent {
+		if err = r.startStream(); err != nil {
+			return err
+		}
+	}
+
+	if r.isHead() {
+		return nil
+	}
+
+	if r.encoder != nil {
+		var tail []byte
+		if tail, err = r.encodeChunk(nil, true); err != nil {
+			return err
+		}
+		if len(tail) > 0 {
+			if err = r.writeChunkFrame(tail); err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.stream != nil {
+		return nil // trailers go out when the stream ends
+	}
+
 	// write deadline before writing
 	r.Conn.SetWriteDeadline(time.Now().Add(r.Cfg.WriteTimeout))
 
-	return safeWriteString(r.Conn, "0\r\n\r\n")
+	return safeWriteString(r.Conn, r.lastChunk())
 }
@@ -0,0 +1,157 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Encoder compresses a response body. Flush must push out everything
+// written so far so that streamed chunks reach the client promptly.
+type Encoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// Encoding is a negotiated Content-Encoding applied while the response is
+// written, both to buffered Flush bodies and to WriteChunk streams.
+type Encoding struct {
+	Name      string
+	NewWriter func(w io.Writer) Encoder
+	// MinSize skips encoding buffered bodies shorter than this many bytes.
+	MinSize int
+	// Encodable, when set, filters on the response Content-Type.
+	Encodable func(contentType string) bool
+}
+
+// SetEncoding asks the response to encode its body with enc. A nil enc
+// still records that the body was negotiated on Accept-Encoding, so caches
+// get the right Vary header.
+func (r *Response) SetEncoding(enc *Encoding) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.headerWritten {
+		return errors.New("headers already written")
+	}
+	r.encoding = enc
+	r.varyEncoding = true
+	return nil
+}
+
+// wantsEncoding reports whether a body of size bytes (-1 when streaming)
+// should go through r.encoding.
+func (r *Response) wantsEncoding(size int) bool {
+	if r.encoding == nil {
+		return false
+	}
+	if size == 0 || (size > 0 && size < r.encoding.MinSize) {
+		return false
+	}
+	if r.StatusCode == 206 || noBodyStatus(r.StatusCode) {
+		return false // Content-Range offsets refer to the unencoded bytes
+	}
+	if r.getHeader("Content-Encoding") != "" {
+		return false // handler already encoded the body
+	}
+	if r.encoding.Encodable != nil && !r.encoding.Encodable(r.getHeader("Content-Type")) {
+		return false
+	}
+	return true
+}
+
+// encodeBody compresses the buffered body in place before Flush writes it.
+func (r *Response) encodeBody() error {
+	if !r.wantsEncoding(len(r.Body)) {
+		return nil
+	}
+	if r.hasContentLength && len(r.Body) != r.contentLength {
+		return errors.New("actual body size does not match Content-Length")
+	}
+
+	var buf bytes.Buffer
+	w := r.encoding.NewWriter(&buf)
+	if _, err := w.Write(r.Body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	r.Body = buf.Bytes()
+	r.setHeaderValue("Content-Encoding", r.encoding.Name)
+	if r.hasContentLength {
+		r.contentLength = len(r.Body)
+		r.setHeaderValue("Content-Length", strconv.Itoa(len(r.Body)))
+	}
+	return nil
+}
+
+// startStreamEncoding attaches an encoder before the first chunk goes out.
+func (r *Response) startStreamEncoding() {
+	if !r.wantsEncoding(-1) {
+		return
+	}
+	r.encoder = r.encoding.NewWriter(&r.encodeBuf)
+	r.setHeaderValue("Content-Encoding", r.encoding.Name)
+	r.delHeader("Content-Length")
+	r.hasContentLength = false
+}
+
+// encodeChunk runs data through the stream encoder and returns the bytes
+// ready to be framed; last closes the encoder to emit its trailer.
+func (r *Response) encodeChunk(data []byte, last bool) ([]byte, error) {
+	if _, err := r.encoder.Write(data); err != nil {
+		return nil, err
+	}
+	var err error
+	if last {
+		err = r.encoder.Close()
+	} else {
+		err = r.encoder.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), r.encodeBuf.Bytes()...)
+	r.encodeBuf.Reset()
+	return out, nil
+}
+
+func (r *Response) addVary(field string) {
+	current := r.getHeader("Vary")
+	for _, v := range strings.Split(current, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), field) {
+			return
+		}
+	}
+	if current != "" {
+		field = current + ", " + field
+	}
+	r.setHeaderValue("Vary", field)
+}
+
+func (r *Response) getHeader(k string) string {
+	for key, v := range r.Headers {
+		if strings.EqualFold(key, k) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (r *Response) delHeader(k string) {
+	for key := range r.Headers {
+		if strings.EqualFold(key, k) {
+			delete(r.Headers, key)
+		}
+	}
+}
+
+// setHeaderValue replaces any casing of k, bypassing SetHeader's checks.
+func (r *Response) setHeaderValue(k, v string) {
+	r.delHeader(k)
+	r.Headers[k] = v
+}
@@ -4,4 +4,9 @@ import "errors"
 
 var (
 	ErrConnectionClosed = errors.New("connection closed by client")
+	ErrResponseFinished = errors.New("response already finished")
+	ErrHandlerTimeout   = errors.New("handler timed out")
+	ErrHijacked         = errors.New("connection has been hijacked")
+	ErrNotHijackable    = errors.New("connection cannot be hijacked")
+	ErrAborted          = errors.New("response aborted")
 )
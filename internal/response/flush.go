@@ -2,45 +2,84 @@ package response
 
 import (
 	"errors"
-	"time"
 
 	"github.com/brutally-Honest/http-server/internal/request"
 )
 
-func (r *Response) Write(b []byte) (err error) {
-	defer func() {
-		if err != nil {
-			r.writeErr = err
-		}
-	}()
-
-	if r.chunked {
-		return errors.New("cannot use Write() when chunked encoding is enabled; use WriteChunk()")
+// Flush completes the response now; see Finish. serverWantsClose asks for
+// the connection to be closed afterwards.
+func (r *Response) Flush(req *request.Request, serverWantsClose bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req != nil {
+		r.req = req
 	}
-
-	if r.hasContentLength && len(r.Body)+len(b) > r.contentLength {
-		return errors.New("body exceeds Content-Length")
+	if serverWantsClose {
+		r.closeAfter = true
 	}
+	return r.finish()
+}
 
-	if !r.headerWritten {
-		r.headerWritten = true
+// Finish sends whatever is still pending: the buffered body, or the end of
+// a streamed one. It is safe to call more than once, and the server calls
+// it after every handler, so handlers only need it to end a response early.
+func (r *Response) Finish() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finish()
+}
+
+// Abort gives up on the response, for a body that cannot be produced in
+// full. Nothing more is sent: HTTP/1 closes the connection and HTTP/2
+// resets the stream, so a client already receiving the body sees it cut
+// short instead of complete. err is reported by Err; nil means ErrAborted.
+func (r *Response) Abort(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished || r.released() != nil {
+		return
+	}
+	if err == nil {
+		err = ErrAborted
 	}
 
-	r.Body = append(r.Body, b...)
-	return nil
+	r.stopTimer()
+	r.finished = true
+	r.closeAfter = true
+	r.writeErr = err
+	r.Body = nil
+	if r.stream != nil {
+		r.stream.Reset()
+	} else {
+		r.Conn.Close()
+	}
 }
 
-func (r *Response) Flush(req *request.Request, serverWantsClose bool) (err error) {
+func (r *Response) finish() (err error) {
 	defer func() {
 		if err != nil {
 			r.writeErr = err
 		}
 	}()
 
-	if r.chunked {
-		return errors.New("use WriteChunk + EndChunked for streaming responses")
+	if r.finished {
+		return nil
+	}
+	r.finished = true
+	r.stopTimer()
+
+	switch {
+	case r.chunked:
+		err = r.endChunked()
+	case r.streaming:
+		err = r.endStream()
+	default:
+		err = r.flushBuffered()
 	}
+	return r.closeStream(err)
+}
 
+func (r *Response) flushBuffered() (err error) {
 	if err = r.checkCancel(); err != nil {
 		return err
 	}
@@ -49,25 +88,32 @@ func (r *Response) Flush(req *request.Request, serverWantsClose bool) (err error
 		r.headerWritten = true
 	}
 
-	connHeader := determineConnectionHeader(req, serverWantsClose)
-	r.Headers["Connection"] = connHeader
-
-	if err = r.writeHeaders(); err != nil {
+	if err = r.encodeBody(); err != nil {
 		return err
 	}
 
-	if r.hasContentLength && len(r.Body) != r.contentLength {
+	// HEAD answers carry the GET headers, and 1xx/204/304 never have a body
+	bodyless := r.isHead() || noBodyStatus(r.StatusCode)
+
+	if !bodyless && r.hasContentLength && len(r.Body) != r.contentLength {
 		return errors.New("actual body size does not match Content-Length")
 	}
 
-	if err = r.checkCancel(); err != nil {
+	if err = r.sendHeaders(); err != nil {
 		return err
 	}
 
-	// write deadline before writing
-	r.Conn.SetWriteDeadline(time.Now().Add(r.Cfg.WriteTimeout))
+	if bodyless || len(r.Body) == 0 {
+		return nil
+	}
+
+	if err = r.checkCancel(); err != nil {
+		return err
+	}
 
-	if _, err = safeWrite(r.Conn, r.Body); err != nil {
+	n, err := r.writeBody(r.Body)
+	r.bytesWritten += int64(n)
+	if err != nil {
 		return err
 	}
 	return nil
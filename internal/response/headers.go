@@ -2,12 +2,39 @@ package response
 
 import (
 	"errors"
-	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func (r *Response) SetHeader(k, v string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.setHeader(k, v)
+}
+
+// AddHeader adds v to the values of k. Values are joined into one field
+// with a comma, as RFC 9110 section 5.3 allows, apart from Set-Cookie: a
+// cookie can hold commas itself, so each one goes out on a line of its
+// own.
+func (r *Response) AddHeader(k, v string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.headerWritten {
+		return errors.New("headers already written")
+	}
+	if strings.EqualFold(k, "set-cookie") {
+		r.cookies = append(r.cookies, v)
+		return nil
+	}
+	if prev := r.getHeader(k); prev != "" {
+		r.delHeader(k)
+		v = prev + ", " + v
+	}
+	return r.setHeader(k, v)
+}
+
+func (r *Response) setHeader(k, v string) error {
 	if r.headerWritten {
 		return errors.New("headers already written")
 	}
@@ -25,11 +52,23 @@ func (r *Response) SetHeader(k, v string) error {
 		r.chunked = true
 	}
 
+	if strings.ToLower(k) == "trailer" {
+		if err := r.declareTrailers(v); err != nil {
+			return err
+		}
+		// trailers only exist in chunked framing
+		if r.req == nil || r.req.Version != "HTTP/1.0" {
+			r.chunked = true
+		}
+	}
+
 	r.Headers[k] = v
 	return nil
 }
 
 func (r *Response) WriteHeader(code int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if r.headerWritten {
 		return errors.New("WriteHeader called twice")
 	}
@@ -38,33 +77,76 @@ func (r *Response) WriteHeader(code int) error {
 	return nil
 }
 
-func (r *Response) writeHeaders() error {
-	statusText := getStatusText(r.StatusCode)
+// sendHeaders writes the status line and header block in one go.
+func (r *Response) sendHeaders() error {
+	statusText := StatusText(r.StatusCode)
 	if statusText == "Unknown" {
-		return errors.New("invalid status code")
+		if r.StatusCode < 100 || r.StatusCode > 599 {
+			return errors.New("invalid status code")
+		}
+		statusText = "" // the reason phrase is optional (RFC 9112 section 4)
 	}
-	if _, err := fmt.Fprintf(r.Conn, "HTTP/1.1 %d %s\r\n", r.StatusCode, statusText); err != nil {
-		return err
+
+	if r.stream == nil && r.closeSignal != nil {
+		select {
+		case <-r.closeSignal:
+			r.closeAfter = true
+		default:
+		}
+	}
+	if r.stream != nil {
+		// connection management is per connection, not per stream
+	} else if r.closeAfter {
+		r.setHeaderValue("Connection", "close")
+	} else if r.getHeader("Connection") == "" {
+		r.Headers["Connection"] = determineConnectionHeader(r.req, false)
 	}
 
-	if !r.chunked && !r.hasContentLength {
+	if !r.chunked && !r.streaming && !r.hasContentLength && !noBodyStatus(r.StatusCode) {
 		r.Headers["Content-Length"] = strconv.Itoa(len(r.Body))
 	}
 
-	if r.chunked {
+	if r.chunked && r.stream == nil {
 		r.Headers["Transfer-Encoding"] = "chunked"
 	}
 
-	for k, v := range r.Headers {
-		header := fmt.Sprintf("%s: %s\r\n", k, v)
-		if err := safeWriteString(r.Conn, header); err != nil {
+	if r.varyEncoding {
+		r.addVary("Accept-Encoding")
+	}
+
+	if r.stream != nil {
+		if err := r.stream.WriteHeaders(r.StatusCode, r.Headers, r.cookies); err != nil {
 			return err
 		}
+		r.headersSent = true
+		return nil
 	}
 
-	if err := safeWriteString(r.Conn, "\r\n"); err != nil {
-		return err
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 ")
+	b.WriteString(strconv.Itoa(r.StatusCode))
+	b.WriteByte(' ')
+	b.WriteString(statusText)
+	b.WriteString("\r\n")
+	for k, v := range r.Headers {
+		b.WriteString(k)
+		b.WriteString(": ")
+		b.WriteString(v)
+		b.WriteString("\r\n")
+	}
+	for _, c := range r.cookies {
+		b.WriteString("Set-Cookie: ")
+		b.WriteString(c)
+		b.WriteString("\r\n")
 	}
+	b.WriteString("\r\n")
 
+	// write deadline before writing
+	r.Conn.SetWriteDeadline(time.Now().Add(r.Cfg.WriteTimeout))
+
+	if err := safeWriteString(r.Conn, b.String()); err != nil {
+		return err
+	}
+	r.headersSent = true
 	return nil
 }
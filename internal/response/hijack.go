@@ -0,0 +1,78 @@
+package response
+
+import (
+	"net"
+	"time"
+)
+
+// SetHijacker installs the function Hijack uses to take the connection away
+// from the server; the server sets it on every response it hands to a
+// handler.
+func (r *Response) SetHijacker(fn func() (net.Conn, []byte, error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hijack = fn
+}
+
+// Hijack hands the raw connection to the handler, along with any bytes the
+// server already read from it past the current request. From then on the
+// server neither reads nor writes the connection and the handler must close
+// it. Anything written through the response but not sent yet is dropped,
+// so call Finish first to keep it; later writes fail with ErrHijacked.
+// Once the handler has returned, Hijack fails with ErrResponseFinished.
+func (r *Response) Hijack() (net.Conn, []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.released(); err != nil {
+		return nil, nil, err
+	}
+	if r.returned {
+		return nil, nil, ErrResponseFinished
+	}
+	if r.hijack == nil {
+		return nil, nil, ErrNotHijackable
+	}
+
+	conn, buffered, err := r.hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.stopTimer()
+	r.hijacked = true
+	r.finished = true
+	r.Body = nil
+	conn.SetDeadline(time.Time{})
+	return conn, buffered, nil
+}
+
+// HandlerReturned tells the response its handler is done, so a goroutine
+// the handler left behind can no longer take the connection while the
+// server reuses it. The server calls it after every handler.
+func (r *Response) HandlerReturned() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.returned = true
+}
+
+// SetCloseSignal makes the response ask for the connection to be closed if
+// done is closed before its headers go out. The server passes its shutdown
+// channel, so clients stop reusing a connection it is about to close.
+func (r *Response) SetCloseSignal(done <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeSignal = done
+}
+
+// released reports why the response may no longer be written, if it was
+// taken over by a timeout or a hijack.
+func (r *Response) released() error {
+	switch {
+	case r.hijacked:
+		return ErrHijacked
+	case r.timedOut:
+		return ErrHandlerTimeout
+	}
+	return nil
+}
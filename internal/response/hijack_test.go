@@ -0,0 +1,100 @@
+package response
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// hijackable lets res hand out its own connection along with buffered.
+func hijackable(res *testResponse, buffered string) {
+	res.SetHijacker(func() (net.Conn, []byte, error) {
+		return res.conn, []byte(buffered), nil
+	})
+}
+
+func TestHijack(t *testing.T) {
+	res := newTestResponse(t, "GET", "HTTP/1.1", 0)
+	hijackable(res, "next request")
+	res.WriteString("dropped")
+
+	conn, buffered, err := res.Hijack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buffered) != "next request" {
+		t.Errorf("buffered %q", buffered)
+	}
+	if _, err := res.WriteString("more"); err != ErrHijacked {
+		t.Errorf("Write after Hijack: %v", err)
+	}
+	if err := res.Finish(); err != nil {
+		t.Errorf("Finish after Hijack: %v", err)
+	}
+	if _, _, err := res.Hijack(); err != ErrHijacked {
+		t.Errorf("second Hijack: %v", err)
+	}
+
+	conn.Write([]byte("raw bytes"))
+	if got := res.wire(t); got != "raw bytes" {
+		t.Errorf("connection carried %q", got)
+	}
+}
+
+func TestHijackStopsTimeout(t *testing.T) {
+	res := newTestResponse(t, "GET", "HTTP/1.1", 0)
+	hijackable(res, "")
+	fired := make(chan struct{})
+	res.OnTimeout(func() { close(fired) })
+	res.SetTimeout(20*time.Millisecond, 0, nil)
+
+	conn, _, err := res.Hijack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fired:
+		t.Fatal("the deadline fired on a hijacked connection")
+	case <-time.After(60 * time.Millisecond):
+	}
+
+	// the connection is still open for the handler
+	if _, err := conn.Write([]byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+	if got := res.wire(t); got != "still here" {
+		t.Errorf("connection carried %q", got)
+	}
+}
+
+func TestHijackRefused(t *testing.T) {
+	hijackErr := errors.New("already upgraded")
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, res *testResponse)
+		want    error
+	}{
+		{name: "no hijacker", prepare: func(t *testing.T, res *testResponse) {}, want: ErrNotHijackable},
+		{name: "handler returned", prepare: func(t *testing.T, res *testResponse) {
+			hijackable(res, "")
+			res.HandlerReturned()
+		}, want: ErrResponseFinished},
+		{name: "timed out", prepare: func(t *testing.T, res *testResponse) {
+			hijackable(res, "")
+			expired(t, res)
+		}, want: ErrHandlerTimeout},
+		{name: "hijacker fails", prepare: func(t *testing.T, res *testResponse) {
+			res.SetHijacker(func() (net.Conn, []byte, error) { return nil, nil, hijackErr })
+		}, want: hijackErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newTestResponse(t, "GET", "HTTP/1.1", 0)
+			tt.prepare(t, res)
+			if _, _, err := res.Hijack(); err != tt.want {
+				t.Errorf("Hijack: %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
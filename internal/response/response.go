@@ -1,19 +1,33 @@
 package response
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/brutally-Honest/http-server/internal/config"
 	"github.com/brutally-Honest/http-server/internal/request"
 )
 
+// DefaultResponseBufferLimit is how much body Write buffers before the
+// response switches to streaming when Config.ResponseBufferLimit is unset.
+const DefaultResponseBufferLimit = 64 * 1024
+
 type Response struct {
-	StatusCode    int
-	Headers       map[string]string
+	// mu serialises writers: the handler and, once its deadline passes, the
+	// timeout that answers in its place.
+	mu sync.Mutex
+
+	StatusCode int
+	Headers    map[string]string
+	// cookies are the Set-Cookie values added with AddHeader, each sent
+	// on a line of its own
+	cookies       []string
 	Body          []byte
 	headerWritten bool
 	chunked       bool
@@ -23,16 +37,53 @@ type Response struct {
 	hasContentLength bool
 	contentLength    int
 
+	req     *request.Request
 	connCtx context.Context
 	reqCtx  context.Context
 
-	writeErr error
+	// headersSent is set once the status line and headers are on the wire.
+	// streaming means the body goes out as it is written instead of being
+	// buffered until Finish; closeDelimited streams end by closing the
+	// connection, which is how HTTP/1.0 clients see an unknown length.
+	headersSent    bool
+	streaming      bool
+	closeDelimited bool
+	finished       bool
+	closeAfter     bool
+
+	writeErr     error
+	bytesWritten int64
+
+	declaredTrailers map[string]bool
+	trailers         map[string]string
+
+	encoding     *Encoding
+	varyEncoding bool
+	encoder      Encoder
+	encodeBuf    bytes.Buffer
+
+	timer     *time.Timer
+	timerSeq  uint64
+	timedOut  bool
+	onTimeout func()
+
+	hijack   func() (net.Conn, []byte, error)
+	hijacked bool
+	// returned is set once the handler is done with the response
+	returned bool
+	// closeSignal, once closed, makes the response end the connection
+	closeSignal <-chan struct{}
+
+	stream Stream
 }
 
-func NewResponseWithContext(code int, connCtx, reqCtx context.Context, conn net.Conn, cfg *config.Config) *Response {
+// NewResponseWithContext creates the response for req, which may be nil when
+// answering a request that could not be parsed.
+func NewResponseWithContext(code int, req *request.Request, connCtx, reqCtx context.Context, conn net.Conn, cfg *config.Config) *Response {
 	return &Response{
 		StatusCode: code,
 		Headers:    map[string]string{},
+		req:        req,
 		connCtx:    connCtx,
 		reqCtx:     reqCtx,
 		Conn:       conn,
@@ -76,19 +127,66 @@ func determineConnectionHeader(req *request.Request, serverWantsClose bool) stri
 	return "keep-alive"
 }
 
-func getStatusText(code int) string {
+func noBodyStatus(code int) bool {
+	return (code >= 100 && code < 200) || code == 204 || code == 304
+}
+
+// StatusText returns the reason phrase for code, or "Unknown".
+func StatusText(code int) string {
 	statusTexts := map[int]string{
+		100: "Continue",
+		101: "Switching Protocols",
+		103: "Early Hints",
+
 		200: "OK",
 		201: "Created",
+		202: "Accepted",
+		203: "Non-Authoritative Information",
 		204: "No Content",
+		205: "Reset Content",
+		206: "Partial Content",
+
+		300: "Multiple Choices",
+		301: "Moved Permanently",
+		302: "Found",
+		303: "See Other",
+		304: "Not Modified",
+		307: "Temporary Redirect",
+		308: "Permanent Redirect",
 
 		400: "Bad Request",
+		401: "Unauthorized",
+		402: "Payment Required",
+		403: "Forbidden",
 		404: "Not Found",
 		405: "Method Not Allowed",
+		406: "Not Acceptable",
+		407: "Proxy Authentication Required",
 		408: "Request Timeout",
+		409: "Conflict",
+		410: "Gone",
+		411: "Length Required",
+		412: "Precondition Failed",
+		413: "Payload Too Large",
+		414: "URI Too Long",
+		415: "Unsupported Media Type",
+		416: "Range Not Satisfiable",
+		417: "Expectation Failed",
+		421: "Misdirected Request",
+		422: "Unprocessable Content",
+		425: "Too Early",
+		426: "Upgrade Required",
+		428: "Precondition Required",
+		429: "Too Many Requests",
+		431: "Request Header Fields Too Large",
+		451: "Unavailable For Legal Reasons",
 
 		500: "Internal Server Error",
+		501: "Not Implemented",
+		502: "Bad Gateway",
 		503: "Service Unavailable",
+		504: "Gateway Timeout",
+		505: "HTTP Version Not Supported",
 	}
 
 	if text, exists := statusTexts[code]; exists {
@@ -98,6 +196,10 @@ func getStatusText(code int) string {
 }
 
 func (r *Response) checkCancel() error {
+	if err := r.released(); err != nil {
+		return err
+	}
+
 	if r.reqCtx != nil {
 		select {
 		case <-r.reqCtx.Done():
@@ -115,6 +217,49 @@ func (r *Response) checkCancel() error {
 	return nil
 }
 
+func (r *Response) bufferLimit() int {
+	if r.Cfg.ResponseBufferLimit > 0 {
+		return r.Cfg.ResponseBufferLimit
+	}
+	return DefaultResponseBufferLimit
+}
+
+func (r *Response) isHead() bool {
+	return r.req != nil && r.req.Method == "HEAD"
+}
+
+// ShouldClose reports whether the connection has to be closed after this
+// response: either side asked for it, the body was close-delimited or a
+// write failed.
+func (r *Response) ShouldClose() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeAfter || r.writeErr != nil || strings.EqualFold(r.getHeader("Connection"), "close")
+}
+
 func (r *Response) HasError() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	return r.writeErr != nil
 }
+
+func (r *Response) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writeErr
+}
+
+// Status reports the status code sent (or about to be sent) to the client.
+func (r *Response) Status() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.StatusCode
+}
+
+// BytesWritten reports the number of body bytes written to the connection,
+// excluding the status line, headers and chunk framing.
+func (r *Response) BytesWritten() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bytesWritten
+}
@@ -0,0 +1,197 @@
+package response
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/request"
+)
+
+// testResponse is a response writing into one end of a pipe whose other end
+// is read in the background.
+type testResponse struct {
+	*Response
+	conn net.Conn
+	out  chan string
+}
+
+// newTestResponse answers a method request over version, switching to
+// streaming past limit buffered bytes.
+func newTestResponse(t *testing.T, method, version string, limit int) *testResponse {
+	t.Helper()
+	server, client := net.Pipe()
+	out := make(chan string, 1)
+	go func() {
+		b, _ := io.ReadAll(client)
+		out <- string(b)
+	}()
+	t.Cleanup(func() { server.Close() })
+
+	cfg := config.Load(4096, 1<<20, 8192, time.Second, time.Second)
+	cfg.ResponseBufferLimit = limit
+	req := &request.Request{Method: method, Path: "/", Version: version, Headers: map[string]string{}}
+	res := NewResponseWithContext(200, req, context.Background(), context.Background(), server, cfg)
+	return &testResponse{Response: res, conn: server, out: out}
+}
+
+// wire closes the connection and returns everything written to it.
+func (tr *testResponse) wire(t *testing.T) string {
+	t.Helper()
+	tr.conn.Close()
+	select {
+	case s := <-tr.out:
+		return s
+	case <-time.After(time.Second):
+		t.Fatal("reading the response timed out")
+		return ""
+	}
+}
+
+// splitResponse splits raw into its status line, header fields keyed by
+// their lower-case name, and body.
+func splitResponse(t *testing.T, raw string) (string, map[string]string, string) {
+	t.Helper()
+	head, body, ok := strings.Cut(raw, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("no end of headers in %q", raw)
+	}
+	lines := strings.Split(head, "\r\n")
+	headers := make(map[string]string)
+	for _, line := range lines[1:] {
+		k, v, _ := strings.Cut(line, ": ")
+		headers[strings.ToLower(k)] = v
+	}
+	return lines[0], headers, body
+}
+
+func TestWriteStreamingSwitch(t *testing.T) {
+	tests := []struct {
+		name          string
+		version       string
+		contentLength string
+		writes        []string
+		headers       map[string]string
+		body          string
+		shouldClose   bool
+	}{
+		{
+			name:    "buffered below the limit",
+			version: "HTTP/1.1",
+			writes:  []string{"hel", "lo"},
+			headers: map[string]string{"content-length": "5", "connection": "keep-alive"},
+			body:    "hello",
+		},
+		{
+			name:    "chunked past the limit",
+			version: "HTTP/1.1",
+			writes:  []string{"hello, ", "world", "!"},
+			headers: map[string]string{"transfer-encoding": "chunked", "connection": "keep-alive"},
+			body:    "c\r\nhello, world\r\n1\r\n!\r\n0\r\n\r\n",
+		},
+		{
+			name:        "close-delimited for HTTP/1.0",
+			version:     "HTTP/1.0",
+			writes:      []string{"hello, ", "world", "!"},
+			headers:     map[string]string{"connection": "close"},
+			body:        "hello, world!",
+			shouldClose: true,
+		},
+		{
+			name:          "as-is with Content-Length",
+			version:       "HTTP/1.1",
+			contentLength: "13",
+			writes:        []string{"hello, ", "world", "!"},
+			headers:       map[string]string{"content-length": "13", "connection": "keep-alive"},
+			body:          "hello, world!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newTestResponse(t, "GET", tt.version, 10)
+			if tt.contentLength != "" {
+				res.SetHeader("Content-Length", tt.contentLength)
+			}
+			for _, w := range tt.writes {
+				if n, err := res.WriteString(w); err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if err := res.Finish(); err != nil {
+				t.Fatal(err)
+			}
+			if res.ShouldClose() != tt.shouldClose {
+				t.Errorf("ShouldClose = %v", res.ShouldClose())
+			}
+
+			status, headers, body := splitResponse(t, res.wire(t))
+			if status != "HTTP/1.1 200 OK" {
+				t.Errorf("status line %q", status)
+			}
+			for _, k := range []string{"content-length", "transfer-encoding", "connection"} {
+				if headers[k] != tt.headers[k] {
+					t.Errorf("%s %q, want %q", k, headers[k], tt.headers[k])
+				}
+			}
+			if body != tt.body {
+				t.Errorf("body %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestWriteStreamingHead(t *testing.T) {
+	res := newTestResponse(t, "HEAD", "HTTP/1.1", 4)
+	if _, err := res.WriteString("hello, world"); err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	_, headers, body := splitResponse(t, res.wire(t))
+	if headers["transfer-encoding"] != "chunked" || body != "" {
+		t.Errorf("headers %v, body %q", headers, body)
+	}
+}
+
+func TestWriteContentLengthMismatch(t *testing.T) {
+	res := newTestResponse(t, "GET", "HTTP/1.1", 4)
+	res.SetHeader("Content-Length", "8")
+	if _, err := res.WriteString("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := res.WriteString("world"); err == nil {
+		t.Error("writing past Content-Length succeeded")
+	}
+
+	res = newTestResponse(t, "GET", "HTTP/1.1", 4)
+	res.SetHeader("Content-Length", "8")
+	res.WriteString("hello")
+	if err := res.Finish(); err == nil {
+		t.Error("a streamed body shorter than Content-Length was not reported")
+	}
+	if !res.ShouldClose() {
+		t.Error("connection kept open after a short body")
+	}
+}
+
+func TestWriteAfterFinish(t *testing.T) {
+	res := newTestResponse(t, "GET", "HTTP/1.1", 0)
+	res.WriteString("done")
+	if err := res.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Finish(); err != nil {
+		t.Errorf("second Finish: %v", err)
+	}
+	if _, err := res.WriteString("more"); err != ErrResponseFinished {
+		t.Errorf("Write after Finish: %v", err)
+	}
+	if res.BytesWritten() != 4 {
+		t.Errorf("BytesWritten = %d", res.BytesWritten())
+	}
+}
@@ -0,0 +1,182 @@
+package response
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+)
+
+// sendFileSlice bounds each sendfile call so WriteTimeout is renewed as the
+// transfer makes progress instead of covering the whole file.
+const sendFileSlice = 4 << 20
+
+// netConner is implemented by connection wrappers, including *tls.Conn, to
+// expose the connection they wrap.
+type netConner interface {
+	NetConn() net.Conn
+}
+
+// SendFile writes the headers followed by length bytes of f starting at
+// offset; a negative length sends the rest of the file. On plain TCP the
+// body is moved by the kernel (sendfile/splice) without passing through
+// user space. When the body must be encoded or the connection uses TLS it
+// falls back to copying through a buffer.
+func (r *Response) SendFile(req *request.Request, f *os.File, offset, length int64) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer func() {
+		if err != nil {
+			r.writeErr = err
+		}
+	}()
+
+	if r.chunked {
+		return errors.New("SendFile cannot be used with chunked encoding")
+	}
+
+	if err = r.released(); err != nil {
+		return err
+	}
+
+	if r.finished || r.headersSent {
+		return ErrResponseFinished
+	}
+
+	if length < 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		length = info.Size() - offset
+	}
+	if offset < 0 || length < 0 {
+		return errors.New("invalid file section")
+	}
+
+	if r.hasContentLength && int64(r.contentLength) != length {
+		return errors.New("file section does not match Content-Length")
+	}
+
+	if req != nil {
+		r.req = req
+	}
+
+	if r.wantsEncoding(int(length)) {
+		// the encoded size is only known once compressed, so buffer and Flush
+		body := make([]byte, length)
+		if _, err = f.ReadAt(body, offset); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		r.Body = body
+		return r.finish()
+	}
+
+	if err = r.checkCancel(); err != nil {
+		return err
+	}
+
+	r.headerWritten = true
+	r.streaming = true
+	r.stopTimer()
+	r.finished = true
+	defer func() { err = r.closeStream(err) }()
+	r.hasContentLength = true
+	r.contentLength = int(length)
+	r.setHeaderValue("Content-Length", strconv.FormatInt(length, 10))
+
+	if err = r.sendHeaders(); err != nil {
+		return err
+	}
+
+	if r.isHead() || noBodyStatus(r.StatusCode) {
+		return nil
+	}
+
+	dst, zeroCopy := sendFileTarget(r.Conn)
+	if r.stream != nil {
+		zeroCopy = false
+	}
+	if zeroCopy {
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	section := io.NewSectionReader(f, offset, length)
+
+	for remaining := length; remaining > 0; {
+		if err = r.checkCancel(); err != nil {
+			return err
+		}
+		if r.stream == nil {
+			r.Conn.SetWriteDeadline(time.Now().Add(r.Cfg.WriteTimeout))
+		}
+
+		// the lock is let go for the copy so Status and BytesWritten do
+		// not block on a slow client; finished keeps other writers out
+		n := min(remaining, sendFileSlice)
+		var written int64
+		r.mu.Unlock()
+		if zeroCopy {
+			written, err = dst.ReadFrom(&io.LimitedReader{R: f, N: n})
+		} else if r.stream != nil {
+			written, err = io.CopyN(bodyWriter{r}, section, n)
+		} else {
+			written, err = io.CopyN(r.Conn, section, n)
+		}
+		r.mu.Lock()
+		r.bytesWritten += written
+		remaining -= written
+
+		if err == io.EOF {
+			// CopyN ran out of file, not of connection
+			err = nil
+		}
+		if err != nil {
+			r.closeAfter = true
+			if isConnectionError(err) {
+				return ErrConnectionClosed
+			}
+			return err
+		}
+		if written == 0 {
+			r.closeAfter = true
+			return io.ErrUnexpectedEOF // file shrank underneath us
+		}
+	}
+	return nil
+}
+
+// SendFilePath opens name and sends a section of it, see SendFile.
+func (r *Response) SendFilePath(req *request.Request, name string, offset, length int64) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.SendFile(req, f, offset, length)
+}
+
+// sendFileTarget unwraps conn down to the socket and reports whether the
+// kernel can copy into it directly. TLS has to encrypt in user space.
+func sendFileTarget(conn net.Conn) (io.ReaderFrom, bool) {
+	for {
+		if _, ok := conn.(*tls.Conn); ok {
+			return nil, false
+		}
+		inner, ok := conn.(netConner)
+		if !ok {
+			break
+		}
+		conn = inner.NetConn()
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		return tcp, true
+	}
+	return nil, false
+}
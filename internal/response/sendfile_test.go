@@ -0,0 +1,144 @@
+package response
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/request"
+)
+
+func openTestFile(t *testing.T, content string) *os.File {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestSendFile(t *testing.T) {
+	const content = "0123456789abcdef"
+	tests := []struct {
+		name           string
+		method         string
+		offset, length int64
+		body           string
+		contentLength  string
+	}{
+		{name: "whole file", method: "GET", offset: 0, length: -1, body: content, contentLength: "16"},
+		{name: "section", method: "GET", offset: 4, length: 6, body: "456789", contentLength: "6"},
+		{name: "rest of the file", method: "GET", offset: 10, length: -1, body: "abcdef", contentLength: "6"},
+		{name: "HEAD", method: "HEAD", offset: 0, length: -1, body: "", contentLength: "16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newTestResponse(t, tt.method, "HTTP/1.1", 0)
+			if err := res.SendFile(nil, openTestFile(t, content), tt.offset, tt.length); err != nil {
+				t.Fatal(err)
+			}
+			_, headers, body := splitResponse(t, res.wire(t))
+			if body != tt.body || headers["content-length"] != tt.contentLength {
+				t.Errorf("Content-Length %q, body %q", headers["content-length"], body)
+			}
+			if res.BytesWritten() != int64(len(tt.body)) {
+				t.Errorf("BytesWritten = %d", res.BytesWritten())
+			}
+		})
+	}
+}
+
+func TestSendFileErrors(t *testing.T) {
+	f := openTestFile(t, "0123456789")
+
+	res := newTestResponse(t, "GET", "HTTP/1.1", 0)
+	res.SetHeader("Content-Length", "4")
+	if err := res.SendFile(nil, f, 0, 5); err == nil {
+		t.Error("section longer than Content-Length accepted")
+	}
+
+	res = newTestResponse(t, "GET", "HTTP/1.1", 0)
+	if err := res.SendFile(nil, f, 20, -1); err == nil {
+		t.Error("offset past the end accepted")
+	}
+
+	res = newTestResponse(t, "GET", "HTTP/1.1", 0)
+	res.SetHeader("Transfer-Encoding", "chunked")
+	if err := res.SendFile(nil, f, 0, -1); err == nil {
+		t.Error("SendFile on a chunked response accepted")
+	}
+
+	res = newTestResponse(t, "GET", "HTTP/1.1", 0)
+	res.Finish()
+	if err := res.SendFile(nil, f, 0, -1); err != ErrResponseFinished {
+		t.Errorf("SendFile after Finish: %v", err)
+	}
+
+	// a file that shrank leaves the client short, so the connection goes
+	res = newTestResponse(t, "GET", "HTTP/1.1", 0)
+	if err := res.SendFile(nil, f, 0, 20); err != io.ErrUnexpectedEOF {
+		t.Errorf("SendFile past the end of the file: %v", err)
+	}
+	if !res.ShouldClose() {
+		t.Error("connection kept open after a short body")
+	}
+}
+
+func TestSendFileZeroCopy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	out := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			out <- ""
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		out <- string(b)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, zeroCopy := sendFileTarget(conn); !zeroCopy {
+		t.Error("TCP connection not used for sendfile")
+	}
+
+	cfg := config.Load(4096, 1<<20, 8192, time.Second, time.Second)
+	req := &request.Request{Method: "GET", Path: "/", Version: "HTTP/1.1", Headers: map[string]string{}}
+	res := NewResponseWithContext(200, req, context.Background(), context.Background(), conn, cfg)
+	if err := res.SendFile(nil, openTestFile(t, "0123456789abcdef"), 2, 8); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	_, headers, body := splitResponse(t, <-out)
+	if body != "23456789" || headers["content-length"] != "8" {
+		t.Errorf("Content-Length %q, body %q", headers["content-length"], body)
+	}
+}
+
+func TestSendFileTarget(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	if _, zeroCopy := sendFileTarget(server); zeroCopy {
+		t.Error("pipe reported as a sendfile target")
+	}
+}
@@ -0,0 +1,68 @@
+package response
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/request"
+)
+
+// Stream carries a response over a multiplexed protocol such as HTTP/2 in
+// place of the HTTP/1 framing written to Conn. The response still decides
+// when headers go out and how the body is buffered or encoded; the stream
+// only frames what it is given.
+type Stream interface {
+	// WriteHeaders sends the response head; cookies are Set-Cookie values
+	// that each need a field of their own.
+	WriteHeaders(status int, headers map[string]string, cookies []string) error
+	WriteData(p []byte) error
+	// End completes the stream, sending trailers when there are any.
+	End(trailers map[string]string) error
+	// Reset abandons the stream after a failure.
+	Reset()
+}
+
+// NewStreamResponse creates the response for a request that arrived on
+// stream. conn is the connection the stream belongs to; it is only used for
+// its addresses.
+func NewStreamResponse(req *request.Request, connCtx, reqCtx context.Context, conn net.Conn, cfg *config.Config, stream Stream) *Response {
+	r := NewResponseWithContext(200, req, connCtx, reqCtx, conn, cfg)
+	r.stream = stream
+	return r
+}
+
+// writeBody puts body bytes on the wire as they are: straight onto the
+// connection, or as data on the stream.
+func (r *Response) writeBody(p []byte) (int, error) {
+	if r.stream != nil {
+		if err := r.stream.WriteData(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	// write deadline before writing
+	r.Conn.SetWriteDeadline(time.Now().Add(r.Cfg.WriteTimeout))
+	return safeWrite(r.Conn, p)
+}
+
+// closeStream ends the stream once the response is complete, or resets it
+// when the response failed. It passes err through.
+func (r *Response) closeStream(err error) error {
+	if r.stream == nil {
+		return err
+	}
+	if err != nil {
+		r.stream.Reset()
+		return err
+	}
+	return r.stream.End(r.trailers)
+}
+
+type bodyWriter struct{ r *Response }
+
+func (w bodyWriter) Write(p []byte) (int, error) {
+	return w.r.writeBody(p)
+}
@@ -0,0 +1,100 @@
+package response
+
+import "time"
+
+// SetTimeout (re)arms the handler deadline to d from now; d <= 0 disarms
+// it. When the deadline passes before Finish, the response answers for the
+// handler: if nothing was sent yet the client gets code with body (503 and
+// its reason phrase when zero), otherwise the body is cut short. An HTTP/1
+// connection is then closed while an HTTP/2 stream that was cut short is
+// reset; either way the OnTimeout callback runs and every later write fails
+// with ErrHandlerTimeout.
+func (r *Response) SetTimeout(d time.Duration, code int, body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopTimer()
+	if d <= 0 || r.finished || r.timedOut {
+		return
+	}
+
+	if code == 0 {
+		code = 503
+	}
+	if body == nil {
+		body = []byte(StatusText(code))
+	}
+
+	seq := r.timerSeq
+	r.timer = time.AfterFunc(d, func() { r.expire(seq, code, body) })
+}
+
+// OnTimeout registers fn to run once the handler deadline has passed and
+// the timeout response is out; the server uses it to cancel the request
+// context.
+func (r *Response) OnTimeout(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onTimeout = fn
+}
+
+// TimedOut reports whether the handler overran its deadline.
+func (r *Response) TimedOut() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.timedOut
+}
+
+// stopTimer disarms the deadline; Finish calls it once the handler is
+// done. Bumping timerSeq also voids a timer that already fired and is
+// waiting for the lock.
+func (r *Response) stopTimer() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.timerSeq++
+}
+
+func (r *Response) expire(seq uint64, code int, body []byte) {
+	r.mu.Lock()
+	if seq != r.timerSeq {
+		r.mu.Unlock()
+		return
+	}
+
+	r.closeAfter = true
+	replied := false
+	if !r.headersSent {
+		// nothing reached the client: drop what the handler prepared
+		r.Headers = map[string]string{"Content-Type": "text/plain; charset=utf-8"}
+		r.cookies = nil
+		r.StatusCode = code
+		r.Body = append([]byte(nil), body...)
+		r.headerWritten = false
+		r.chunked = false
+		r.streaming = false
+		r.closeDelimited = false
+		r.hasContentLength = false
+		r.declaredTrailers = nil
+		r.trailers = nil
+		r.encoding = nil
+		r.finish()
+		replied = true
+	}
+	r.finished = true
+	r.timedOut = true
+	switch {
+	case r.stream == nil:
+		r.Conn.Close()
+	case !replied:
+		r.stream.Reset() // other streams on the connection carry on
+	}
+
+	fn := r.onTimeout
+	r.mu.Unlock()
+
+	if fn != nil {
+		fn()
+	}
+}
@@ -0,0 +1,120 @@
+package response
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// expired arms a short deadline on res and waits for it to pass.
+func expired(t *testing.T, res *testResponse) {
+	t.Helper()
+	fired := make(chan struct{})
+	res.OnTimeout(func() { close(fired) })
+	res.SetTimeout(10*time.Millisecond, 0, nil)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("the deadline did not fire")
+	}
+}
+
+func TestTimeoutBeforeHeaders(t *testing.T) {
+	res := newTestResponse(t, "GET", "HTTP/1.1", 0)
+	res.SetHeader("X-Handler", "yes")
+	res.AddHeader("Set-Cookie", "a=1")
+	res.WriteString("partial")
+	expired(t, res)
+
+	if !res.TimedOut() {
+		t.Error("TimedOut = false")
+	}
+	if _, err := res.WriteString("late"); err != ErrHandlerTimeout {
+		t.Errorf("Write after the deadline: %v", err)
+	}
+	if err := res.Finish(); err != nil {
+		t.Errorf("Finish after the deadline: %v", err)
+	}
+
+	status, headers, body := splitResponse(t, res.wire(t))
+	if status != "HTTP/1.1 503 Service Unavailable" || body != "Service Unavailable" {
+		t.Errorf("got %q %q", status, body)
+	}
+	if headers["x-handler"] != "" || headers["set-cookie"] != "" || headers["connection"] != "close" {
+		t.Errorf("headers %v", headers)
+	}
+}
+
+func TestTimeoutCustomResponse(t *testing.T) {
+	res := newTestResponse(t, "GET", "HTTP/1.1", 0)
+	fired := make(chan struct{})
+	res.OnTimeout(func() { close(fired) })
+	res.SetTimeout(10*time.Millisecond, 504, []byte("upstream too slow"))
+	<-fired
+
+	status, _, body := splitResponse(t, res.wire(t))
+	if status != "HTTP/1.1 504 Gateway Timeout" || body != "upstream too slow" {
+		t.Errorf("got %q %q", status, body)
+	}
+}
+
+func TestTimeoutAfterHeaders(t *testing.T) {
+	res := newTestResponse(t, "GET", "HTTP/1.1", 4)
+	res.WriteString("streamed")
+	expired(t, res)
+
+	// the client already has a 200, so the body is cut short
+	status, _, body := splitResponse(t, res.wire(t))
+	if status != "HTTP/1.1 200 OK" || body != "8\r\nstreamed\r\n" {
+		t.Errorf("got %q %q", status, body)
+	}
+	if _, err := res.WriteString("late"); err != ErrHandlerTimeout {
+		t.Errorf("Write after the deadline: %v", err)
+	}
+}
+
+func TestTimeoutStopped(t *testing.T) {
+	tests := []struct {
+		name   string
+		finish func(t *testing.T, res *testResponse)
+	}{
+		{name: "Finish", finish: func(t *testing.T, res *testResponse) {
+			res.WriteString("done")
+			if err := res.Finish(); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "SendFile", finish: func(t *testing.T, res *testResponse) {
+			if err := res.SendFile(nil, openTestFile(t, "done"), 0, -1); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "Abort", finish: func(t *testing.T, res *testResponse) {
+			res.Abort(nil)
+		}},
+		{name: "disarmed", finish: func(t *testing.T, res *testResponse) {
+			res.SetTimeout(0, 0, nil)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newTestResponse(t, "GET", "HTTP/1.1", 0)
+			fired := make(chan struct{})
+			res.OnTimeout(func() { close(fired) })
+			res.SetTimeout(20*time.Millisecond, 0, nil)
+			tt.finish(t, res)
+
+			select {
+			case <-fired:
+				t.Fatal("the deadline fired after the response was done")
+			case <-time.After(60 * time.Millisecond):
+			}
+			if res.TimedOut() {
+				t.Error("TimedOut = true")
+			}
+			if strings.Contains(res.wire(t), "503") {
+				t.Error("a 503 was sent")
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// forbiddenTrailers may not be sent after the body (RFC 9110 6.5.1): they
+// frame the message, route it, or are needed before the content is used.
+var forbiddenTrailers = map[string]bool{
+	"authorization":       true,
+	"cache-control":       true,
+	"connection":          true,
+	"content-encoding":    true,
+	"content-length":      true,
+	"content-range":       true,
+	"content-type":        true,
+	"cookie":              true,
+	"expect":              true,
+	"host":                true,
+	"keep-alive":          true,
+	"max-forwards":        true,
+	"pragma":              true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"proxy-connection":    true,
+	"range":               true,
+	"set-cookie":          true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+	"www-authenticate":    true,
+}
+
+// declareTrailers validates the value of a Trailer header and records the
+// announced field names.
+func (r *Response) declareTrailers(value string) error {
+	declared := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if forbiddenTrailers[strings.ToLower(name)] {
+			return fmt.Errorf("%s is not allowed as a trailer field", name)
+		}
+		declared[strings.ToLower(name)] = true
+	}
+	if len(declared) == 0 {
+		return errors.New("empty Trailer header")
+	}
+	r.declaredTrailers = declared
+	return nil
+}
+
+// SetTrailer sets a trailer field sent after the last chunk. The field must
+// have been announced beforehand with SetHeader("Trailer", ...).
+func (r *Response) SetTrailer(k, v string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		return ErrResponseFinished
+	}
+	if !r.declaredTrailers[strings.ToLower(k)] {
+		return fmt.Errorf("trailer %s was not declared in the Trailer header", k)
+	}
+	if r.trailers == nil {
+		r.trailers = make(map[string]string)
+	}
+	r.trailers[k] = v
+	return nil
+}
+
+// lastChunk is the zero-size chunk plus any trailer fields.
+func (r *Response) lastChunk() string {
+	var b strings.Builder
+	b.WriteString("0\r\n")
+	for k, v := range r.trailers {
+		b.WriteString(k)
+		b.WriteString(": ")
+		b.WriteString(v)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
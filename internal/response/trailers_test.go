@@ -0,0 +1,84 @@
+package response
+
+import "testing"
+
+func TestTrailers(t *testing.T) {
+	res := newTestResponse(t, "GET", "HTTP/1.1", 0)
+	if err := res.SetHeader("Trailer", "Checksum"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := res.WriteString("data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := res.SetTrailer("checksum", "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := res.SetTrailer("Expires", "0"); err == nil {
+		t.Error("undeclared trailer accepted")
+	}
+	if err := res.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := res.SetTrailer("Checksum", "late"); err != ErrResponseFinished {
+		t.Errorf("SetTrailer after Finish: %v", err)
+	}
+
+	_, headers, body := splitResponse(t, res.wire(t))
+	if headers["transfer-encoding"] != "chunked" || headers["trailer"] != "Checksum" {
+		t.Errorf("headers %v", headers)
+	}
+	if want := "4\r\ndata\r\n0\r\nchecksum: abc123\r\n\r\n"; body != want {
+		t.Errorf("body %q, want %q", body, want)
+	}
+}
+
+func TestTrailersWithoutBody(t *testing.T) {
+	res := newTestResponse(t, "GET", "HTTP/1.1", 0)
+	res.SetHeader("Trailer", "Status")
+	res.SetTrailer("Status", "ok")
+	if err := res.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	_, _, body := splitResponse(t, res.wire(t))
+	if want := "0\r\nStatus: ok\r\n\r\n"; body != want {
+		t.Errorf("body %q, want %q", body, want)
+	}
+}
+
+func TestDeclareTrailers(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: "Checksum"},
+		{value: "Checksum, Server-Timing"},
+		{value: " , ", wantErr: true},
+		{value: "Content-Length", wantErr: true},
+		{value: "Checksum, set-cookie", wantErr: true},
+		{value: "Transfer-Encoding", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			res := newTestResponse(t, "GET", "HTTP/1.1", 0)
+			err := res.SetHeader("Trailer", tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SetHeader(Trailer, %q) = %v", tt.value, err)
+			}
+		})
+	}
+}
+
+func TestTrailersHTTP10(t *testing.T) {
+	// HTTP/1.0 has no chunked framing to carry them
+	res := newTestResponse(t, "GET", "HTTP/1.0", 0)
+	res.SetHeader("Trailer", "Checksum")
+	res.SetTrailer("Checksum", "abc123")
+	res.WriteString("data")
+	if err := res.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	_, headers, body := splitResponse(t, res.wire(t))
+	if headers["transfer-encoding"] != "" || body != "data" {
+		t.Errorf("headers %v, body %q", headers, body)
+	}
+}
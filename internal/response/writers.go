@@ -3,7 +3,6 @@ package response
 import (
 	"errors"
 	"io"
-	"log"
 	"net"
 )
 
@@ -11,10 +10,8 @@ func safeWrite(conn net.Conn, buffer []byte) (int, error) {
 	n, err := conn.Write(buffer)
 	if err != nil {
 		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || isConnectionError(err) {
-			log.Printf("write error : connection closed")
 			return n, ErrConnectionClosed
 		}
-		log.Printf("write error: %v", err)
 		return n, err
 	}
 	return n, nil
@@ -24,3 +21,152 @@ func safeWriteString(conn net.Conn, s string) error {
 	_, err := safeWrite(conn, []byte(s))
 	return err
 }
+
+// Write implements io.Writer. The body is buffered until it outgrows the
+// response buffer limit, after which the headers are sent and the rest is
+// streamed: chunked for HTTP/1.1, close-delimited for HTTP/1.0, or as-is
+// when the handler already set Content-Length.
+func (r *Response) Write(b []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer func() {
+		if err != nil {
+			r.writeErr = err
+		}
+	}()
+
+	if err = r.released(); err != nil {
+		return 0, err
+	}
+
+	if r.finished {
+		return 0, ErrResponseFinished
+	}
+
+	if r.chunked {
+		if err = r.writeChunk(b); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if r.streaming {
+		return r.writeStream(b)
+	}
+
+	if r.hasContentLength && len(r.Body)+len(b) > r.contentLength {
+		return 0, errors.New("body exceeds Content-Length")
+	}
+
+	if !r.headerWritten {
+		r.headerWritten = true
+	}
+
+	r.Body = append(r.Body, b...)
+
+	if len(r.Body) > r.bufferLimit() {
+		if err = r.startStreaming(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (r *Response) WriteString(s string) (int, error) {
+	return r.Write([]byte(s))
+}
+
+// startStreaming stops buffering: it picks a framing for the body, sends
+// the headers and writes out what was buffered so far.
+func (r *Response) startStreaming() error {
+	if err := r.checkCancel(); err != nil {
+		return err
+	}
+
+	switch {
+	case r.hasContentLength && !r.wantsEncoding(-1):
+		// length already known, the body can follow unframed
+	case r.req == nil || r.req.Version != "HTTP/1.0":
+		r.chunked = true
+	default:
+		r.closeDelimited = true
+		r.closeAfter = true
+	}
+
+	pending := r.Body
+	r.Body = nil
+
+	if r.chunked {
+		return r.writeChunk(pending)
+	}
+
+	if err := r.startStream(); err != nil {
+		return err
+	}
+	_, err := r.writeStream(pending)
+	return err
+}
+
+// startStream sends the headers for a body that follows incrementally.
+func (r *Response) startStream() error {
+	r.headerWritten = true
+	r.streaming = true
+	if r.chunked || r.closeDelimited {
+		r.startStreamEncoding()
+	}
+	return r.sendHeaders()
+}
+
+// writeStream writes unframed body bytes after the headers have been sent.
+func (r *Response) writeStream(b []byte) (int, error) {
+	if err := r.checkCancel(); err != nil {
+		return 0, err
+	}
+
+	data := b
+	if r.encoder != nil {
+		var err error
+		if data, err = r.encodeChunk(b, false); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.hasContentLength && r.bytesWritten+int64(len(data)) > int64(r.contentLength) {
+		return 0, errors.New("body exceeds Content-Length")
+	}
+
+	if r.isHead() || len(data) == 0 {
+		return len(b), nil
+	}
+
+	n, err := r.writeBody(data)
+	r.bytesWritten += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// endStream completes an unframed streamed body.
+func (r *Response) endStream() error {
+	if r.encoder != nil {
+		tail, err := r.encodeChunk(nil, true)
+		if err != nil {
+			return err
+		}
+		if len(tail) > 0 && !r.isHead() {
+			n, err := r.writeBody(tail)
+			r.bytesWritten += int64(n)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.hasContentLength && !r.isHead() && r.bytesWritten != int64(r.contentLength) {
+		// the client is still waiting for the missing bytes
+		r.closeAfter = true
+		return errors.New("body shorter than Content-Length")
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrMisdirectedHost means a strict HostRouter serves no such host;
+	// the server answers 421 Misdirected Request.
+	ErrMisdirectedHost = errors.New("host not served here")
+	// ErrUnknownHost means the host matched nothing and there is no
+	// default matcher; the server answers 404.
+	ErrUnknownHost = errors.New("unknown host")
+)
+
+// HostMatcher is implemented by matchers that pick the RouteMatcher for a
+// request by its host before the path is matched.
+type HostMatcher interface {
+	MatchHost(host string) (RouteMatcher, map[string]string, error)
+}
+
+// HostRouter dispatches to a RouteMatcher per virtual host. Hosts are
+// registered as
+//
+//	example.test          that host exactly
+//	:tenant.example.test  one label in place of each :name, captured as a param
+//	*.example.test        any subdomain, however deep, but not example.test
+//
+// IP addresses are exact hosts, IPv6 ones with or without brackets. Exact
+// hosts win over patterns, patterns over wildcards, and the longest
+// wildcard wins among those. A request for any other host goes to the
+// default matcher, or is misdirected when Strict is set.
+type HostRouter struct {
+	// Strict answers requests for unknown hosts with 421 Misdirected
+	// Request instead of handing them to the default matcher.
+	Strict bool
+
+	// def serves hosts nothing else matches, and requests without a Host
+	// header even when Strict is set
+	def       RouteMatcher
+	exact     map[string]RouteMatcher
+	patterns  []hostPattern
+	wildcards []hostPattern // longest suffix first
+
+	// shared are routes registered on the HostRouter itself, which every
+	// host serves
+	shared []sharedRoute
+	// registered tracks which matchers have the shared routes already
+	registered map[RouteMatcher]bool
+}
+
+type hostPattern struct {
+	pattern string
+	labels  []string // for patterns
+	suffix  string   // for wildcards, with the leading dot
+	matcher RouteMatcher
+}
+
+type sharedRoute struct {
+	method, path string
+	handler      Handler
+}
+
+// NewHostRouter builds a HostRouter that falls back to def, which may be
+// nil to serve registered hosts only.
+func NewHostRouter(def RouteMatcher) *HostRouter {
+	h := &HostRouter{
+		def:        def,
+		exact:      make(map[string]RouteMatcher),
+		registered: make(map[RouteMatcher]bool),
+	}
+	if def != nil {
+		h.share(def)
+	}
+	return h
+}
+
+// Host routes requests for pattern to m. It panics on a malformed or
+// repeated pattern, as Register does on routes.
+func (h *HostRouter) Host(pattern string, m RouteMatcher) {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if pattern == "" {
+		panic("empty host pattern")
+	}
+	// an IPv6 literal would otherwise read as a pattern of params
+	if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(pattern, "["), "]")); ip != nil {
+		pattern = ip.String()
+	}
+	if h.taken(pattern) {
+		panic(fmt.Sprintf("host %s already registered", pattern))
+	}
+	if net.ParseIP(pattern) != nil {
+		h.exact[pattern] = m
+		h.share(m)
+		return
+	}
+
+	labels := strings.Split(pattern, ".")
+	for i, label := range labels {
+		switch {
+		case label == "":
+			panic(fmt.Sprintf("invalid host pattern %s: empty label", pattern))
+		case label == "*" && i != 0, label != "*" && strings.Contains(label, "*"):
+			panic(fmt.Sprintf("invalid host pattern %s: * must be the whole first label", pattern))
+		case label == ":":
+			panic(fmt.Sprintf("invalid host pattern %s: unnamed param", pattern))
+		}
+	}
+
+	switch {
+	case labels[0] == "*":
+		if len(labels) < 2 {
+			panic(fmt.Sprintf("invalid host pattern %s: wildcard needs a domain", pattern))
+		}
+		h.wildcards = append(h.wildcards, hostPattern{pattern: pattern, suffix: pattern[1:], matcher: m})
+		sort.SliceStable(h.wildcards, func(i, j int) bool {
+			return len(h.wildcards[i].suffix) > len(h.wildcards[j].suffix)
+		})
+	case strings.Contains(pattern, ":"):
+		h.patterns = append(h.patterns, hostPattern{pattern: pattern, labels: labels, matcher: m})
+	default:
+		h.exact[pattern] = m
+	}
+	h.share(m)
+}
+
+func (h *HostRouter) taken(pattern string) bool {
+	if _, ok := h.exact[pattern]; ok {
+		return true
+	}
+	for _, p := range h.patterns {
+		if p.pattern == pattern {
+			return true
+		}
+	}
+	for _, w := range h.wildcards {
+		if w.pattern == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchHost picks the matcher for host, a Host header value that may carry
+// a port, and returns the params of a pattern host.
+func (h *HostRouter) MatchHost(host string) (RouteMatcher, map[string]string, error) {
+	if host == "" {
+		return h.fallback(false)
+	}
+	host = normalizeHost(host)
+
+	if m, ok := h.exact[host]; ok {
+		return m, nil, nil
+	}
+
+	labels := strings.Split(host, ".")
+	for _, p := range h.patterns {
+		if params, ok := p.match(labels); ok {
+			return p.matcher, params, nil
+		}
+	}
+
+	for _, w := range h.wildcards {
+		if len(host) > len(w.suffix) && strings.HasSuffix(host, w.suffix) {
+			return w.matcher, nil, nil
+		}
+	}
+	return h.fallback(h.Strict)
+}
+
+func (h *HostRouter) fallback(strict bool) (RouteMatcher, map[string]string, error) {
+	if strict {
+		return nil, nil, ErrMisdirectedHost
+	}
+	if h.def == nil {
+		return nil, nil, ErrUnknownHost
+	}
+	return h.def, nil, nil
+}
+
+func (p hostPattern) match(labels []string) (map[string]string, bool) {
+	if len(labels) != len(p.labels) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, label := range p.labels {
+		if label[0] == ':' {
+			params[label[1:]] = labels[i]
+			continue
+		}
+		if label != labels[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// Register adds a route every host serves, such as a metrics endpoint,
+// including hosts added later.
+func (h *HostRouter) Register(method, path string, handler Handler) {
+	h.shared = append(h.shared, sharedRoute{method, path, handler})
+	for m := range h.registered {
+		m.Register(method, path, handler)
+	}
+}
+
+// share registers the shared routes on m, once however many hosts use it.
+func (h *HostRouter) share(m RouteMatcher) {
+	if h.registered[m] {
+		return
+	}
+	h.registered[m] = true
+	for _, r := range h.shared {
+		m.Register(r.method, r.path, r.handler)
+	}
+}
+
+// Match matches path on the default matcher, for callers that know
+// nothing of hosts.
+func (h *HostRouter) Match(method, path string) (Handler, map[string]string, error) {
+	if h.def == nil {
+		return nil, nil, ErrUnknownHost
+	}
+	return h.def.Match(method, path)
+}
+
+// Routes lists the routes of every host that can enumerate them, the
+// default ones with an empty Host.
+func (h *HostRouter) Routes() []RouteInfo {
+	var routes []RouteInfo
+	add := func(host string, m RouteMatcher) {
+		lister, ok := m.(RouteLister)
+		if !ok {
+			return
+		}
+		for _, r := range lister.Routes() {
+			r.Host = host
+			routes = append(routes, r)
+		}
+	}
+
+	if h.def != nil {
+		add("", h.def)
+	}
+	hosts := make([]string, 0, len(h.exact))
+	for host := range h.exact {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		add(host, h.exact[host])
+	}
+	for _, p := range h.patterns {
+		add(p.pattern, p.matcher)
+	}
+	for _, w := range h.wildcards {
+		add(w.pattern, w.matcher)
+	}
+	return routes
+}
+
+// normalizeHost lower-cases host and drops its port and any trailing dot;
+// IP addresses are put in their canonical form.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
@@ -0,0 +1,197 @@
+package router
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+)
+
+func nop(*request.Request, *response.Response) {}
+
+// testHosts registers a host router over named matchers so results can be
+// told apart by name.
+func testHosts(def bool) (*HostRouter, map[RouteMatcher]string) {
+	names := map[RouteMatcher]string{}
+	named := func(name string) RouteMatcher {
+		r := NewRouter()
+		names[r] = name
+		return r
+	}
+
+	var d RouteMatcher
+	if def {
+		d = named("default")
+	}
+	h := NewHostRouter(d)
+	h.Host("example.test", named("exact"))
+	h.Host(":tenant.example.test", named("tenant"))
+	h.Host("api.:tenant.example.test", named("api"))
+	h.Host("*.example.test", named("wild"))
+	h.Host("*.eu.example.test", named("wild-eu"))
+	h.Host("127.0.0.1", named("ipv4"))
+	h.Host("[::1]", named("loopback6"))
+	h.Host("2001:DB8::1", named("ipv6"))
+	h.Host("Example.ORG.", named("org"))
+	return h, names
+}
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		host   string
+		want   string
+		params map[string]string
+	}{
+		{host: "example.test", want: "exact"},
+		{host: "EXAMPLE.Test", want: "exact"},
+		{host: "example.test:8080", want: "exact"},
+		{host: "example.test.", want: "exact"},
+		{host: "example.test.:443", want: "exact"},
+		{host: "acme.example.test", want: "tenant", params: map[string]string{"tenant": "acme"}},
+		{host: "Acme.example.test:8080", want: "tenant", params: map[string]string{"tenant": "acme"}},
+		{host: "acme.example.test.", want: "tenant", params: map[string]string{"tenant": "acme"}},
+		{host: "api.acme.example.test", want: "api", params: map[string]string{"tenant": "acme"}},
+		{host: "eu.example.test", want: "tenant", params: map[string]string{"tenant": "eu"}},
+		{host: "a.b.example.test", want: "wild"},
+		{host: "a.b.c.example.test:80", want: "wild"},
+		{host: "x.eu.example.test", want: "wild-eu"},
+		{host: "a.b.eu.example.test", want: "wild-eu"},
+		{host: "127.0.0.1", want: "ipv4"},
+		{host: "127.0.0.1:1783", want: "ipv4"},
+		{host: "[::1]", want: "loopback6"},
+		{host: "[::1]:1783", want: "loopback6"},
+		{host: "[0:0::1]:1783", want: "loopback6"},
+		{host: "[2001:db8::1]:443", want: "ipv6"},
+		{host: "[2001:DB8:0::1]", want: "ipv6"},
+		{host: "example.org", want: "org"},
+		{host: "EXAMPLE.org.:8443", want: "org"},
+		{host: "other.test", want: "default"},
+		{host: "example.test.other", want: "default"},
+		{host: "xexample.test", want: "default"},
+		{host: "127.0.0.2", want: "default"},
+		{host: "[::2]:1783", want: "default"},
+		{host: "", want: "default"},
+	}
+
+	h, names := testHosts(true)
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			m, params, err := h.MatchHost(tt.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names[m] != tt.want {
+				t.Errorf("matched %q, want %q", names[m], tt.want)
+			}
+			if len(params) != len(tt.params) {
+				t.Errorf("params %v, want %v", params, tt.params)
+			}
+			for k, v := range tt.params {
+				if params[k] != v {
+					t.Errorf("param %s: %q, want %q", k, params[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchHostFallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		def     bool
+		strict  bool
+		host    string
+		want    string
+		wantErr error
+	}{
+		{name: "default", def: true, host: "other.test", want: "default"},
+		{name: "strict", def: true, strict: true, host: "other.test", wantErr: ErrMisdirectedHost},
+		{name: "strict with a port", def: true, strict: true, host: "other.test:8080", wantErr: ErrMisdirectedHost},
+		{name: "strict still serves known hosts", def: true, strict: true, host: "acme.example.test", want: "tenant"},
+		{name: "strict without a Host header", def: true, strict: true, host: "", want: "default"},
+		{name: "no default", host: "other.test", wantErr: ErrUnknownHost},
+		{name: "no default without a Host header", host: "", wantErr: ErrUnknownHost},
+		{name: "no default, strict", strict: true, host: "other.test", wantErr: ErrMisdirectedHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, names := testHosts(tt.def)
+			h.Strict = tt.strict
+			m, _, err := h.MatchHost(tt.host)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && names[m] != tt.want {
+				t.Errorf("matched %q, want %q", names[m], tt.want)
+			}
+		})
+	}
+}
+
+func TestHostPanics(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		".",
+		"example.test",
+		"EXAMPLE.test.",
+		"::1",
+		"[0::1]",
+		"a..test",
+		"a.*.test",
+		"*",
+		"*.",
+		"foo*.test",
+		":.test",
+	} {
+		t.Run(pattern, func(t *testing.T) {
+			h, _ := testHosts(true)
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Host(%q) did not panic", pattern)
+				}
+			}()
+			h.Host(pattern, NewRouter())
+		})
+	}
+}
+
+func TestHostRouterSharedRoutes(t *testing.T) {
+	def, a, b := NewRouter(), NewRouter(), NewRouter()
+	h := NewHostRouter(def)
+	h.Host("a.test", a)
+	h.Register("GET", "/metrics", nop)
+	h.Host("b.test", b)
+	h.Host("*.b.test", b) // already has the shared routes
+
+	for name, m := range map[string]*Router{"default": def, "a": a, "b": b} {
+		if _, _, err := m.Match("GET", "/metrics"); err != nil {
+			t.Errorf("%s does not serve the shared route: %v", name, err)
+		}
+	}
+	if _, _, err := h.Match("GET", "/metrics"); err != nil {
+		t.Errorf("Match on the host router: %v", err)
+	}
+}
+
+func TestHostRouterRoutes(t *testing.T) {
+	def, tenants := NewRouter(), NewRouter()
+	def.GET("/", nop)
+	tenants.GET("/dashboard", nop)
+	h := NewHostRouter(def)
+	h.Host(":tenant.example.test", tenants)
+
+	want := map[RouteInfo]bool{
+		{Method: "GET", Pattern: "/"}:                                        true,
+		{Method: "GET", Pattern: "/dashboard", Host: ":tenant.example.test"}: true,
+	}
+	routes := h.Routes()
+	if len(routes) != len(want) {
+		t.Fatalf("routes %v", routes)
+	}
+	for _, r := range routes {
+		if !want[r] {
+			t.Errorf("unexpected route %+v", r)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package router
+
+// Middleware wraps a Handler with behaviour that runs around it.
+type Middleware func(next Handler) Handler
+
+// Chain wraps h so that mws run in the order given, the first being outermost.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
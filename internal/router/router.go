@@ -2,6 +2,7 @@ package router
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/brutally-Honest/http-server/internal/request"
@@ -15,6 +16,18 @@ type RouteMatcher interface {
 	Register(method, path string, handler Handler)
 }
 
+// RouteLister is implemented by matchers that can enumerate their routes.
+type RouteLister interface {
+	Routes() []RouteInfo
+}
+
+type RouteInfo struct {
+	Method  string `json:"method"`
+	Pattern string `json:"pattern"`
+	// Host is the virtual host of the route, empty for every host.
+	Host string `json:"host,omitempty"`
+}
+
 type Node struct {
 	segement      string
 	children      map[string]*Node
@@ -130,7 +143,11 @@ func (r *Router) Register(method, path string, handler Handler) {
 	if err != nil {
 		panic(err)
 	}
-	if err := r.insert(method, segments, handler); err != nil {
+	route := func(req *request.Request, res *response.Response) {
+		req.Route = path
+		handler(req, res)
+	}
+	if err := r.insert(method, segments, route); err != nil {
 		panic(err.Error())
 	}
 }
@@ -151,6 +168,10 @@ func (r *Router) DELETE(path string, handler Handler) {
 	r.Register("DELETE", path, handler)
 }
 
+func (r *Router) HEAD(path string, handler Handler) {
+	r.Register("HEAD", path, handler)
+}
+
 func (r *Router) search(method string, segments []string) (Handler, map[string]string) {
 	curr := r.root
 	params := make(map[string]string)
@@ -200,3 +221,35 @@ func (r *Router) Match(method, path string) (Handler, map[string]string, error)
 
 	return handler, params, nil
 }
+
+// Routes returns every registered method and pattern, sorted by pattern.
+func (r *Router) Routes() []RouteInfo {
+	var routes []RouteInfo
+	r.root.walk("", &routes)
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Pattern != routes[j].Pattern {
+			return routes[i].Pattern < routes[j].Pattern
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+func (n *Node) walk(prefix string, routes *[]RouteInfo) {
+	pattern := prefix
+	if pattern == "" {
+		pattern = "/"
+	}
+	for method := range n.handlers {
+		*routes = append(*routes, RouteInfo{Method: method, Pattern: pattern})
+	}
+	for segment, child := range n.children {
+		child.walk(prefix+"/"+segment, routes)
+	}
+	if n.paramChild != nil {
+		n.paramChild.walk(prefix+"/"+n.paramChild.segement, routes)
+	}
+	if n.wildcardChild != nil {
+		n.wildcardChild.walk(prefix+"/"+n.wildcardChild.segement, routes)
+	}
+}
@@ -0,0 +1,27 @@
+package router
+
+import (
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+)
+
+// Timeout gives the handlers it wraps d to finish, replacing the server-wide
+// Config.HandlerTimeout for them; d <= 0 lifts the limit, which long-lived
+// streams such as SSE need. Past the deadline Request.Context is cancelled
+// and, if nothing was sent yet, the client gets code with body instead
+// (503 and its reason phrase when zero and nil).
+func Timeout(d time.Duration, code int, body []byte) Middleware {
+	return func(next Handler) Handler {
+		return func(req *request.Request, res *response.Response) {
+			res.SetTimeout(d, code, body)
+			next(req, res)
+		}
+	}
+}
+
+// WithTimeout limits a single handler to d with the default 503 reply.
+func WithTimeout(d time.Duration, h Handler) Handler {
+	return Timeout(d, 0, nil)(h)
+}
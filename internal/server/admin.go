@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime/pprof"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+)
+
+// newAdminServer builds the debug listener: live connections, pprof
+// profiles and the route table of s. It must never be exposed publicly.
+func (s *Server) newAdminServer() *Server {
+	r := router.NewRouter()
+	r.GET("/debug/connections", func(req *request.Request, res *response.Response) {
+		writeJSON(req, res, s.snapshotConns())
+	})
+	r.GET("/debug/routes", func(req *request.Request, res *response.Response) {
+		routes := []router.RouteInfo{}
+		if lister, ok := s.matcher.(router.RouteLister); ok {
+			routes = lister.Routes()
+		}
+		writeJSON(req, res, routes)
+	})
+	r.GET("/debug/pprof/:profile", func(req *request.Request, res *response.Response) {
+		profile := pprof.Lookup(req.Params["profile"])
+		if profile == nil {
+			res.WriteHeader(404)
+			res.Write([]byte("Unknown profile"))
+			res.Flush(req, false)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := profile.WriteTo(&buf, 0); err != nil {
+			res.WriteHeader(500)
+			res.Flush(req, false)
+			return
+		}
+		res.SetHeader("Content-Type", "application/octet-stream")
+		res.SetHeader("Content-Disposition", `attachment; filename="`+profile.Name()+`.pb.gz"`)
+		res.Write(buf.Bytes())
+		res.Flush(req, false)
+	})
+
+	cfg := *s.config
+	cfg.MetricsPath = ""
+	cfg.AdminAddr = ""
+
+	admin := NewServer(s.config.AdminAddr, &cfg, r)
+	admin.Logger = s.Logger.With("listener", "admin")
+	return admin
+}
+
+func writeJSON(req *request.Request, res *response.Response, v any) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		res.WriteHeader(500)
+		res.Flush(req, false)
+		return
+	}
+	res.SetHeader("Content-Type", "application/json")
+	res.Write(append(body, '\n'))
+	res.Flush(req, false)
+}
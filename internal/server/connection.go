@@ -2,24 +2,131 @@ package server
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
 	"net"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/http2"
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/proxyproto"
+	"github.com/brutally-Honest/http-server/internal/request"
 )
 
 func (s *Server) handleConnection(conn net.Conn) {
+	s.setConnState(conn, StateNew)
+	// read first, so everything after sees the client's real address
+	proxyHeader, err := readProxyHeader(conn)
+	if err != nil {
+		s.Logger.Debug("proxy protocol header rejected", "peer", conn.RemoteAddr().String(), "error", err)
+		conn.Close()
+		s.setConnState(conn, StateClosed)
+		return
+	}
+	if s.OnAccept != nil && !s.OnAccept(conn) {
+		conn.Close()
+		s.setConnState(conn, StateClosed)
+		return
+	}
+
+	tc := s.track(conn)
+	defer s.untrack(tc)
+	tc.proxyHeader = proxyHeader
+
+	log := s.Logger.With(
+		"conn_id", tc.id,
+		"remote_addr", conn.RemoteAddr().String(),
+	)
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("panic in handleConnection: %v", r)
+			log.Error("panic in handleConnection", "panic", r)
 			conn.Close()
+			s.setConnState(conn, StateClosed)
 		}
 	}()
 	ctx, cancelConn := context.WithCancel(context.Background())
 	defer cancelConn()
 
+	s.metrics.connOpened()
+	defer s.metrics.connClosed()
+
+	parser := request.NewParser(tc, s.config, log)
+	parser.OnPhase = func(p request.Phase) {
+		switch p {
+		case request.PhaseHeaders:
+			tc.setPhase(phaseReadingHeaders)
+			s.setConnState(conn, StateActive)
+		case request.PhaseBody:
+			tc.setPhase(phaseReadingBody)
+		}
+	}
+
+	log.Debug("connection opened")
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if !s.handshake(tc, tlsConn, ctx, log) {
+			conn.Close()
+			s.setConnState(conn, StateClosed)
+			return
+		}
+		if tc.tls.NegotiatedProtocol == "h2" {
+			s.setConnState(conn, StateActive)
+			if !http2.AdequateTLS(tc.tls) {
+				log.Debug("http2 refused on a blocklisted cipher suite", "cipher", tls.CipherSuiteName(tc.tls.CipherSuite))
+				conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+				http2.RejectConn(conn, http2.ErrCodeInadequateSecurity, "cipher suite not allowed for HTTP/2")
+			} else {
+				s.serveHTTP2(tc, ctx, log, nil, nil)
+			}
+			log.Debug("connection closed")
+			conn.Close()
+			s.setConnState(conn, StateClosed)
+			return
+		}
+	}
+
 	for {
-		if handleRequest(conn, s, ctx) {
+		closeConn := handleRequest(tc, parser, s, ctx, log)
+		if tc.hijacked.Load() {
+			log.Debug("connection hijacked")
+			s.setConnState(conn, StateHijacked)
+			return
+		}
+		// no keep-alive once the server is shutting down
+		if closeConn || s.shuttingDown() {
+			log.Debug("connection closed")
 			conn.Close()
+			s.setConnState(conn, StateClosed)
 			return
 		}
+		s.setConnState(conn, StateIdle)
+	}
+}
+
+// handshake completes the TLS handshake within ReadTimeout and records the
+// negotiated state on conn.
+func (s *Server) handshake(conn *trackedConn, tlsConn *tls.Conn, ctx context.Context, log logger.Logger) bool {
+	tlsConn.SetDeadline(time.Now().Add(s.config.ReadTimeout))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		log.Debug("tls handshake failed", "error", err)
+		return false
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	state := tlsConn.ConnectionState()
+	conn.tls = &state
+	log.Debug("tls handshake done", "version", tls.VersionName(state.Version), "alpn", state.NegotiatedProtocol)
+	return true
+}
+
+// readProxyHeader reads the PROXY protocol header of a connection accepted
+// through a proxyproto.Listener from a trusted source, below TLS if need
+// be. Other connections have none.
+func readProxyHeader(conn net.Conn) (*proxyproto.Header, error) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	pc, ok := conn.(*proxyproto.Conn)
+	if !ok {
+		return nil, nil
 	}
+	return pc.Header()
 }
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/proxyproto"
+)
+
+type connPhase int32
+
+const (
+	phaseIdle connPhase = iota
+	phaseReadingHeaders
+	phaseReadingBody
+	phaseInHandler
+	phaseWriting
+	phaseHTTP2
+)
+
+func (p connPhase) String() string {
+	switch p {
+	case phaseIdle:
+		return "idle"
+	case phaseReadingHeaders:
+		return "reading headers"
+	case phaseReadingBody:
+		return "reading body"
+	case phaseInHandler:
+		return "in handler"
+	case phaseWriting:
+		return "writing"
+	case phaseHTTP2:
+		return "http2"
+	default:
+		return "unknown"
+	}
+}
+
+// trackedConn wraps an accepted connection with the bookkeeping exposed on
+// the admin listener. Writes made while a handler runs flip it to "writing".
+type trackedConn struct {
+	net.Conn
+	id       uint64
+	accepted time.Time
+	requests atomic.Int64
+	phase    atomic.Int32
+	since    atomic.Int64 // unix nanos of the last phase change
+
+	// hijacked is set when a handler takes the connection over, possibly
+	// from a goroutine of its own.
+	hijacked atomic.Bool
+	// tls is the handshake state of a TLS connection, nil in cleartext.
+	tls *tls.ConnectionState
+	// proxyHeader is what the load balancer said about the connection,
+	// nil without the PROXY protocol.
+	proxyHeader *proxyproto.Header
+}
+
+func (c *trackedConn) setPhase(p connPhase) {
+	c.phase.Store(int32(p))
+	c.since.Store(time.Now().UnixNano())
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	if c.phase.CompareAndSwap(int32(phaseInHandler), int32(phaseWriting)) {
+		c.since.Store(time.Now().UnixNano())
+	}
+	return c.Conn.Write(b)
+}
+
+// NetConn exposes the accepted socket, letting the response use sendfile.
+func (c *trackedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+type connSnapshot struct {
+	ID         uint64  `json:"id"`
+	RemoteAddr string  `json:"remote_addr"`
+	AgeSeconds float64 `json:"age_seconds"`
+	Requests   int64   `json:"requests"`
+	State      string  `json:"state"`
+	StateFor   float64 `json:"state_seconds"`
+}
+
+func (s *Server) track(conn net.Conn) *trackedConn {
+	tc := &trackedConn{
+		Conn:     conn,
+		id:       s.connSeq.Add(1),
+		accepted: time.Now(),
+	}
+	tc.setPhase(phaseIdle)
+
+	s.mu.Lock()
+	if s.conns == nil {
+		s.conns = make(map[uint64]*trackedConn)
+	}
+	s.conns[tc.id] = tc
+	s.mu.Unlock()
+	return tc
+}
+
+func (s *Server) untrack(tc *trackedConn) {
+	s.mu.Lock()
+	delete(s.conns, tc.id)
+	s.mu.Unlock()
+}
+
+// closeIdleConns closes the connections waiting for a request and reports
+// whether none are left at all.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tc := range s.conns {
+		if connPhase(tc.phase.Load()) == phaseIdle {
+			tc.Close()
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tc := range s.conns {
+		tc.Close()
+	}
+}
+
+func (s *Server) snapshotConns() []connSnapshot {
+	now := time.Now()
+
+	s.mu.Lock()
+	out := make([]connSnapshot, 0, len(s.conns))
+	for _, tc := range s.conns {
+		out = append(out, connSnapshot{
+			ID:         tc.id,
+			RemoteAddr: tc.RemoteAddr().String(),
+			AgeSeconds: now.Sub(tc.accepted).Seconds(),
+			Requests:   tc.requests.Load(),
+			State:      connPhase(tc.phase.Load()).String(),
+			StateFor:   now.Sub(time.Unix(0, tc.since.Load())).Seconds(),
+		})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
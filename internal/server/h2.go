@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/brutally-Honest/http-server/internal/http2"
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+)
+
+// serveHTTP2 hands the connection to the HTTP/2 engine. Its streams go
+// through the same middleware and routes as HTTP/1 requests.
+func (s *Server) serveHTTP2(conn *trackedConn, ctx context.Context, log logger.Logger, buffered []byte, upgrade *request.Request) {
+	conn.setPhase(phaseHTTP2)
+	log = log.With("proto", "HTTP/2.0")
+	log.Debug("serving http2")
+
+	h2 := &http2.Server{
+		Config:   s.config,
+		Logger:   log,
+		Shutdown: s.shutdown,
+		Handler: func(req *request.Request, stream response.Stream) {
+			reqCtx, cancelReq := context.WithCancel(req.Context)
+			defer cancelReq()
+
+			req.Context = reqCtx
+			req.TLS = conn.tls
+			req.ProxyHeader = conn.proxyHeader
+			req.RemoteAddr = conn.RemoteAddr().String()
+			req.TrustedProxies = s.config.TrustedProxies
+			req.TrustForwarded = s.config.TrustForwarded
+			// behind a trusted proxy the peer is the proxy; log the client too
+			req.Logger = log.With("method", req.Method, "path", req.Path, "real_ip", req.RealIP())
+			conn.requests.Add(1)
+
+			res := response.NewStreamResponse(req, ctx, reqCtx, conn, s.config, stream)
+			s.serve(conn, req, res, cancelReq)
+		},
+	}
+
+	if err := h2.ServeConn(ctx, conn, buffered, upgrade); err != nil && !errors.Is(err, io.EOF) {
+		log.Debug("http2 connection ended", "error", err)
+	}
+}
+
+// upgradeH2C answers an Upgrade: h2c request with 101 and continues the
+// connection as HTTP/2, where the request itself is served as stream 1.
+func (s *Server) upgradeH2C(conn *trackedConn, parser *request.Parser, req *request.Request, ctx context.Context, log logger.Logger) {
+	res := response.NewResponseWithContext(101, req, ctx, nil, conn, s.config)
+	res.SetHeader("Connection", "Upgrade")
+	res.SetHeader("Upgrade", "h2c")
+	if err := res.Finish(); err != nil {
+		log.Debug("h2c upgrade failed", "error", err)
+		return
+	}
+	s.serveHTTP2(conn, ctx, log, parser.Buffered(), req)
+}
+
+// h2c reports whether conn may switch to cleartext HTTP/2. Over TLS the
+// protocol is settled by ALPN during the handshake instead.
+func (s *Server) h2c(conn *trackedConn) bool {
+	return s.config.H2C && conn.tls == nil
+}
@@ -2,19 +2,32 @@ package server
 
 import (
 	"context"
-	"log"
+	"errors"
 	"net"
-	"strings"
+	"time"
 
+	"github.com/brutally-Honest/http-server/internal/http2"
+	"github.com/brutally-Honest/http-server/internal/logger"
 	"github.com/brutally-Honest/http-server/internal/request"
 	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
 )
 
-func handleRequest(conn net.Conn, s *Server, ctx context.Context) bool {
-	req, reqErr := request.ParseRequest(conn, s.config)
+func handleRequest(conn *trackedConn, parser *request.Parser, s *Server, ctx context.Context, log logger.Logger) bool {
+	defer conn.setPhase(phaseIdle)
+
+	req, reqErr := parser.Parse()
 	if reqErr != nil {
-		log.Println("parse error: ", reqErr.Error())
-		res := response.NewResponseWithContext(400, ctx, nil, conn, s.config)
+		if errors.Is(reqErr, request.ErrConnectionClosed) {
+			return true // client went away between requests
+		}
+		if errors.Is(reqErr, request.ErrHTTP2Preface) && s.h2c(conn) {
+			s.serveHTTP2(conn, ctx, log, parser.Buffered(), nil)
+			return true
+		}
+		log.Warn("parse error", "error", reqErr)
+		s.metrics.parseError(reqErr)
+		res := response.NewResponseWithContext(400, nil, ctx, nil, conn, s.config)
 		res.Write([]byte("Bad Request"))
 		res.Flush(nil, true)
 		return true
@@ -22,24 +35,113 @@ func handleRequest(conn net.Conn, s *Server, ctx context.Context) bool {
 	reqCtx, cancelReq := context.WithCancel(ctx)
 	defer cancelReq()
 
-	handler, params, err := s.matcher.Match(req.Method, req.Path)
-	if err != nil {
-		log.Println("router error: ", err.Error())
-		res := response.NewResponseWithContext(404, ctx, reqCtx, conn, s.config)
-		res.Write([]byte("Not Found"))
-		res.Flush(req, false)
-		return strings.ToLower(req.Headers["Connection"]) == "close"
+	req.Context = reqCtx
+	req.TLS = conn.tls
+	req.ProxyHeader = conn.proxyHeader
+	req.RemoteAddr = conn.RemoteAddr().String()
+	req.TrustedProxies = s.config.TrustedProxies
+	req.TrustForwarded = s.config.TrustForwarded
+	// behind a trusted proxy the peer is the proxy; log the client too
+	req.Logger = log.With("method", req.Method, "path", req.Path, "real_ip", req.RealIP())
+
+	if s.h2c(conn) && http2.IsUpgrade(req) {
+		s.upgradeH2C(conn, parser, req, ctx, log)
+		return true
 	}
 
-	req.Params = params
-	req.Context = reqCtx
+	// cancel the request if the client goes away while the handler runs
+	watchLog := req.Logger
+	parser.Watch(func() {
+		watchLog.Debug("client disconnected during request")
+		cancelReq()
+	})
+	defer parser.StopWatch()
 
-	res := response.NewResponseWithContext(200, ctx, reqCtx, conn, s.config)
-	handler(req, res)
+	conn.requests.Add(1)
+	conn.setPhase(phaseInHandler)
+
+	res := response.NewResponseWithContext(200, req, ctx, reqCtx, conn, s.config)
+	res.SetCloseSignal(s.shutdown)
+	res.SetHijacker(func() (net.Conn, []byte, error) {
+		parser.StopWatch()
+		conn.hijacked.Store(true)
+		return conn.Conn, parser.Buffered(), nil
+	})
+	s.serve(conn, req, res, cancelReq)
+
+	if conn.hijacked.Load() {
+		return true // the handler owns the connection now
+	}
 
 	if res.HasError() {
+		req.Logger.Debug("response write failed", "error", res.Err())
 		return true // write errors
 	}
 
-	return strings.ToLower(req.Headers["Connection"]) == "close"
+	return res.ShouldClose()
+}
+
+// serve runs the middleware chain and the matched route for req, then
+// finishes the response and reports it. It is shared by HTTP/1 requests
+// and HTTP/2 streams.
+func (s *Server) serve(conn *trackedConn, req *request.Request, res *response.Response, cancelReq context.CancelFunc) {
+	res.OnTimeout(cancelReq)
+	res.SetTimeout(s.config.HandlerTimeout, 0, nil)
+
+	start := time.Now()
+	router.Chain(s.route, s.middlewareChain()...)(req, res)
+	res.HandlerReturned()
+	res.Finish()
+	// logged only now, as middleware such as RequestID tag req.Logger
+	// inside the chain, while it may still be running when time runs out
+	if res.TimedOut() {
+		req.Logger.Warn("handler timed out", "elapsed", time.Since(start))
+	}
+	s.metrics.observeRequest(req, res, time.Since(start))
+
+	if s.OnRequestComplete != nil {
+		s.OnRequestComplete(conn.Conn, req, res)
+	}
+}
+
+// route dispatches the request to the matched handler, answering 404 itself
+// so unmatched requests still pass through the middleware chain. A
+// router.HostMatcher picks the matcher for the request's host first.
+func (s *Server) route(req *request.Request, res *response.Response) {
+	matcher := s.matcher
+	var hostParams map[string]string
+	if hm, ok := matcher.(router.HostMatcher); ok {
+		var err error
+		matcher, hostParams, err = hm.MatchHost(req.Host())
+		if err != nil {
+			req.Logger.Debug("host router error", "host", req.Host(), "error", err)
+			code := 404
+			if errors.Is(err, router.ErrMisdirectedHost) {
+				code = 421
+			}
+			res.WriteHeader(code)
+			res.WriteString(response.StatusText(code))
+			return
+		}
+	}
+
+	handler, params, err := matcher.Match(req.Method, req.Path)
+	if err != nil {
+		req.Logger.Debug("router error", "error", err)
+		res.WriteHeader(404)
+		res.Write([]byte("Not Found"))
+		return
+	}
+
+	// path params win over host params of the same name
+	for k, v := range hostParams {
+		if params == nil {
+			params = make(map[string]string)
+		}
+		if _, ok := params[k]; !ok {
+			params[k] = v
+		}
+	}
+	req.Params = params
+	handler(req, res)
 }
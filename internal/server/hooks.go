@@ -0,0 +1,44 @@
+package server
+
+import "net"
+
+// ConnState describes where a connection is in its lifecycle, as reported
+// to Server.ConnState.
+type ConnState int
+
+const (
+	// StateNew is a freshly accepted connection, before OnAccept runs.
+	StateNew ConnState = iota
+	// StateActive means bytes of a request have arrived; it lasts until the
+	// response is finished.
+	StateActive
+	// StateIdle is a keep-alive connection waiting for its next request.
+	StateIdle
+	// StateHijacked is terminal: a handler took over the connection.
+	StateHijacked
+	// StateClosed is terminal: the server closed the connection.
+	StateClosed
+)
+
+func (c ConnState) String() string {
+	switch c {
+	case StateNew:
+		return "new"
+	case StateActive:
+		return "active"
+	case StateIdle:
+		return "idle"
+	case StateHijacked:
+		return "hijacked"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+func (s *Server) setConnState(conn net.Conn, state ConnState) {
+	if s.ConnState != nil {
+		s.ConnState(conn, state)
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/metrics"
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+)
+
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	activeConns   *metrics.Gauge
+	totalConns    *metrics.Counter
+	requests      *metrics.Counter
+	duration      *metrics.Histogram
+	bytesIn       *metrics.Counter
+	bytesOut      *metrics.Counter
+	parseFailures *metrics.Counter
+}
+
+func newServerMetrics() *serverMetrics {
+	reg := metrics.NewRegistry()
+	return &serverMetrics{
+		registry: reg,
+		activeConns: reg.NewGauge("http_active_connections",
+			"Connections currently open."),
+		totalConns: reg.NewCounter("http_connections_total",
+			"Connections accepted."),
+		requests: reg.NewCounter("http_requests_total",
+			"Requests served, by route pattern, method and status.",
+			"route", "method", "status"),
+		duration: reg.NewHistogram("http_request_duration_seconds",
+			"Time spent in the handler chain.",
+			metrics.DefBuckets, "route", "method"),
+		bytesIn: reg.NewCounter("http_request_bytes_total",
+			"Request bytes read, including request line and headers."),
+		bytesOut: reg.NewCounter("http_response_bytes_total",
+			"Response body bytes written."),
+		parseFailures: reg.NewCounter("http_parse_errors_total",
+			"Requests rejected before routing, by error type.",
+			"type"),
+	}
+}
+
+func (m *serverMetrics) connOpened() {
+	m.totalConns.Inc()
+	m.activeConns.Inc()
+}
+
+func (m *serverMetrics) connClosed() {
+	m.activeConns.Dec()
+}
+
+func (m *serverMetrics) observeRequest(req *request.Request, res *response.Response, elapsed time.Duration) {
+	route := req.Route
+	if route == "" {
+		route = "unmatched"
+	}
+	m.requests.Inc(route, req.Method, strconv.Itoa(res.Status()))
+	m.duration.Observe(elapsed.Seconds(), route, req.Method)
+	m.bytesIn.Add(float64(req.BytesRead))
+	m.bytesOut.Add(float64(res.BytesWritten()))
+}
+
+func (m *serverMetrics) parseError(err error) {
+	m.parseFailures.Inc(parseErrorType(err))
+}
+
+func parseErrorType(err error) string {
+	var ne net.Error
+	switch {
+	case errors.Is(err, request.ErrHeaderLimitExceeded):
+		return "header_limit"
+	case errors.Is(err, request.ErrBodyLimitExceeded):
+		return "body_limit"
+	case errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	default:
+		return "malformed"
+	}
+}
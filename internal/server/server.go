@@ -1,43 +1,280 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/metrics"
+	"github.com/brutally-Honest/http-server/internal/proxyproto"
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
 	"github.com/brutally-Honest/http-server/internal/router"
 )
 
+// ErrServerClosed is returned by Serve and its variants once Shutdown has
+// been called.
+var ErrServerClosed = errors.New("server closed")
+
+// shutdownPollInterval is how often Shutdown looks for connections that
+// went idle.
+const shutdownPollInterval = 200 * time.Millisecond
+
 type Server struct {
-	Addr     string
-	listener net.Listener
-	running  bool
-	mu       sync.Mutex
-	config   *config.Config
-	matcher  router.RouteMatcher
+	Addr   string
+	Logger logger.Logger
+
+	// TLSConfig, when set, is the base of the configuration used by
+	// ListenAndServeTLS and ServeTLS. Leave NextProtos empty to offer "h2"
+	// and "http/1.1" through ALPN.
+	TLSConfig *tls.Config
+
+	// ConnState, when set, is called on every connection state transition.
+	ConnState func(net.Conn, ConnState)
+	// OnAccept, when set, decides whether a new connection is served;
+	// returning false closes it straight away.
+	OnAccept func(net.Conn) bool
+	// OnRequestComplete, when set, runs after the handler chain returns.
+	OnRequestComplete func(net.Conn, *request.Request, *response.Response)
+
+	listener   net.Listener
+	running    bool
+	mu         sync.Mutex
+	config     *config.Config
+	matcher    router.RouteMatcher
+	middleware []router.Middleware
+	connSeq    atomic.Uint64
+	conns      map[uint64]*trackedConn
+	metrics    *serverMetrics
+	admin      *Server
+
+	// shutdown is closed when Shutdown starts; HTTP/2 connections watch it
+	// to send GOAWAY.
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
 }
 
 func NewServer(Addr string, config *config.Config, router router.RouteMatcher) *Server {
-	return &Server{
-		Addr:    Addr,
-		config:  config,
-		matcher: router,
+	s := &Server{
+		Addr:     Addr,
+		Logger:   logger.New(os.Stderr, slog.LevelInfo),
+		config:   config,
+		matcher:  router,
+		metrics:  newServerMetrics(),
+		shutdown: make(chan struct{}),
 	}
+	if config.MetricsPath != "" {
+		router.Register("GET", config.MetricsPath, metrics.Handler(s.metrics.registry))
+	}
+	return s
+}
+
+// Metrics returns the registry the server reports into, so applications can
+// register their own metrics alongside the built-in ones.
+func (s *Server) Metrics() *metrics.Registry {
+	return s.metrics.registry
+}
+
+// Use appends middleware that wraps every request, including unmatched ones.
+func (s *Server) Use(mws ...router.Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, mws...)
+}
+
+// middlewareChain snapshots the middleware for one request, so Use may be
+// called while the server is running.
+func (s *Server) middlewareChain() []router.Middleware {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.middleware[:len(s.middleware):len(s.middleware)]
 }
 
 func (s *Server) ListenAndServe() error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	if err := s.startAdmin(); err != nil {
+		listener.Close()
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// ListenAndServeTLS is ListenAndServe over TLS. certFile and keyFile hold
+// a PEM certificate and key; both may be empty when TLSConfig already
+// provides certificates.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	if err := s.startAdmin(); err != nil {
+		listener.Close()
+		return err
+	}
+	return s.ServeTLS(listener, certFile, keyFile)
+}
+
+// listen opens the TCP listener on Addr, reading PROXY protocol headers
+// off it when the config asks for them.
+func (s *Server) listen() (net.Listener, error) {
 	listener, err := net.Listen("tcp", s.Addr)
 	if err != nil {
-		return fmt.Errorf("listening Socket Error : %v", err)
+		return nil, fmt.Errorf("listening Socket Error : %v", err)
+	}
+	if !s.config.ProxyProtocol {
+		return listener, nil
+	}
+	timeout := s.config.ProxyHeaderTimeout
+	if timeout <= 0 {
+		timeout = s.config.ReadTimeout
+	}
+	return &proxyproto.Listener{
+		Listener:      listener,
+		Trusted:       s.config.ProxyTrusted,
+		HeaderTimeout: timeout,
+	}, nil
+}
+
+func (s *Server) startAdmin() error {
+	if s.config.AdminAddr == "" {
+		return nil
+	}
+	admin := s.newAdminServer()
+	adminListener, err := net.Listen("tcp", admin.Addr)
+	if err != nil {
+		return fmt.Errorf("admin listening Socket Error : %v", err)
+	}
+
+	s.mu.Lock()
+	s.admin = admin
+	s.mu.Unlock()
+	go func() {
+		if err := admin.Serve(adminListener); err != nil && !errors.Is(err, ErrServerClosed) {
+			admin.Logger.Error("admin listener stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Serve accepts connections on listener until Accept fails or Shutdown
+// is called.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.shuttingDown() {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
 	}
+	s.listener = listener
+	s.running = true
+	s.mu.Unlock()
+
+	s.Logger.Info("server listening", "addr", listener.Addr().String())
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
 			return fmt.Errorf("connection Error : %v", err)
 		}
 		go s.handleConnection(conn)
 	}
 }
 
-// TODO: implement shutdown
+// ServeTLS is Serve over TLS. ALPN decides the protocol of each
+// connection: "h2" goes to the HTTP/2 engine, anything else is served as
+// HTTP/1.1. h2c is never accepted over TLS.
+func (s *Server) ServeTLS(listener net.Listener, certFile, keyFile string) error {
+	cfg, err := s.tlsConfig(certFile, keyFile)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	return s.Serve(tls.NewListener(listener, cfg))
+}
+
+func (s *Server) tlsConfig(certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if s.TLSConfig != nil {
+		cfg = s.TLSConfig.Clone()
+	}
+	// HTTP/2 over TLS requires TLS 1.2 or later (RFC 9113 section 9.2)
+	if cfg.MinVersion < tls.VersionTLS12 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS certificate: %v", err)
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+	if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && cfg.GetConfigForClient == nil {
+		return nil, errors.New("TLS needs a certificate")
+	}
+	return cfg, nil
+}
+
+// Shutdown stops the server gracefully. The listener is closed, idle
+// connections are closed, HTTP/1 connections close after the response in
+// flight and HTTP/2 connections send GOAWAY and close once their open
+// streams are answered. Hijacked connections are not waited for. If ctx
+// ends first, the remaining connections are closed and ctx.Err returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+
+	s.mu.Lock()
+	s.running = false
+	var err error
+	if s.listener != nil {
+		if cerr := s.listener.Close(); cerr != nil && !errors.Is(cerr, net.ErrClosed) {
+			err = cerr
+		}
+	}
+	admin := s.admin
+	s.mu.Unlock()
+
+	if admin != nil {
+		defer admin.Shutdown(ctx)
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.closeConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	select {
+	case <-s.shutdown:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,1017 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/http2"
+	"github.com/brutally-Honest/http-server/internal/http2/hpack"
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/metrics"
+	"github.com/brutally-Honest/http-server/internal/middleware"
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+)
+
+// testServer serves r on a loopback port; serveErr receives what Serve
+// returned.
+type testServer struct {
+	*Server
+	addr     string
+	serveErr chan error
+}
+
+func newTestServer(t *testing.T, r router.RouteMatcher, tlsConfig *tls.Config) *testServer {
+	t.Helper()
+	return newTestServerWith(t, r, tlsConfig, nil)
+}
+
+// newTestServerWith is newTestServer with configure run on the server
+// before it starts serving.
+func newTestServerWith(t *testing.T, r router.RouteMatcher, tlsConfig *tls.Config, configure func(*Server)) *testServer {
+	t.Helper()
+	cfg := config.Load(4096, 1<<20, 8192, 5*time.Second, 5*time.Second)
+	cfg.H2C = true
+	s := NewServer("127.0.0.1:0", cfg, r)
+	s.Logger = logger.Discard()
+	s.TLSConfig = tlsConfig
+	if configure != nil {
+		configure(s)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{Server: s, addr: ln.Addr().String(), serveErr: make(chan error, 1)}
+	go func() {
+		if tlsConfig != nil {
+			ts.serveErr <- s.ServeTLS(ln, "", "")
+		} else {
+			ts.serveErr <- s.Serve(ln)
+		}
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return ts
+}
+
+// shutdown starts Shutdown and waits until the server is shutting down.
+func (ts *testServer) shutdown(t *testing.T) <-chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- ts.Shutdown(ctx)
+	}()
+	for !ts.shuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+	return done
+}
+
+// readResponse reads an HTTP/1.1 response with a Content-Length body.
+func readResponse(t *testing.T, br *bufio.Reader) (status string, headers map[string]string, body string) {
+	t.Helper()
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading status line: %v", err)
+	}
+	status = strings.TrimSpace(line)
+	headers = map[string]string{}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading headers: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		k, v, _ := strings.Cut(line, ":")
+		headers[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	n := 0
+	for _, c := range headers["content-length"] {
+		n = n*10 + int(c-'0')
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	return status, headers, string(b)
+}
+
+// blockingRouter answers /block once release is closed and /fast at once.
+func blockingRouter(release <-chan struct{}, started chan<- struct{}) *router.Router {
+	r := router.NewRouter()
+	r.GET("/block", func(req *request.Request, res *response.Response) {
+		started <- struct{}{}
+		<-release
+		res.WriteString("released")
+	})
+	r.GET("/fast", func(req *request.Request, res *response.Response) {
+		res.WriteString("fast")
+	})
+	return r
+}
+
+func TestShutdownClosesKeepAliveConnection(t *testing.T) {
+	release, started := make(chan struct{}), make(chan struct{}, 1)
+	ts := newTestServer(t, blockingRouter(release, started), nil)
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	io.WriteString(conn, "GET /block HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+
+	done := ts.shutdown(t)
+	close(release)
+
+	status, headers, body := readResponse(t, br)
+	if status != "HTTP/1.1 200 OK" || body != "released" {
+		t.Errorf("got %s %q", status, body)
+	}
+	if headers["connection"] != "close" {
+		t.Errorf("Connection: %q, want close during shutdown", headers["connection"])
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := br.ReadByte(); !errors.Is(err, io.EOF) {
+		t.Errorf("connection not closed after the response: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if err := <-ts.serveErr; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve returned %v, want ErrServerClosed", err)
+	}
+}
+
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	ts := newTestServer(t, blockingRouter(nil, nil), nil)
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	io.WriteString(conn, "GET /fast HTTP/1.1\r\nHost: x\r\n\r\n")
+	if _, headers, _ := readResponse(t, br); headers["connection"] != "keep-alive" {
+		t.Fatalf("Connection: %q before shutdown", headers["connection"])
+	}
+
+	done := ts.shutdown(t)
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := br.ReadByte(); !errors.Is(err, io.EOF) {
+		t.Errorf("idle connection not closed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if _, err := net.Dial("tcp", ts.addr); err == nil {
+		t.Error("listener still accepting")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	release, started := make(chan struct{}), make(chan struct{}, 1)
+	defer close(release)
+	ts := newTestServer(t, blockingRouter(release, started), nil)
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /block HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := ts.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown returned %v, want DeadlineExceeded", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("connection still open after the deadline")
+	}
+}
+
+// h2Conn is a minimal HTTP/2 client over conn.
+type h2Conn struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+type h2Frame struct {
+	typ, flags byte
+	streamID   uint32
+	payload    []byte
+}
+
+func (c *h2Conn) write(typ, flags byte, streamID uint32, payload []byte) {
+	c.t.Helper()
+	n := len(payload)
+	b := []byte{byte(n >> 16), byte(n >> 8), byte(n), typ, flags}
+	b = binary.BigEndian.AppendUint32(b, streamID)
+	if _, err := c.conn.Write(append(b, payload...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *h2Conn) read() (h2Frame, error) {
+	var hdr [9]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return h2Frame{}, err
+	}
+	f := h2Frame{typ: hdr[3], flags: hdr[4], streamID: binary.BigEndian.Uint32(hdr[5:]) & (1<<31 - 1)}
+	f.payload = make([]byte, int(hdr[0])<<16|int(hdr[1])<<8|int(hdr[2]))
+	_, err := io.ReadFull(c.br, f.payload)
+	return f, err
+}
+
+// expect reads frames until one of type typ.
+func (c *h2Conn) expect(typ byte) h2Frame {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		f, err := c.read()
+		if err != nil {
+			c.t.Fatalf("waiting for frame type %d: %v", typ, err)
+		}
+		if f.typ == typ {
+			return f
+		}
+	}
+}
+
+func (c *h2Conn) start() {
+	c.t.Helper()
+	io.WriteString(c.conn, http2.ClientPreface)
+	c.write(0x4, 0, 0, nil) // SETTINGS
+}
+
+func (c *h2Conn) get(id uint32, path string) {
+	var enc hpack.Encoder
+	var block []byte
+	for _, f := range []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: path},
+		{Name: ":authority", Value: "x"},
+	} {
+		block = enc.AppendField(block, f)
+	}
+	c.write(0x1, 0x4|0x1, id, block) // HEADERS, END_HEADERS|END_STREAM
+}
+
+func TestShutdownDrainsHTTP2(t *testing.T) {
+	release, started := make(chan struct{}), make(chan struct{}, 1)
+	ts := newTestServer(t, blockingRouter(release, started), nil)
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &h2Conn{t: t, conn: conn, br: bufio.NewReader(conn)}
+	c.start()
+	c.get(1, "/block")
+	<-started
+
+	done := ts.shutdown(t)
+	f := c.expect(0x7) // GOAWAY
+	if last, code := binary.BigEndian.Uint32(f.payload), binary.BigEndian.Uint32(f.payload[4:]); last != 1 || code != 0 {
+		t.Errorf("GOAWAY last stream %d code %d, want 1 and NO_ERROR", last, code)
+	}
+
+	// the stream in flight still gets its answer
+	close(release)
+	data := c.expect(0x0)
+	for data.flags&0x1 == 0 && len(data.payload) == 0 {
+		data = c.expect(0x0)
+	}
+	if string(data.payload) != "released" {
+		t.Errorf("stream 1 body %q", data.payload)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		if _, err := c.read(); err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Errorf("connection ended with %v, want EOF", err)
+			}
+			break
+		}
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+// selfSigned makes a certificate for 127.0.0.1.
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestALPNDispatch(t *testing.T) {
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{selfSigned(t)},
+		// the blocklisted suite is allowed so the h2 check has work to do
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA},
+	}
+	ts := newTestServer(t, blockingRouter(nil, nil), cfg)
+
+	dial := func(t *testing.T, c *tls.Config) *tls.Conn {
+		t.Helper()
+		c.InsecureSkipVerify = true
+		conn, err := tls.Dial("tcp", ts.addr, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	for _, protos := range [][]string{{"http/1.1"}, nil} {
+		t.Run("HTTP/1.1 with ALPN "+strings.Join(protos, ","), func(t *testing.T) {
+			conn := dial(t, &tls.Config{NextProtos: protos})
+			io.WriteString(conn, "GET /fast HTTP/1.1\r\nHost: x\r\n\r\n")
+			if status, _, body := readResponse(t, bufio.NewReader(conn)); status != "HTTP/1.1 200 OK" || body != "fast" {
+				t.Errorf("got %s %q", status, body)
+			}
+		})
+	}
+
+	t.Run("h2", func(t *testing.T) {
+		conn := dial(t, &tls.Config{NextProtos: []string{"h2", "http/1.1"}})
+		if p := conn.ConnectionState().NegotiatedProtocol; p != "h2" {
+			t.Fatalf("negotiated %q", p)
+		}
+		c := &h2Conn{t: t, conn: conn, br: bufio.NewReader(conn)}
+		c.start()
+		c.get(1, "/fast")
+		if data := c.expect(0x0); string(data.payload) != "fast" {
+			t.Errorf("body %q", data.payload)
+		}
+	})
+
+	t.Run("h2 is refused on a blocklisted cipher", func(t *testing.T) {
+		conn := dial(t, &tls.Config{
+			NextProtos:   []string{"h2"},
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA},
+		})
+		// the server answers before reading the preface, so none is sent
+		c := &h2Conn{t: t, conn: conn, br: bufio.NewReader(conn)}
+		f := c.expect(0x7)
+		if code := http2.ErrCode(binary.BigEndian.Uint32(f.payload[4:])); code != http2.ErrCodeInadequateSecurity {
+			t.Errorf("GOAWAY %v, want INADEQUATE_SECURITY", code)
+		}
+	})
+
+	t.Run("h2 over TLS 1.2 with an AEAD cipher", func(t *testing.T) {
+		conn := dial(t, &tls.Config{NextProtos: []string{"h2"}, MaxVersion: tls.VersionTLS12})
+		c := &h2Conn{t: t, conn: conn, br: bufio.NewReader(conn)}
+		c.start()
+		c.get(1, "/fast")
+		if data := c.expect(0x0); string(data.payload) != "fast" {
+			t.Errorf("body %q", data.payload)
+		}
+	})
+}
+
+func TestUseWhileServing(t *testing.T) {
+	ts := newTestServer(t, blockingRouter(nil, nil), nil)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				ts.Use(func(next router.Handler) router.Handler { return next })
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for i := 0; i < 20; i++ {
+		io.WriteString(conn, "GET /fast HTTP/1.1\r\nHost: x\r\n\r\n")
+		if _, _, body := readResponse(t, br); body != "fast" {
+			t.Fatalf("got %q", body)
+		}
+	}
+}
+
+func TestHostRouting(t *testing.T) {
+	def, tenants, users := router.NewRouter(), router.NewRouter(), router.NewRouter()
+	echo := func(req *request.Request, res *response.Response) {
+		keys := make([]string, 0, len(req.Params))
+		for k := range req.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(res, "%s=%s;", k, req.Params[k])
+		}
+	}
+	def.GET("/", func(req *request.Request, res *response.Response) { res.WriteString("default") })
+	tenants.GET("/users/:id", echo)
+	users.GET("/users/:id", echo)
+
+	hosts := router.NewHostRouter(def)
+	hosts.Host(":tenant.example.test", tenants)
+	hosts.Host(":id.users.test", users)
+	hosts.Host("[::1]", tenants)
+
+	tests := []struct {
+		name   string
+		strict bool
+		req    string
+		status string
+		body   string
+	}{
+		{name: "host params merged", req: "GET /users/7 HTTP/1.1\r\nHost: acme.example.test:1783\r\n\r\n", status: "200 OK", body: "id=7;tenant=acme;"},
+		{name: "path params win", req: "GET /users/7 HTTP/1.1\r\nHost: bob.users.test\r\n\r\n", status: "200 OK", body: "id=7;"},
+		{name: "trailing dot", req: "GET /users/7 HTTP/1.1\r\nHost: acme.example.test.\r\n\r\n", status: "200 OK", body: "id=7;tenant=acme;"},
+		{name: "IPv6 literal", req: "GET /users/7 HTTP/1.1\r\nHost: [::1]:1783\r\n\r\n", status: "200 OK", body: "id=7;"},
+		{name: "unknown host", req: "GET / HTTP/1.1\r\nHost: other.test\r\n\r\n", status: "200 OK", body: "default"},
+		{name: "unknown path on a host", req: "GET / HTTP/1.1\r\nHost: acme.example.test\r\n\r\n", status: "404 Not Found", body: "Not Found"},
+		{name: "strict", strict: true, req: "GET / HTTP/1.1\r\nHost: other.test\r\n\r\n", status: "421 Misdirected Request", body: "Misdirected Request"},
+		{name: "strict known host", strict: true, req: "GET /users/7 HTTP/1.1\r\nHost: acme.example.test\r\n\r\n", status: "200 OK", body: "id=7;tenant=acme;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts.Strict = tt.strict
+			ts := newTestServer(t, hosts, nil)
+			conn, err := net.Dial("tcp", ts.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			io.WriteString(conn, tt.req)
+			status, _, body := readResponse(t, bufio.NewReader(conn))
+			if !strings.HasSuffix(status, " "+tt.status) || body != tt.body {
+				t.Errorf("got %s %q, want %s %q", status, body, tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func TestSendFileKeepAlive(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(name, []byte("file body"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const timeout = 100 * time.Millisecond
+	r := router.NewRouter()
+	r.GET("/file", router.WithTimeout(timeout, func(req *request.Request, res *response.Response) {
+		if err := res.SendFilePath(req, name, 0, -1); err != nil {
+			t.Errorf("SendFilePath: %v", err)
+		}
+	}))
+	r.GET("/fast", func(req *request.Request, res *response.Response) {
+		res.WriteString("fast")
+	})
+	ts := newTestServer(t, r, nil)
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	io.WriteString(conn, "GET /file HTTP/1.1\r\nHost: x\r\n\r\n")
+	if _, headers, body := readResponse(t, br); body != "file body" || headers["connection"] != "keep-alive" {
+		t.Fatalf("got %q, Connection: %q", body, headers["connection"])
+	}
+
+	// the handler deadline must not outlive the response it was set for
+	time.Sleep(3 * timeout)
+	io.WriteString(conn, "GET /fast HTTP/1.1\r\nHost: x\r\n\r\n")
+	if _, _, body := readResponse(t, br); body != "fast" {
+		t.Errorf("second request got %q", body)
+	}
+}
+
+// logBuffer collects log output written from the server's goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRequestLoggerRealIP(t *testing.T) {
+	var logs logBuffer
+	r := router.NewRouter()
+	r.GET("/", func(req *request.Request, res *response.Response) {
+		req.Logger.Info("handled")
+		res.WriteString("ok")
+	})
+	tests := []struct {
+		name    string
+		trusted string
+		want    string
+	}{
+		{name: "trusted proxy", trusted: "127.0.0.0/8", want: "real_ip=203.0.113.7"},
+		{name: "untrusted peer", trusted: "10.0.0.0/8", want: "real_ip=127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, trusted, _ := net.ParseCIDR(tt.trusted)
+			ts := newTestServerWith(t, r, nil, func(s *Server) {
+				s.Logger = logger.New(&logs, slog.LevelInfo)
+				s.config.TrustedProxies = []*net.IPNet{trusted}
+			})
+			conn, err := net.Dial("tcp", ts.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nX-Forwarded-For: 203.0.113.7\r\n\r\n")
+			readResponse(t, bufio.NewReader(conn))
+
+			var line string
+			for _, l := range strings.Split(logs.String(), "\n") {
+				if strings.Contains(l, "msg=handled") {
+					line = l
+				}
+			}
+			if !strings.Contains(line, tt.want) || !strings.Contains(line, "remote_addr=127.0.0.1:") {
+				t.Errorf("log line %q, want %s and the peer", line, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutLogCarriesRequestID(t *testing.T) {
+	var logs logBuffer
+	r := router.NewRouter()
+	r.GET("/slow", router.WithTimeout(50*time.Millisecond, func(req *request.Request, res *response.Response) {
+		<-req.Context.Done()
+	}))
+	ts := newTestServerWith(t, r, nil, func(s *Server) {
+		s.Logger = logger.New(&logs, slog.LevelInfo)
+		s.Use(middleware.RequestID())
+	})
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: x\r\nX-Request-ID: req-42\r\n\r\n")
+	if status, _, _ := readResponse(t, bufio.NewReader(conn)); !strings.HasSuffix(status, " 503 Service Unavailable") {
+		t.Fatalf("got %s", status)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for !strings.Contains(logs.String(), "handler timed out") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	for _, l := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(l, "handler timed out") {
+			if !strings.Contains(l, "request_id=req-42") {
+				t.Errorf("timeout logged without the request ID: %q", l)
+			}
+			return
+		}
+	}
+	t.Errorf("no timeout logged in %q", logs.String())
+}
+
+func TestChunkedRequestBody(t *testing.T) {
+	r := router.NewRouter()
+	r.POST("/echo", func(req *request.Request, res *response.Response) {
+		fmt.Fprintf(res, "%s;%s", req.Body, req.Trailers["x-checksum"])
+	})
+	ts := newTestServer(t, r, nil)
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	io.WriteString(conn, "POST /echo HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n"+
+		"POST /echo HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\n\r\nok")
+	if _, _, body := readResponse(t, br); body != "hello world;abc" {
+		t.Errorf("chunked request: got %q", body)
+	}
+	if _, _, body := readResponse(t, br); body != "ok;" {
+		t.Errorf("request after it: got %q", body)
+	}
+
+	io.WriteString(conn, "POST /echo HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: gzip\r\n\r\n")
+	if status, _, _ := readResponse(t, br); !strings.HasSuffix(status, " 400 Bad Request") {
+		t.Errorf("unsupported coding: got %s", status)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	r := router.NewRouter()
+	r.GET("/items/:id", func(req *request.Request, res *response.Response) {
+		res.WriteString(req.Params["id"])
+	})
+	ts := newTestServerWith(t, r, nil, func(s *Server) {
+		r.GET("/metrics", metrics.Handler(s.Metrics()))
+	})
+
+	bad, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	bad.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(bad, "NOT A REQUEST\r\n\r\n")
+	if status, _, _ := readResponse(t, bufio.NewReader(bad)); status != "HTTP/1.1 400 Bad Request" {
+		t.Fatalf("malformed request got %q", status)
+	}
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	for _, path := range []string{"/items/1", "/items/2", "/missing"} {
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+		readResponse(t, br)
+	}
+	io.WriteString(conn, "GET /metrics HTTP/1.1\r\nHost: x\r\n\r\n")
+	_, headers, body := readResponse(t, br)
+	if !strings.HasPrefix(headers["content-type"], "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", headers["content-type"])
+	}
+
+	for _, want := range []string{
+		`http_requests_total{route="/items/:id",method="GET",status="200"} 2`,
+		`http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`http_request_duration_seconds_count{route="/items/:id",method="GET"} 2`,
+		`http_parse_errors_total{type="malformed"} 1`,
+		"http_connections_total 2",
+		"http_response_bytes_total 11",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics lack %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	release, started := make(chan struct{}), make(chan struct{}, 1)
+	ts := newTestServer(t, blockingRouter(release, started), nil)
+	defer close(release)
+
+	admin := ts.newAdminServer()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go admin.Serve(ln)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		admin.Shutdown(ctx)
+	}()
+	get := func(path string) (string, map[string]string, string) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: admin\r\nConnection: close\r\n\r\n")
+		return readResponse(t, bufio.NewReader(conn))
+	}
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /block HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+
+	_, headers, body := get("/debug/connections")
+	var conns []connSnapshot
+	if err := json.Unmarshal([]byte(body), &conns); err != nil || headers["content-type"] != "application/json" {
+		t.Fatalf("connections %q (%s): %v", body, headers["content-type"], err)
+	}
+	if len(conns) != 1 || conns[0].State != "in handler" || conns[0].Requests != 1 || conns[0].RemoteAddr != conn.LocalAddr().String() {
+		t.Errorf("connections %+v", conns)
+	}
+
+	_, _, body = get("/debug/routes")
+	var routes []router.RouteInfo
+	if err := json.Unmarshal([]byte(body), &routes); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(routes) != "[{GET /block } {GET /fast }]" {
+		t.Errorf("routes %v", routes)
+	}
+
+	if status, headers, body := get("/debug/pprof/goroutine"); status != "HTTP/1.1 200 OK" || headers["content-type"] != "application/octet-stream" || body == "" {
+		t.Errorf("goroutine profile: %q %v, %d bytes", status, headers, len(body))
+	}
+	if status, _, _ := get("/debug/pprof/nope"); status != "HTTP/1.1 404 Not Found" {
+		t.Errorf("unknown profile: %q", status)
+	}
+}
+
+// stateRecorder collects the ConnState transitions of a server and signals
+// each terminal one.
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []ConnState
+	done   chan struct{}
+}
+
+func (sr *stateRecorder) record(_ net.Conn, state ConnState) {
+	sr.mu.Lock()
+	sr.states = append(sr.states, state)
+	sr.mu.Unlock()
+	if state == StateClosed || state == StateHijacked {
+		sr.done <- struct{}{}
+	}
+}
+
+func (sr *stateRecorder) wait(t *testing.T) string {
+	t.Helper()
+	select {
+	case <-sr.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection did not reach a terminal state")
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return fmt.Sprint(sr.states)
+}
+
+func TestConnStateHooks(t *testing.T) {
+	r := router.NewRouter()
+	r.GET("/fast", func(req *request.Request, res *response.Response) {
+		res.WriteString("fast")
+	})
+	r.GET("/hijack", func(req *request.Request, res *response.Response) {
+		conn, _, err := res.Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 6\r\nConnection: close\r\n\r\nraw ok")
+		conn.Close()
+	})
+
+	tests := []struct {
+		name     string
+		requests []string
+		accept   bool
+		want     string
+	}{
+		{
+			name:     "keep-alive",
+			requests: []string{"/fast", "/fast", "/fast close"},
+			accept:   true,
+			want:     "[new active idle active idle active closed]",
+		},
+		{
+			name:     "hijacked",
+			requests: []string{"/hijack"},
+			accept:   true,
+			want:     "[new active hijacked]",
+		},
+		{
+			name:   "refused by OnAccept",
+			accept: false,
+			want:   "[new closed]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := &stateRecorder{done: make(chan struct{}, 1)}
+			var completed []string
+			var mu sync.Mutex
+			ts := newTestServerWith(t, r, nil, func(s *Server) {
+				s.ConnState = sr.record
+				s.OnAccept = func(net.Conn) bool { return tt.accept }
+				s.OnRequestComplete = func(_ net.Conn, req *request.Request, res *response.Response) {
+					mu.Lock()
+					completed = append(completed, fmt.Sprintf("%s %d", req.Path, res.Status()))
+					mu.Unlock()
+				}
+			})
+
+			conn, err := net.Dial("tcp", ts.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			br := bufio.NewReader(conn)
+			for _, target := range tt.requests {
+				path, closing := strings.CutSuffix(target, " close")
+				head := "GET " + path + " HTTP/1.1\r\nHost: x\r\n"
+				if closing {
+					head += "Connection: close\r\n"
+				}
+				io.WriteString(conn, head+"\r\n")
+				readResponse(t, br)
+			}
+
+			if got := sr.wait(t); got != tt.want {
+				t.Errorf("states %s, want %s", got, tt.want)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(completed) != len(tt.requests) {
+				t.Errorf("OnRequestComplete saw %v", completed)
+			}
+		})
+	}
+}
+
+func TestDisconnectCancelsRequest(t *testing.T) {
+	started, cancelled := make(chan struct{}), make(chan error, 1)
+	r := router.NewRouter()
+	r.GET("/slow", func(req *request.Request, res *response.Response) {
+		close(started)
+		select {
+		case <-req.Context.Done():
+			cancelled <- req.Context.Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- nil
+		}
+	})
+	ts := newTestServer(t, r, nil)
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+	conn.Close()
+
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Errorf("request context ended with %v", err)
+	}
+}
+
+func TestHandlerTimeouts(t *testing.T) {
+	const limit = 50 * time.Millisecond
+	wait := func(d time.Duration) router.Handler {
+		return func(req *request.Request, res *response.Response) {
+			select {
+			case <-req.Context.Done():
+			case <-time.After(d):
+				res.WriteString("finished")
+			}
+		}
+	}
+	r := router.NewRouter()
+	r.GET("/slow", wait(time.Second))
+	r.GET("/fast", wait(0))
+	r.GET("/lifted", router.Chain(wait(2*limit), router.Timeout(0, 0, nil)))
+	r.GET("/custom", router.Chain(wait(time.Second), router.Timeout(limit/2, 504, []byte("gave up"))))
+	ts := newTestServerWith(t, r, nil, func(s *Server) {
+		s.config.HandlerTimeout = limit
+	})
+
+	tests := []struct {
+		path   string
+		status string
+		body   string
+	}{
+		{path: "/slow", status: "HTTP/1.1 503 Service Unavailable", body: "Service Unavailable"},
+		{path: "/fast", status: "HTTP/1.1 200 OK", body: "finished"},
+		{path: "/lifted", status: "HTTP/1.1 200 OK", body: "finished"},
+		{path: "/custom", status: "HTTP/1.1 504 Gateway Timeout", body: "gave up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			conn, err := net.Dial("tcp", ts.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			io.WriteString(conn, "GET "+tt.path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+			status, _, body := readResponse(t, bufio.NewReader(conn))
+			if status != tt.status || body != tt.body {
+				t.Errorf("got %q %q, want %q %q", status, body, tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func TestHijackHandsOverBufferedBytes(t *testing.T) {
+	r := router.NewRouter()
+	r.GET("/upgrade", func(req *request.Request, res *response.Response) {
+		conn, buffered, err := res.Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		// the client did not wait for the response before talking
+		rest := make([]byte, 4-len(buffered))
+		if _, err := io.ReadFull(conn, rest); err != nil {
+			t.Errorf("reading past the buffered bytes: %v", err)
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n\r\necho "+string(buffered)+string(rest))
+	})
+	ts := newTestServer(t, r, nil)
+
+	conn, err := net.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /upgrade HTTP/1.1\r\nHost: x\r\n\r\nPING")
+
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "HTTP/1.1 101 Switching Protocols\r\n\r\necho PING"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,207 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+)
+
+// DefaultHeartbeat keeps idle streams alive through proxies that drop quiet
+// connections, typically after 60 seconds.
+const DefaultHeartbeat = 15 * time.Second
+
+var ErrClosed = errors.New("sse: stream closed")
+
+// Event is one message of a text/event-stream. Only Data is required.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// Writer streams events over a chunked response. It is safe for concurrent
+// use; a heartbeat goroutine writes comments while the stream is idle.
+// Handlers must call Close before returning.
+type Writer struct {
+	res         *response.Response
+	ctx         context.Context
+	lastEventID string
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+
+	stop chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewWriter sends the event-stream headers and starts heartbeats every
+// heartbeat interval (zero disables them). The stream ends when the request
+// context is cancelled, a write fails or Close is called.
+func NewWriter(req *request.Request, res *response.Response, heartbeat time.Duration) (*Writer, error) {
+	if req.Version == "HTTP/1.0" {
+		return nil, errors.New("sse: streaming requires HTTP/1.1")
+	}
+
+	res.SetHeader("Content-Type", "text/event-stream")
+	res.SetHeader("Cache-Control", "no-cache")
+	res.SetHeader("X-Accel-Buffering", "no")
+	res.SetHeader("Transfer-Encoding", "chunked")
+
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	w := &Writer{
+		res:         res,
+		ctx:         ctx,
+		lastEventID: req.Headers["last-event-id"],
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	// an empty chunk sends just the headers, so the client sees the stream open
+	if err := res.WriteChunk(nil); err != nil {
+		return nil, err
+	}
+
+	w.wg.Add(1)
+	go w.watch(heartbeat)
+	return w, nil
+}
+
+// LastEventID is the ID the client last received before reconnecting, taken
+// from the Last-Event-ID request header; empty on a first connection.
+func (w *Writer) LastEventID() string {
+	return w.lastEventID
+}
+
+// Done is closed once the stream can no longer be written to.
+func (w *Writer) Done() <-chan struct{} {
+	return w.done
+}
+
+func (w *Writer) Send(ev Event) error {
+	if strings.ContainsAny(ev.ID, "\r\n\x00") || strings.ContainsAny(ev.Event, "\r\n") {
+		return errors.New("sse: id and event must be single-line")
+	}
+
+	var b strings.Builder
+	if ev.Event != "" {
+		b.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.ID != "" {
+		b.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(ev.Data)
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	return w.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore.
+func (w *Writer) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(": " + strings.TrimRight(line, "\r") + "\n")
+	}
+	b.WriteString("\n")
+	return w.write(b.String())
+}
+
+// Close stops the heartbeat and ends the response. It is idempotent.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+	}
+	w.mu.Unlock()
+
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		if errors.Is(w.err, ErrClosed) {
+			return nil
+		}
+		return w.err
+	}
+	w.fail(ErrClosed)
+	return w.res.EndChunked()
+}
+
+func (w *Writer) write(s string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.ctx.Err(); err != nil {
+		w.fail(err)
+		return err
+	}
+	if err := w.res.WriteChunk([]byte(s)); err != nil {
+		w.fail(err)
+		return err
+	}
+	return nil
+}
+
+// fail records the first error and releases Done; callers hold w.mu.
+func (w *Writer) fail(err error) {
+	if w.err == nil {
+		w.err = err
+		close(w.done)
+	}
+}
+
+func (w *Writer) watch(heartbeat time.Duration) {
+	defer w.wg.Done()
+
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.done:
+			return
+		case <-w.ctx.Done():
+			w.mu.Lock()
+			w.fail(w.ctx.Err())
+			w.mu.Unlock()
+			return
+		case <-tick:
+			if err := w.Comment("heartbeat"); err != nil {
+				return
+			}
+		}
+	}
+}
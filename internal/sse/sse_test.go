@@ -0,0 +1,149 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/client"
+	"github.com/brutally-Honest/http-server/internal/config"
+	"github.com/brutally-Honest/http-server/internal/logger"
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+	"github.com/brutally-Honest/http-server/internal/router"
+	"github.com/brutally-Honest/http-server/internal/server"
+)
+
+// serveEvents serves h on /events and returns a request for it and a
+// client.
+func serveEvents(t *testing.T, h router.Handler) (*client.Request, *client.Client) {
+	t.Helper()
+	r := router.NewRouter()
+	r.GET("/events", h)
+	cfg := config.Load(4096, 1<<20, 8192, 5*time.Second, 5*time.Second)
+	s := server.NewServer("127.0.0.1:0", cfg, r)
+	s.Logger = logger.Discard()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+
+	c := &client.Client{Timeout: 5 * time.Second}
+	t.Cleanup(func() {
+		c.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	req, err := client.NewRequest(context.Background(), "GET", "http://"+ln.Addr().String()+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req, c
+}
+
+func TestWriter(t *testing.T) {
+	req, c := serveEvents(t, func(req *request.Request, res *response.Response) {
+		w, err := NewWriter(req, res, 0)
+		if err != nil {
+			t.Errorf("NewWriter: %v", err)
+			return
+		}
+		if err := w.Send(Event{ID: "1\n2", Data: "x"}); err == nil {
+			t.Error("multi-line id accepted")
+		}
+		w.Send(Event{ID: "42", Event: "greeting", Data: "hello\nworld", Retry: 3 * time.Second})
+		w.Send(Event{Data: "a\r\nb\rc"})
+		w.Send(Event{Data: "resumed after " + w.LastEventID()})
+		w.Comment("note")
+		if err := w.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("second Close: %v", err)
+		}
+		if err := w.Send(Event{Data: "late"}); err != ErrClosed {
+			t.Errorf("Send after Close: %v", err)
+		}
+		select {
+		case <-w.Done():
+		default:
+			t.Error("Done still open after Close")
+		}
+	})
+	req.Headers["last-event-id"] = "41"
+
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !res.Chunked || res.Headers["content-type"] != "text/event-stream" || res.Headers["cache-control"] != "no-cache" {
+		t.Errorf("headers %v", res.Headers)
+	}
+	want := "event: greeting\nid: 42\nretry: 3000\ndata: hello\ndata: world\n\n" +
+		"data: a\ndata: b\ndata: c\n\n" +
+		"data: resumed after 41\n\n" +
+		": note\n\n"
+	if string(body) != want {
+		t.Errorf("got\n%q\nwant\n%q", body, want)
+	}
+}
+
+func TestWriterHeartbeatAndDisconnect(t *testing.T) {
+	ended := make(chan error, 1)
+	req, c := serveEvents(t, func(req *request.Request, res *response.Response) {
+		w, err := NewWriter(req, res, 10*time.Millisecond)
+		if err != nil {
+			ended <- err
+			return
+		}
+		defer w.Close()
+		select {
+		case <-w.Done():
+			ended <- w.Send(Event{Data: "gone"})
+		case <-time.After(10 * time.Second):
+			ended <- nil
+		}
+	})
+
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(res.Body)
+	for i := 0; i < 2; i++ {
+		if line, err := br.ReadString('\n'); err != nil || line != ": heartbeat\n" {
+			t.Fatalf("got %q, %v, want a heartbeat", line, err)
+		}
+		br.ReadString('\n')
+	}
+
+	// dropping the connection ends the stream on the server side
+	res.Body.Close()
+	c.CloseIdleConnections()
+	select {
+	case err := <-ended:
+		if err == nil {
+			t.Error("Send succeeded after the client went away")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream did not notice the disconnect")
+	}
+}
+
+func TestWriterHTTP10(t *testing.T) {
+	req := &request.Request{Method: "GET", Version: "HTTP/1.0", Headers: map[string]string{}}
+	if _, err := NewWriter(req, nil, 0); err == nil {
+		t.Error("HTTP/1.0 request accepted")
+	}
+}
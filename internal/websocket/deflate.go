@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"sync"
+)
+
+// deflateTail is the empty stored block permessage-deflate strips from the
+// end of every message (RFC 7692 section 7.2.1).
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// inflateTail restores the stripped block and adds a final empty one, so
+// the flate reader sees a complete stream and returns io.EOF.
+var inflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var flateWriters sync.Map // level -> *sync.Pool
+
+// negotiateDeflate picks the first permessage-deflate offer in header that
+// can be served. Both sides drop their context between messages, so every
+// message is compressed on its own; flate's fixed 32KB window rules out
+// offers that shrink the server window.
+func negotiateDeflate(header string) (string, bool) {
+	for _, offer := range strings.Split(header, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+
+		ok := true
+		for _, p := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+			switch strings.TrimSpace(name) {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				ok = ok && strings.Trim(value, `" `) == "15"
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return "permessage-deflate; server_no_context_takeover; client_no_context_takeover", true
+		}
+	}
+	return "", false
+}
+
+func deflate(data []byte, level int) ([]byte, error) {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	pool, _ := flateWriters.LoadOrStore(level, &sync.Pool{})
+
+	var buf bytes.Buffer
+	fw, _ := pool.(*sync.Pool).Get().(*flate.Writer)
+	if fw == nil {
+		var err error
+		if fw, err = flate.NewWriter(&buf, level); err != nil {
+			return nil, err
+		}
+	} else {
+		fw.Reset(&buf)
+	}
+	defer pool.(*sync.Pool).Put(fw)
+
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+// inflate decompresses one message, failing with ErrReadLimit once the
+// output passes limit.
+func inflate(data []byte, limit int64) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(inflateTail)))
+	defer fr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(fr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, ErrReadLimit
+	}
+	return out, nil
+}
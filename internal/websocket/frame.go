@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	maxControlPayload = 125
+	// maxFrameHeader is the largest unmasked header a server writes.
+	maxFrameHeader = 10
+)
+
+type frameHeader struct {
+	fin    bool
+	rsv1   bool
+	rsv2   bool
+	rsv3   bool
+	opcode MessageType
+	masked bool
+	mask   [4]byte
+	length int64
+}
+
+func (c *Conn) readFrameHeader() (frameHeader, error) {
+	var h frameHeader
+	var b [8]byte
+	if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+		return h, err
+	}
+
+	h.fin = b[0]&0x80 != 0
+	h.rsv1 = b[0]&0x40 != 0
+	h.rsv2 = b[0]&0x20 != 0
+	h.rsv3 = b[0]&0x10 != 0
+	h.opcode = MessageType(b[0] & 0x0f)
+	h.masked = b[1]&0x80 != 0
+	h.length = int64(b[1] & 0x7f)
+
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, b[:8]); err != nil {
+			return h, err
+		}
+		n := binary.BigEndian.Uint64(b[:8])
+		if n > 1<<63-1 {
+			return h, errors.New("websocket: frame length overflows")
+		}
+		h.length = int64(n)
+	}
+
+	if h.masked {
+		if _, err := io.ReadFull(c.br, h.mask[:]); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// checkFrame validates a frame header against RFC 6455 section 5 and the
+// negotiated extensions. started says a fragmented message is underway.
+func (c *Conn) checkFrame(h frameHeader, started bool) error {
+	if !h.masked {
+		return errors.New("websocket: client frame is not masked")
+	}
+	if h.rsv2 || h.rsv3 {
+		return errors.New("websocket: reserved bits set")
+	}
+
+	switch h.opcode {
+	case CloseMessage, PingMessage, PongMessage:
+		if !h.fin {
+			return errors.New("websocket: fragmented control frame")
+		}
+		if h.length > maxControlPayload {
+			return errControlTooLong
+		}
+		if h.rsv1 {
+			return errors.New("websocket: compressed control frame")
+		}
+	case TextMessage, BinaryMessage:
+		if started {
+			return errors.New("websocket: new message before the previous one ended")
+		}
+		if h.rsv1 && !c.compress {
+			return errors.New("websocket: RSV1 set without permessage-deflate")
+		}
+	case continuationFrame:
+		if !started {
+			return errors.New("websocket: continuation frame outside a message")
+		}
+		if h.rsv1 {
+			return errors.New("websocket: RSV1 set on a continuation frame")
+		}
+	default:
+		return fmt.Errorf("websocket: unknown opcode %d", h.opcode)
+	}
+	return nil
+}
+
+// readPayload reads the frame body into p, which must be h.length long,
+// and removes the client's mask.
+func (c *Conn) readPayload(h frameHeader, p []byte) error {
+	if _, err := io.ReadFull(c.br, p); err != nil {
+		return err
+	}
+	for i := range p {
+		p[i] ^= h.mask[i&3]
+	}
+	return nil
+}
+
+// appendFrameHeader appends the header of an unmasked server frame.
+func appendFrameHeader(b []byte, fin, rsv1 bool, op MessageType, length int) []byte {
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+
+	switch {
+	case length <= 125:
+		return append(b, b0, byte(length))
+	case length <= 0xffff:
+		b = append(b, b0, 126)
+		return binary.BigEndian.AppendUint16(b, uint16(length))
+	default:
+		b = append(b, b0, 127)
+		return binary.BigEndian.AppendUint64(b, uint64(length))
+	}
+}
@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brutally-Honest/http-server/internal/request"
+	"github.com/brutally-Honest/http-server/internal/response"
+)
+
+// acceptGUID is the key suffix from RFC 6455 section 1.3.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Options tunes Upgrade; the zero value is usable.
+type Options struct {
+	// Subprotocols lists the supported subprotocols in order of preference.
+	Subprotocols []string
+	// CheckOrigin decides whether a browser origin may connect. When nil,
+	// only requests without an Origin or from the same host are accepted.
+	CheckOrigin func(req *request.Request) bool
+	// EnableCompression offers permessage-deflate to clients that ask.
+	EnableCompression bool
+	// CompressionLevel is a compress/flate level; zero uses the default.
+	CompressionLevel int
+	// ReadLimit caps incoming messages; zero uses DefaultReadLimit.
+	ReadLimit int64
+	// WriteTimeout bounds every frame write; zero uses the server's
+	// Config.WriteTimeout.
+	WriteTimeout time.Duration
+}
+
+// Upgrade validates the opening handshake of req, answers 101 Switching
+// Protocols and takes the connection over from the server. On a bad
+// handshake it writes the error response itself and returns an error
+// wrapping ErrBadHandshake. The handler owns the returned Conn and must
+// close it.
+func Upgrade(req *request.Request, res *response.Response, opts *Options) (*Conn, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	fail := func(code int, reason string) (*Conn, error) {
+		res.WriteHeader(code)
+		res.WriteString(reason)
+		return nil, fmt.Errorf("%w: %s", ErrBadHandshake, reason)
+	}
+
+	if req.Method != "GET" {
+		res.SetHeader("Allow", "GET")
+		return fail(405, "method must be GET")
+	}
+	if req.Version != "HTTP/1.1" {
+		return fail(400, "upgrade requires HTTP/1.1")
+	}
+	if !hasToken(req.Headers["connection"], "upgrade") {
+		return fail(400, "missing Connection: Upgrade")
+	}
+	if !hasToken(req.Headers["upgrade"], "websocket") {
+		return fail(400, "missing Upgrade: websocket")
+	}
+	if req.Headers["sec-websocket-version"] != "13" {
+		res.SetHeader("Sec-WebSocket-Version", "13")
+		return fail(426, "unsupported Sec-WebSocket-Version")
+	}
+
+	key := req.Headers["sec-websocket-key"]
+	if raw, err := base64.StdEncoding.DecodeString(key); err != nil || len(raw) != 16 {
+		return fail(400, "invalid Sec-WebSocket-Key")
+	}
+
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return fail(403, "origin not allowed")
+	}
+
+	res.SetHeader("Upgrade", "websocket")
+	res.SetHeader("Connection", "Upgrade")
+	res.SetHeader("Sec-WebSocket-Accept", acceptKey(key))
+
+	subprotocol := selectSubprotocol(req.Headers["sec-websocket-protocol"], opts.Subprotocols)
+	if subprotocol != "" {
+		res.SetHeader("Sec-WebSocket-Protocol", subprotocol)
+	}
+
+	var compress bool
+	if opts.EnableCompression {
+		var ext string
+		if ext, compress = negotiateDeflate(req.Headers["sec-websocket-extensions"]); compress {
+			res.SetHeader("Sec-WebSocket-Extensions", ext)
+		}
+	}
+
+	res.WriteHeader(101)
+	if err := res.Finish(); err != nil {
+		return nil, err
+	}
+	conn, buffered, err := res.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.WriteTimeout == 0 {
+		copied := *opts
+		copied.WriteTimeout = res.Cfg.WriteTimeout
+		opts = &copied
+	}
+
+	c := newConn(conn, buffered, opts)
+	c.subprotocol = subprotocol
+	c.compress = compress
+	c.writeCompress = compress
+	return c, nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// hasToken reports whether the comma-separated header value contains
+// token, ignoring case.
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func selectSubprotocol(offered string, supported []string) string {
+	for _, want := range supported {
+		if hasToken(offered, want) {
+			return want
+		}
+	}
+	return ""
+}
+
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers["origin"]
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Headers["host"])
+}
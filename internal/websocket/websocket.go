@@ -0,0 +1,433 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the opcode of a message or control frame.
+type MessageType int
+
+const (
+	continuationFrame MessageType = 0
+
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+	CloseMessage  MessageType = 8
+	PingMessage   MessageType = 9
+	PongMessage   MessageType = 10
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+// DefaultReadLimit caps incoming messages, after decompression, when
+// Options.ReadLimit is unset.
+const DefaultReadLimit = 1 << 20
+
+// closeTimeout bounds how long Close waits for the peer to answer.
+const closeTimeout = 5 * time.Second
+
+var (
+	ErrBadHandshake   = errors.New("websocket: bad handshake")
+	ErrReadLimit      = errors.New("websocket: message exceeds read limit")
+	ErrCloseSent      = errors.New("websocket: close already sent")
+	ErrInvalidUTF8    = errors.New("websocket: invalid UTF-8 in text message")
+	ErrBadMessageType = errors.New("websocket: message type must be text or binary")
+	errControlTooLong = errors.New("websocket: control frame payload exceeds 125 bytes")
+)
+
+// CloseError is returned by ReadMessage once the peer closed the
+// connection; Code is CloseNoStatusReceived if it gave no code.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// Conn is an upgraded WebSocket connection. One goroutine may read while
+// others write: writes are serialised, and ReadMessage answers pings and
+// close frames itself.
+type Conn struct {
+	conn         net.Conn
+	br           *bufio.Reader
+	subprotocol  string
+	writeTimeout time.Duration
+	readLimit    int64
+
+	// compress is set when permessage-deflate was negotiated; writeCompress
+	// decides whether outgoing messages use it.
+	compress      bool
+	writeCompress bool
+	level         int
+
+	writeMu   sync.Mutex
+	closeSent bool
+
+	readErr   error
+	closeOnce sync.Once
+
+	// OnPong, when set, receives the payload of every pong the peer sends,
+	// typically to extend a read deadline.
+	OnPong func(data []byte)
+}
+
+func newConn(conn net.Conn, buffered []byte, opts *Options) *Conn {
+	limit := opts.ReadLimit
+	if limit <= 0 {
+		limit = DefaultReadLimit
+	}
+	return &Conn{
+		conn:         conn,
+		br:           bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn)),
+		writeTimeout: opts.WriteTimeout,
+		readLimit:    limit,
+		level:        opts.CompressionLevel,
+	}
+}
+
+// Subprotocol reports the subprotocol agreed on during the handshake.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Compressed reports whether permessage-deflate was negotiated.
+func (c *Conn) Compressed() bool {
+	return c.compress
+}
+
+// EnableWriteCompression turns compression of outgoing messages on or off;
+// it has no effect unless permessage-deflate was negotiated.
+func (c *Conn) EnableWriteCompression(enable bool) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.writeCompress = enable && c.compress
+}
+
+// SetReadLimit caps the size of incoming messages. A larger message closes
+// the connection with CloseMessageTooBig.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline bounds the next reads; a connection has none by default.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// ReadMessage returns the next text or binary message, reassembling
+// fragments and decompressing it as needed. Pings are answered and pongs
+// passed to OnPong along the way. Protocol violations close the connection
+// with the matching code; once the peer closes, the error is a *CloseError.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+
+	var (
+		typ        MessageType
+		msg        []byte
+		compressed bool
+		started    bool
+	)
+	for {
+		h, err := c.readFrameHeader()
+		if err != nil {
+			return 0, nil, c.abort(err)
+		}
+		if err := c.checkFrame(h, started); err != nil {
+			return 0, nil, c.fail(CloseProtocolError, err)
+		}
+
+		if h.opcode >= CloseMessage {
+			payload := make([]byte, h.length)
+			if err := c.readPayload(h, payload); err != nil {
+				return 0, nil, c.abort(err)
+			}
+			if err := c.handleControl(h.opcode, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		if h.opcode != continuationFrame {
+			typ = h.opcode
+			compressed = h.rsv1
+			started = true
+		}
+		if int64(len(msg))+h.length > c.readLimit {
+			return 0, nil, c.fail(CloseMessageTooBig, ErrReadLimit)
+		}
+
+		n := len(msg)
+		msg = append(msg, make([]byte, h.length)...)
+		if err := c.readPayload(h, msg[n:]); err != nil {
+			return 0, nil, c.abort(err)
+		}
+		if h.fin {
+			break
+		}
+	}
+
+	if compressed {
+		var err error
+		if msg, err = inflate(msg, c.readLimit); err != nil {
+			if errors.Is(err, ErrReadLimit) {
+				return 0, nil, c.fail(CloseMessageTooBig, err)
+			}
+			return 0, nil, c.fail(CloseInvalidPayload, err)
+		}
+	}
+
+	if typ == TextMessage && !utf8.Valid(msg) {
+		return 0, nil, c.fail(CloseInvalidPayload, ErrInvalidUTF8)
+	}
+	return typ, msg, nil
+}
+
+func (c *Conn) handleControl(op MessageType, payload []byte) error {
+	switch op {
+	case PingMessage:
+		if err := c.writeFrame(true, false, PongMessage, payload); err != nil && !errors.Is(err, ErrCloseSent) {
+			return c.abort(err)
+		}
+	case PongMessage:
+		if c.OnPong != nil {
+			c.OnPong(payload)
+		}
+	case CloseMessage:
+		closeErr, err := parseClosePayload(payload)
+		if err != nil {
+			code := CloseProtocolError
+			if errors.Is(err, ErrInvalidUTF8) {
+				code = CloseInvalidPayload
+			}
+			return c.fail(code, err)
+		}
+
+		// echo the code back unless we started the closing handshake
+		echo := payload
+		if len(echo) > 2 {
+			echo = echo[:2]
+		}
+		c.writeFrame(true, false, CloseMessage, echo)
+		c.closeConn()
+		c.readErr = closeErr
+		return closeErr
+	}
+	return nil
+}
+
+func parseClosePayload(payload []byte) (*CloseError, error) {
+	switch {
+	case len(payload) == 0:
+		return &CloseError{Code: CloseNoStatusReceived}, nil
+	case len(payload) == 1:
+		return nil, errors.New("websocket: truncated close code")
+	}
+
+	code := int(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return nil, fmt.Errorf("websocket: invalid close code %d", code)
+	}
+	if !utf8.Valid(payload[2:]) {
+		return nil, ErrInvalidUTF8
+	}
+	return &CloseError{Code: code, Text: string(payload[2:])}, nil
+}
+
+// validCloseCode reports whether code may appear in a close frame; 1005,
+// 1006 and 1015 are reserved for reporting only.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// WriteMessage sends data as a single text or binary frame, compressed when
+// permessage-deflate is in use. Text messages must be valid UTF-8.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return ErrBadMessageType
+	}
+
+	c.writeMu.Lock()
+	compress := c.writeCompress
+	c.writeMu.Unlock()
+
+	if compress {
+		payload, err := deflate(data, c.level)
+		if err != nil {
+			return err
+		}
+		return c.writeFrame(true, true, typ, payload)
+	}
+	return c.writeFrame(true, false, typ, data)
+}
+
+// NextWriter returns a writer for one message sent in fragments: every
+// Write goes out as a frame and Close ends the message. Compressed
+// messages are held back and sent whole on Close. Only one message may be
+// in progress at a time; control frames can still be sent meanwhile.
+func (c *Conn) NextWriter(typ MessageType) (io.WriteCloser, error) {
+	if typ != TextMessage && typ != BinaryMessage {
+		return nil, ErrBadMessageType
+	}
+
+	c.writeMu.Lock()
+	compress := c.writeCompress
+	c.writeMu.Unlock()
+	return &messageWriter{c: c, op: typ, compress: compress}, nil
+}
+
+type messageWriter struct {
+	c        *Conn
+	op       MessageType
+	compress bool
+	buf      []byte
+	closed   bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("websocket: write to closed message writer")
+	}
+	if w.compress {
+		w.buf = append(w.buf, p...)
+		return len(p), nil
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.c.writeFrame(false, false, w.op, p); err != nil {
+		return 0, err
+	}
+	w.op = continuationFrame
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if w.compress {
+		payload, err := deflate(w.buf, w.c.level)
+		if err != nil {
+			return err
+		}
+		return w.c.writeFrame(true, true, w.op, payload)
+	}
+	return w.c.writeFrame(true, false, w.op, nil)
+}
+
+// Ping sends a ping; the answer reaches OnPong through ReadMessage.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errControlTooLong
+	}
+	return c.writeFrame(true, false, PingMessage, data)
+}
+
+// WriteClose starts the closing handshake without waiting for the answer,
+// which ReadMessage then reports as a *CloseError.
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		return errControlTooLong
+	}
+	return c.writeFrame(true, false, CloseMessage, payload)
+}
+
+// Close performs the closing handshake: it sends a close frame, waits a
+// few seconds for the peer's, dropping any messages still in flight, and
+// closes the connection. It must not run alongside ReadMessage; a reading
+// goroutine should use WriteClose and let ReadMessage finish the handshake.
+func (c *Conn) Close(code int, reason string) error {
+	if err := c.WriteClose(code, reason); err == nil && c.readErr == nil {
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				break
+			}
+		}
+	}
+	return c.closeConn()
+}
+
+func (c *Conn) writeFrame(fin, rsv1 bool, op MessageType, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if op == CloseMessage {
+		c.closeSent = true
+	}
+
+	buf := appendFrameHeader(make([]byte, 0, maxFrameHeader+len(payload)), fin, rsv1, op, len(payload))
+	buf = append(buf, payload...)
+
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// fail closes the connection with code after a protocol violation and
+// makes err sticky for later reads.
+func (c *Conn) fail(code int, err error) error {
+	c.WriteClose(code, "")
+	return c.abort(err)
+}
+
+// abort closes the connection without a handshake, after a read failed.
+func (c *Conn) abort(err error) error {
+	c.closeConn()
+	if c.readErr == nil {
+		c.readErr = err
+	}
+	return c.readErr
+}
+
+func (c *Conn) closeConn() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.conn.Close()
+	})
+	return err
+}
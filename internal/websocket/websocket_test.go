@@ -0,0 +1,470 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeConn replays what the client sent and records what the server wrote.
+type fakeConn struct {
+	in     *bytes.Reader
+	out    bytes.Buffer
+	closed bool
+}
+
+func (c *fakeConn) Read(p []byte) (int, error) {
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	return c.in.Read(p)
+}
+
+func (c *fakeConn) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	return c.out.Write(p)
+}
+
+func (c *fakeConn) Close() error                     { c.closed = true; return nil }
+func (c *fakeConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (c *fakeConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (c *fakeConn) SetDeadline(time.Time) error      { return nil }
+func (c *fakeConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *fakeConn) SetWriteDeadline(time.Time) error { return nil }
+
+func newTestConn(client []byte, opts *Options) (*Conn, *fakeConn) {
+	fc := &fakeConn{in: bytes.NewReader(client)}
+	if opts == nil {
+		opts = &Options{}
+	}
+	return newConn(fc, nil, opts), fc
+}
+
+// clientFrame builds a masked frame as a client sends it.
+func clientFrame(fin, rsv1 bool, op MessageType, payload []byte) []byte {
+	b := appendFrameHeader(nil, fin, rsv1, op, len(payload))
+	b[1] |= 0x80
+	mask := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	b = append(b, mask[:]...)
+	for i, c := range payload {
+		b = append(b, c^mask[i&3])
+	}
+	return b
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+type serverFrame struct {
+	fin, rsv1 bool
+	op        MessageType
+	payload   []byte
+}
+
+// serverFrames parses the unmasked frames the server wrote.
+func serverFrames(t *testing.T, out []byte) []serverFrame {
+	t.Helper()
+	c := &Conn{br: bufio.NewReader(bytes.NewReader(out))}
+	var frames []serverFrame
+	for {
+		h, err := c.readFrameHeader()
+		if errors.Is(err, io.EOF) {
+			return frames
+		}
+		if err != nil {
+			t.Fatalf("reading server frame: %v", err)
+		}
+		if h.masked {
+			t.Fatal("server frame is masked")
+		}
+		p := make([]byte, h.length)
+		if _, err := io.ReadFull(c.br, p); err != nil {
+			t.Fatalf("reading server payload: %v", err)
+		}
+		frames = append(frames, serverFrame{h.fin, h.rsv1, h.opcode, p})
+	}
+}
+
+func TestFrameHeaderRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 125, 126, 0xffff, 0x10000, 1 << 20} {
+		b := appendFrameHeader(nil, true, false, BinaryMessage, n)
+		c := &Conn{br: bufio.NewReader(bytes.NewReader(b))}
+		h, err := c.readFrameHeader()
+		if err != nil {
+			t.Fatalf("length %d: %v", n, err)
+		}
+		if h.length != int64(n) || !h.fin || h.opcode != BinaryMessage || h.masked {
+			t.Errorf("length %d: got %+v", n, h)
+		}
+	}
+}
+
+func TestFrameHeaderLengthOverflow(t *testing.T) {
+	b := []byte{0x82, 127, 0x80, 0, 0, 0, 0, 0, 0, 0}
+	c := &Conn{br: bufio.NewReader(bytes.NewReader(b))}
+	if _, err := c.readFrameHeader(); err == nil {
+		t.Fatal("64-bit length with the high bit set was accepted")
+	}
+}
+
+// TestReadMaskedHello uses the single-frame masked example of RFC 6455
+// section 5.7.
+func TestReadMaskedHello(t *testing.T) {
+	frame := []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}
+	c, _ := newTestConn(frame, nil)
+	typ, msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != TextMessage || string(msg) != "Hello" {
+		t.Errorf("got %d %q, want text \"Hello\"", typ, msg)
+	}
+}
+
+func TestReadFragmentedWithPing(t *testing.T) {
+	var in []byte
+	in = append(in, clientFrame(false, false, TextMessage, []byte("Hel"))...)
+	in = append(in, clientFrame(true, false, PingMessage, []byte("are you there"))...)
+	in = append(in, clientFrame(false, false, continuationFrame, []byte("l"))...)
+	in = append(in, clientFrame(true, false, continuationFrame, []byte("o"))...)
+	in = append(in, clientFrame(true, false, BinaryMessage, []byte{1, 2, 3})...)
+
+	var pongs []string
+	c, fc := newTestConn(in, nil)
+	c.OnPong = func(p []byte) { pongs = append(pongs, string(p)) }
+
+	typ, msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != TextMessage || string(msg) != "Hello" {
+		t.Errorf("got %d %q, want text \"Hello\"", typ, msg)
+	}
+	typ, msg, err = c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != BinaryMessage || !bytes.Equal(msg, []byte{1, 2, 3}) {
+		t.Errorf("got %d %v, want binary [1 2 3]", typ, msg)
+	}
+
+	frames := serverFrames(t, fc.out.Bytes())
+	if len(frames) != 1 || frames[0].op != PongMessage || string(frames[0].payload) != "are you there" {
+		t.Errorf("server wrote %+v, want one pong echoing the ping", frames)
+	}
+	if len(pongs) != 0 {
+		t.Errorf("OnPong saw %q for a ping", pongs)
+	}
+}
+
+func TestOnPong(t *testing.T) {
+	in := append(clientFrame(true, false, PongMessage, []byte("tick")),
+		clientFrame(true, false, TextMessage, []byte("x"))...)
+	c, _ := newTestConn(in, nil)
+	var got string
+	c.OnPong = func(p []byte) { got = string(p) }
+	if _, _, err := c.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if got != "tick" {
+		t.Errorf("OnPong got %q, want \"tick\"", got)
+	}
+}
+
+// TestProtocolViolations follows the framing and UTF-8 cases of the
+// Autobahn test suite: each one must close with the given code.
+func TestProtocolViolations(t *testing.T) {
+	unmasked := appendFrameHeader(nil, true, false, TextMessage, 2)
+	unmasked = append(unmasked, "hi"...)
+
+	tests := []struct {
+		name string
+		in   [][]byte
+		code int
+	}{
+		{"unmasked frame", [][]byte{unmasked}, CloseProtocolError},
+		{"rsv2 set", [][]byte{func() []byte {
+			b := clientFrame(true, false, TextMessage, []byte("x"))
+			b[0] |= 0x20
+			return b
+		}()}, CloseProtocolError},
+		{"rsv1 without deflate", [][]byte{clientFrame(true, true, TextMessage, []byte("x"))}, CloseProtocolError},
+		{"reserved opcode", [][]byte{clientFrame(true, false, 3, nil)}, CloseProtocolError},
+		{"reserved control opcode", [][]byte{clientFrame(true, false, 11, nil)}, CloseProtocolError},
+		{"fragmented ping", [][]byte{clientFrame(false, false, PingMessage, nil)}, CloseProtocolError},
+		{"ping of 126 bytes", [][]byte{clientFrame(true, false, PingMessage, make([]byte, 126))}, CloseProtocolError},
+		{"continuation first", [][]byte{clientFrame(true, false, continuationFrame, []byte("x"))}, CloseProtocolError},
+		{"text inside a fragmented message", [][]byte{
+			clientFrame(false, false, TextMessage, []byte("a")),
+			clientFrame(true, false, TextMessage, []byte("b")),
+		}, CloseProtocolError},
+		{"invalid UTF-8", [][]byte{clientFrame(true, false, TextMessage, []byte{0xce, 0xba, 0xe1, 0xbd})}, CloseInvalidPayload},
+		{"UTF-8 split invalid across fragments", [][]byte{
+			clientFrame(false, false, TextMessage, []byte{0xce}),
+			clientFrame(true, false, continuationFrame, []byte{0x41}),
+		}, CloseInvalidPayload},
+		{"close with one byte", [][]byte{clientFrame(true, false, CloseMessage, []byte{0x03})}, CloseProtocolError},
+		{"close with reserved code 1005", [][]byte{clientFrame(true, false, CloseMessage, closePayload(1005, ""))}, CloseProtocolError},
+		{"close with code 999", [][]byte{clientFrame(true, false, CloseMessage, closePayload(999, ""))}, CloseProtocolError},
+		{"close with invalid UTF-8 reason", [][]byte{clientFrame(true, false, CloseMessage, closePayload(1000, "\xff"))}, CloseInvalidPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, fc := newTestConn(bytes.Join(tt.in, nil), nil)
+			if _, _, err := c.ReadMessage(); err == nil {
+				t.Fatal("ReadMessage succeeded")
+			}
+			if !fc.closed {
+				t.Error("connection left open")
+			}
+			frames := serverFrames(t, fc.out.Bytes())
+			if len(frames) != 1 || frames[0].op != CloseMessage {
+				t.Fatalf("server wrote %+v, want a close frame", frames)
+			}
+			if code := int(binary.BigEndian.Uint16(frames[0].payload)); code != tt.code {
+				t.Errorf("close code %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	in := append(clientFrame(false, false, BinaryMessage, make([]byte, 60)),
+		clientFrame(true, false, continuationFrame, make([]byte, 60))...)
+	c, fc := newTestConn(in, &Options{ReadLimit: 100})
+	if _, _, err := c.ReadMessage(); !errors.Is(err, ErrReadLimit) {
+		t.Fatalf("got %v, want ErrReadLimit", err)
+	}
+	frames := serverFrames(t, fc.out.Bytes())
+	if len(frames) != 1 || binary.BigEndian.Uint16(frames[0].payload) != CloseMessageTooBig {
+		t.Errorf("server wrote %+v, want close 1009", frames)
+	}
+	// the error sticks
+	if _, _, err := c.ReadMessage(); !errors.Is(err, ErrReadLimit) {
+		t.Errorf("second read got %v, want ErrReadLimit", err)
+	}
+}
+
+func TestCloseHandshakeFromClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    CloseError
+		echo    []byte
+	}{
+		{"code and reason", closePayload(1000, "bye"), CloseError{Code: 1000, Text: "bye"}, closePayload(1000, "")},
+		{"application code", closePayload(4000, ""), CloseError{Code: 4000}, closePayload(4000, "")},
+		{"empty", nil, CloseError{Code: CloseNoStatusReceived}, []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := append(clientFrame(true, false, CloseMessage, tt.payload),
+				clientFrame(true, false, TextMessage, []byte("after close"))...)
+			c, fc := newTestConn(in, nil)
+			_, _, err := c.ReadMessage()
+			var ce *CloseError
+			if !errors.As(err, &ce) || *ce != tt.want {
+				t.Fatalf("got %v, want %v", err, &tt.want)
+			}
+			if !fc.closed {
+				t.Error("connection left open after the close handshake")
+			}
+			frames := serverFrames(t, fc.out.Bytes())
+			if len(frames) != 1 || frames[0].op != CloseMessage || !bytes.Equal(frames[0].payload, tt.echo) {
+				t.Errorf("server wrote %+v, want close echoing %v", frames, tt.echo)
+			}
+			if _, _, err := c.ReadMessage(); !errors.As(err, &ce) {
+				t.Errorf("read after close got %v, want the close error again", err)
+			}
+		})
+	}
+}
+
+func TestCloseHandshakeFromServer(t *testing.T) {
+	// the client still had a message in flight when it saw our close
+	in := append(clientFrame(true, false, TextMessage, []byte("late")),
+		clientFrame(true, false, CloseMessage, closePayload(1001, ""))...)
+	c, fc := newTestConn(in, nil)
+	if err := c.Close(CloseGoingAway, "restarting"); err != nil {
+		t.Fatal(err)
+	}
+	if !fc.closed {
+		t.Error("connection left open")
+	}
+	frames := serverFrames(t, fc.out.Bytes())
+	if len(frames) != 1 || !bytes.Equal(frames[0].payload, closePayload(1001, "restarting")) {
+		t.Errorf("server wrote %+v, want a single close 1001", frames)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("x")); !errors.Is(err, ErrCloseSent) {
+		t.Errorf("write after close got %v, want ErrCloseSent", err)
+	}
+}
+
+func TestControlFrameLimits(t *testing.T) {
+	c, fc := newTestConn(nil, nil)
+	if err := c.Ping(make([]byte, 126)); !errors.Is(err, errControlTooLong) {
+		t.Errorf("Ping(126 bytes) got %v", err)
+	}
+	if err := c.WriteClose(1000, strings.Repeat("x", 124)); !errors.Is(err, errControlTooLong) {
+		t.Errorf("WriteClose with a 126 byte payload got %v", err)
+	}
+	if err := c.Ping(make([]byte, 125)); err != nil {
+		t.Errorf("Ping(125 bytes) got %v", err)
+	}
+	if err := c.WriteMessage(PingMessage, nil); !errors.Is(err, ErrBadMessageType) {
+		t.Errorf("WriteMessage(ping) got %v", err)
+	}
+	frames := serverFrames(t, fc.out.Bytes())
+	if len(frames) != 1 || frames[0].op != PingMessage || len(frames[0].payload) != 125 {
+		t.Errorf("server wrote %+v, want one 125 byte ping", frames)
+	}
+}
+
+func TestNextWriterFragments(t *testing.T) {
+	c, fc := newTestConn(nil, nil)
+	w, err := c.NextWriter(TextMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "ab")
+	c.Ping([]byte("p")) // control frames may interleave
+	io.WriteString(w, "cd")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []serverFrame{
+		{false, false, TextMessage, []byte("ab")},
+		{true, false, PingMessage, []byte("p")},
+		{false, false, continuationFrame, []byte("cd")},
+		{true, false, continuationFrame, []byte{}},
+	}
+	got := serverFrames(t, fc.out.Bytes())
+	if len(got) != len(want) {
+		t.Fatalf("got %d frames, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].fin != want[i].fin || got[i].op != want[i].op || !bytes.Equal(got[i].payload, want[i].payload) {
+			t.Errorf("frame %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNegotiateDeflate(t *testing.T) {
+	tests := []struct {
+		offer string
+		ok    bool
+	}{
+		{"permessage-deflate", true},
+		{"permessage-deflate; client_max_window_bits", true},
+		{"permessage-deflate; server_max_window_bits=15", true},
+		{`permessage-deflate; server_max_window_bits="15"`, true},
+		{"permessage-deflate; server_max_window_bits=10", false},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", true},
+		{"permessage-deflate; mystery", false},
+		{"x-webkit-deflate-frame", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		ext, ok := negotiateDeflate(tt.offer)
+		if ok != tt.ok {
+			t.Errorf("%q: ok = %v, want %v", tt.offer, ok, tt.ok)
+		}
+		// neither side may keep its context, see negotiateDeflate
+		if ok && ext != "permessage-deflate; server_no_context_takeover; client_no_context_takeover" {
+			t.Errorf("%q: answered %q", tt.offer, ext)
+		}
+	}
+}
+
+func TestCompressedMessages(t *testing.T) {
+	msg := []byte(strings.Repeat("compress me please ", 50))
+	payload, err := deflate(msg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasSuffix(payload, deflateTail) {
+		t.Error("deflate left the 00 00 ff ff tail on")
+	}
+
+	// a compressed message split over two frames; RSV1 only on the first
+	in := append(clientFrame(false, true, TextMessage, payload[:10]),
+		clientFrame(true, false, continuationFrame, payload[10:])...)
+	c, fc := newTestConn(in, nil)
+	c.compress, c.writeCompress = true, true
+
+	typ, got, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != TextMessage || !bytes.Equal(got, msg) {
+		t.Errorf("inflated %q", got)
+	}
+
+	// without context takeover every message inflates on its own
+	c.WriteMessage(TextMessage, msg)
+	c.WriteMessage(TextMessage, msg)
+	frames := serverFrames(t, fc.out.Bytes())
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+	for i, f := range frames {
+		if !f.rsv1 {
+			t.Errorf("frame %d: RSV1 not set", i)
+		}
+		out, err := inflate(f.payload, 1<<20)
+		if err != nil || !bytes.Equal(out, msg) {
+			t.Errorf("frame %d: inflate got %v, %d bytes", i, err, len(out))
+		}
+	}
+	if !bytes.Equal(frames[0].payload, frames[1].payload) {
+		t.Error("second message depends on the first one's context")
+	}
+}
+
+func TestCompressedReadLimit(t *testing.T) {
+	payload, err := deflate(make([]byte, 1<<16), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, fc := newTestConn(clientFrame(true, true, BinaryMessage, payload), &Options{ReadLimit: 1024})
+	c.compress = true
+	if _, _, err := c.ReadMessage(); !errors.Is(err, ErrReadLimit) {
+		t.Fatalf("got %v, want ErrReadLimit", err)
+	}
+	frames := serverFrames(t, fc.out.Bytes())
+	if len(frames) != 1 || binary.BigEndian.Uint16(frames[0].payload) != CloseMessageTooBig {
+		t.Errorf("server wrote %+v, want close 1009", frames)
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// RFC 6455 section 1.3
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("acceptKey = %q", got)
+	}
+}
+
+func TestValidCloseCode(t *testing.T) {
+	valid := []int{1000, 1001, 1002, 1003, 1007, 1008, 1009, 1010, 1011, 1012, 1013, 1014, 3000, 4999}
+	invalid := []int{0, 999, 1004, 1005, 1006, 1015, 1016, 2000, 2999, 5000, 65535}
+	for _, code := range valid {
+		if !validCloseCode(code) {
+			t.Errorf("%d rejected", code)
+		}
+	}
+	for _, code := range invalid {
+		if validCloseCode(code) {
+			t.Errorf("%d accepted", code)
+		}
+	}
+}